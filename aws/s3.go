@@ -2,28 +2,69 @@ package aws
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	iiiferrors "github.com/thisisaaronland/go-iiif/errors"
 	_ "log"
+	"mime"
 	"os/user"
+	"path"
 	"path/filepath"
 	"strings"
 )
 
 type S3Connection struct {
-	service *s3.S3
-	bucket  string
-	prefix  string
+	service       *s3.S3
+	bucket        string
+	prefix        string
+	acl           string
+	storage_class string
+	sse           string
+	kms_key_id    string
+	cache_control string
 }
 
+// S3Config is the configuration for an S3Connection. Endpoint and PathStyle
+// are for S3-compatible services like MinIO. ACL, StorageClass, the server
+// side encryption settings and CacheControl are applied to every object that
+// is written; ACL defaults to "public-read" (for backwards compatibility) and
+// may be "none" for buckets that don't allow ACLs.
+
 type S3Config struct {
-	Bucket      string
-	Prefix      string
-	Region      string
-	Credentials string // see notes below
+	Bucket               string
+	Prefix               string
+	Region               string
+	Credentials          string // see notes below
+	Endpoint             string
+	PathStyle            bool
+	ACL                  string
+	StorageClass         string
+	ServerSideEncryption string
+	KMSKeyID             string
+	CacheControl         string
+}
+
+const DefaultACL = s3.ObjectCannedACLPublicRead
+
+// IIIF formats that the mime package may not know about, depending on the
+// operating system
+
+var contentTypes = map[string]string{
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".gif":  "image/gif",
+	".tif":  "image/tiff",
+	".tiff": "image/tiff",
+	".webp": "image/webp",
+	".jp2":  "image/jp2",
+	".pdf":  "application/pdf",
+	".json": "application/json",
 }
 
 func NewS3Connection(s3cfg S3Config) (*S3Connection, error) {
@@ -31,8 +72,25 @@ func NewS3Connection(s3cfg S3Config) (*S3Connection, error) {
 	// https://docs.aws.amazon.com/sdk-for-go/v1/developerguide/configuring-sdk.html
 	// https://docs.aws.amazon.com/sdk-for-go/api/service/s3/
 
+	region := s3cfg.Region
+
+	// S3-compatible services generally don't care about regions but the
+	// request signer does
+
+	if region == "" && s3cfg.Endpoint != "" {
+		region = "us-east-1"
+	}
+
 	cfg := aws.NewConfig()
-	cfg.WithRegion(s3cfg.Region)
+	cfg.WithRegion(region)
+
+	if s3cfg.Endpoint != "" {
+		cfg.WithEndpoint(s3cfg.Endpoint)
+	}
+
+	if s3cfg.PathStyle {
+		cfg.WithS3ForcePathStyle(true)
+	}
 
 	if strings.HasPrefix(s3cfg.Credentials, "env:") {
 
@@ -95,10 +153,25 @@ func NewS3Connection(s3cfg S3Config) (*S3Connection, error) {
 
 	service := s3.New(sess)
 
+	acl := s3cfg.ACL
+
+	if acl == "" {
+		acl = DefaultACL
+	}
+
+	if acl == "none" {
+		acl = ""
+	}
+
 	c := S3Connection{
-		service: service,
-		bucket:  s3cfg.Bucket,
-		prefix:  s3cfg.Prefix,
+		service:       service,
+		bucket:        s3cfg.Bucket,
+		prefix:        s3cfg.Prefix,
+		acl:           acl,
+		storage_class: s3cfg.StorageClass,
+		sse:           s3cfg.ServerSideEncryption,
+		kms_key_id:    s3cfg.KMSKeyID,
+		cache_control: s3cfg.CacheControl,
 	}
 
 	return &c, nil
@@ -106,6 +179,11 @@ func NewS3Connection(s3cfg S3Config) (*S3Connection, error) {
 
 func (conn *S3Connection) Head(key string) (*s3.HeadObjectOutput, error) {
 
+	return conn.HeadWithContext(context.Background(), key)
+}
+
+func (conn *S3Connection) HeadWithContext(ctx context.Context, key string) (*s3.HeadObjectOutput, error) {
+
 	key = conn.prepareKey(key)
 
 	params := &s3.HeadObjectInput{
@@ -113,10 +191,16 @@ func (conn *S3Connection) Head(key string) (*s3.HeadObjectOutput, error) {
 		Key:    aws.String(key),
 	}
 
-	rsp, err := conn.service.HeadObject(params)
+	// this version of the AWS SDK predates the *WithContext methods so we
+	// attach the context to the underlying HTTP request ourselves
+
+	req, rsp := conn.service.HeadObjectRequest(params)
+	req.HTTPRequest = req.HTTPRequest.WithContext(ctx)
+
+	err := req.Send()
 
 	if err != nil {
-		return nil, err
+		return nil, typedError(key, err)
 	}
 
 	return rsp, nil
@@ -124,6 +208,29 @@ func (conn *S3Connection) Head(key string) (*s3.HeadObjectOutput, error) {
 
 func (conn *S3Connection) Get(key string) ([]byte, error) {
 
+	rsp, err := conn.GetWithContext(context.Background(), key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rsp.Body.Close()
+
+	buf := new(bytes.Buffer)
+	_, err = buf.ReadFrom(rsp.Body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// GetWithContext returns the response for key, whose Body it is the caller's
+// responsibility to close.
+
+func (conn *S3Connection) GetWithContext(ctx context.Context, key string) (*s3.GetObjectOutput, error) {
+
 	key = conn.prepareKey(key)
 
 	params := &s3.GetObjectInput{
@@ -131,33 +238,93 @@ func (conn *S3Connection) Get(key string) ([]byte, error) {
 		Key:    aws.String(key),
 	}
 
-	rsp, err := conn.service.GetObject(params)
+	req, rsp := conn.service.GetObjectRequest(params)
+	req.HTTPRequest = req.HTTPRequest.WithContext(ctx)
+
+	err := req.Send()
 
 	if err != nil {
-		return nil, err
+		return nil, typedError(key, err)
 	}
 
-	buf := new(bytes.Buffer)
-	buf.ReadFrom(rsp.Body)
+	return rsp, nil
+}
 
-	return buf.Bytes(), nil
+// PutOptions are the (optional) details that PutWithContext stores along with
+// a key. Metadata keys are stored by S3 as "x-amz-meta-{KEY}" headers. If
+// ContentType is empty it is inferred from the key's extension and if
+// CacheControl is empty the connection's default is used.
+
+type PutOptions struct {
+	ContentType  string
+	CacheControl string
+	Metadata     map[string]string
 }
 
 func (conn *S3Connection) Put(key string, body []byte) error {
 
+	return conn.PutWithContext(context.Background(), key, body, nil)
+}
+
+func (conn *S3Connection) PutWithContext(ctx context.Context, key string, body []byte, opts *PutOptions) error {
+
 	key = conn.prepareKey(key)
 
 	params := &s3.PutObjectInput{
 		Bucket: aws.String(conn.bucket),
 		Key:    aws.String(key),
 		Body:   bytes.NewReader(body),
-		ACL:    aws.String("public-read"),
 	}
 
-	_, err := conn.service.PutObject(params)
+	if conn.acl != "" {
+		params.ACL = aws.String(conn.acl)
+	}
+
+	if conn.storage_class != "" {
+		params.StorageClass = aws.String(conn.storage_class)
+	}
+
+	if conn.sse != "" {
+		params.ServerSideEncryption = aws.String(conn.sse)
+	}
+
+	if conn.kms_key_id != "" {
+		params.SSEKMSKeyId = aws.String(conn.kms_key_id)
+	}
+
+	content_type := ContentTypeForKey(key)
+	cache_control := conn.cache_control
+
+	if opts != nil {
+
+		if opts.ContentType != "" {
+			content_type = opts.ContentType
+		}
+
+		if opts.CacheControl != "" {
+			cache_control = opts.CacheControl
+		}
+
+		if len(opts.Metadata) > 0 {
+			params.Metadata = aws.StringMap(opts.Metadata)
+		}
+	}
+
+	if content_type != "" {
+		params.ContentType = aws.String(content_type)
+	}
+
+	if cache_control != "" {
+		params.CacheControl = aws.String(cache_control)
+	}
+
+	req, _ := conn.service.PutObjectRequest(params)
+	req.HTTPRequest = req.HTTPRequest.WithContext(ctx)
+
+	err := req.Send()
 
 	if err != nil {
-		return err
+		return typedError(key, err)
 	}
 
 	return nil
@@ -165,6 +332,11 @@ func (conn *S3Connection) Put(key string, body []byte) error {
 
 func (conn *S3Connection) Delete(key string) error {
 
+	return conn.DeleteWithContext(context.Background(), key)
+}
+
+func (conn *S3Connection) DeleteWithContext(ctx context.Context, key string) error {
+
 	key = conn.prepareKey(key)
 
 	params := &s3.DeleteObjectInput{
@@ -172,7 +344,10 @@ func (conn *S3Connection) Delete(key string) error {
 		Key:    aws.String(key),
 	}
 
-	_, err := conn.service.DeleteObject(params)
+	req, _ := conn.service.DeleteObjectRequest(params)
+	req.HTTPRequest = req.HTTPRequest.WithContext(ctx)
+
+	err := req.Send()
 
 	if err != nil {
 		return err
@@ -181,6 +356,75 @@ func (conn *S3Connection) Delete(key string) error {
 	return nil
 }
 
+// List calls fn for every object whose key starts with prefix. The key passed
+// to fn has had the connection's own prefix removed so it can be handed back
+// to Get, Put or Delete as-is.
+
+func (conn *S3Connection) List(ctx context.Context, prefix string, fn func(string, *s3.Object) error) error {
+
+	root := ""
+
+	if conn.prefix != "" {
+		root = strings.TrimSuffix(conn.prefix, "/") + "/"
+	}
+
+	params := &s3.ListObjectsV2Input{
+		Bucket: aws.String(conn.bucket),
+		Prefix: aws.String(root + prefix),
+	}
+
+	for {
+
+		req, rsp := conn.service.ListObjectsV2Request(params)
+		req.HTTPRequest = req.HTTPRequest.WithContext(ctx)
+
+		err := req.Send()
+
+		if err != nil {
+			return typedError(prefix, err)
+		}
+
+		for _, obj := range rsp.Contents {
+
+			key := strings.TrimPrefix(aws.StringValue(obj.Key), root)
+
+			err := fn(key, obj)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		if !aws.BoolValue(rsp.IsTruncated) {
+			break
+		}
+
+		params.ContinuationToken = rsp.NextContinuationToken
+	}
+
+	return nil
+}
+
+// ContentTypeForKey returns the content type for a key based on its extension,
+// or an empty string if it can't be determined.
+
+func ContentTypeForKey(key string) string {
+
+	ext := strings.ToLower(path.Ext(key))
+
+	if ext == "" {
+		return ""
+	}
+
+	t, ok := contentTypes[ext]
+
+	if ok {
+		return t
+	}
+
+	return mime.TypeByExtension(ext)
+}
+
 func (conn *S3Connection) prepareKey(key string) string {
 
 	if conn.prefix == "" {
@@ -189,3 +433,33 @@ func (conn *S3Connection) prepareKey(key string) string {
 
 	return filepath.Join(conn.prefix, key)
 }
+
+// typedError maps the errors that S3 returns for missing keys and for requests
+// that never made it (or that S3 itself choked on) to their go-iiif equivalents
+
+func typedError(key string, err error) error {
+
+	aws_err, ok := err.(awserr.Error)
+
+	if !ok {
+		return err
+	}
+
+	code := aws_err.Code()
+
+	if code == "NoSuchKey" || code == "NotFound" {
+		return iiiferrors.NewNotFoundError(key, err)
+	}
+
+	if code == "RequestError" {
+		return iiiferrors.NewUpstreamUnavailableError("S3", err)
+	}
+
+	rsp_err, ok := err.(awserr.RequestFailure)
+
+	if ok && rsp_err.StatusCode() >= 500 {
+		return iiiferrors.NewUpstreamUnavailableError("S3", err)
+	}
+
+	return err
+}
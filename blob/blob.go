@@ -0,0 +1,149 @@
+package blob
+
+// A Bucket is a place to keep blobs (images, derivatives, whatever) that is
+// named by a URL, for example:
+//
+//	file:///usr/local/iiif/images
+//	mem://scratch
+//	s3://your-bucket/some/prefix?region=us-east-1&credentials=env:
+//
+// The scheme of the URL decides which driver opens the bucket. The file, mem
+// and s3 drivers ship with go-iiif and others (gs:// or azblob:// for example)
+// can be added by registering them, in the same way that sources and caches
+// are, and enabling them with a blank import:
+//
+//	import _ "example.com/your/iiif-blob-driver"
+//
+// Keys always use "/" as a separator, whatever the bucket.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Bucket interface {
+	NewReader(ctx context.Context, key string) (io.ReadCloser, *Attributes, error)
+	Attributes(ctx context.Context, key string) (*Attributes, error)
+	WriteAll(ctx context.Context, key string, body []byte, opts *WriterOptions) error
+	Delete(ctx context.Context, key string) error
+	List(ctx context.Context, prefix string, fn ListFunc) error
+	Close() error
+}
+
+// LocalBucket is implemented by buckets whose Attributes method doesn't have to
+// ask another server, which is to say it's cheap enough to call for every
+// request.
+
+type LocalBucket interface {
+	Local() bool
+}
+
+// IsLocal reports whether b is a LocalBucket that says it is local.
+
+func IsLocal(b Bucket) bool {
+
+	lb, ok := b.(LocalBucket)
+	return ok && lb.Local()
+}
+
+// Attributes are what a bucket knows about a blob. Size is -1 and everything
+// else is its zero value if it isn't known.
+
+type Attributes struct {
+	Size        int64
+	ModTime     time.Time
+	ETag        string
+	ContentType string
+	Metadata    map[string]string
+}
+
+type WriterOptions struct {
+	ContentType string
+	Metadata    map[string]string
+}
+
+// ListFunc is called with the key and (at least the size and modification time
+// of) the attributes of each blob in a listing. Returning an error stops it.
+
+type ListFunc func(key string, attrs *Attributes) error
+
+type BucketOpenFunc func(ctx context.Context, u *url.URL) (Bucket, error)
+
+var drivers_mu = new(sync.RWMutex)
+var drivers = make(map[string]BucketOpenFunc)
+
+// Register makes a driver available for a URL scheme. Like sql.Register it
+// panics if it's called twice with the same scheme or with a nil function.
+
+func Register(scheme string, open_func BucketOpenFunc) {
+
+	drivers_mu.Lock()
+	defer drivers_mu.Unlock()
+
+	if open_func == nil {
+		panic("blob: Register function is nil")
+	}
+
+	_, dupe := drivers[scheme]
+
+	if dupe {
+		panic("blob: Register called twice for scheme " + scheme)
+	}
+
+	drivers[scheme] = open_func
+}
+
+func Schemes() []string {
+
+	drivers_mu.RLock()
+	defer drivers_mu.RUnlock()
+
+	schemes := make([]string, 0)
+
+	for scheme, _ := range drivers {
+		schemes = append(schemes, scheme)
+	}
+
+	sort.Strings(schemes)
+	return schemes
+}
+
+func OpenBucket(ctx context.Context, uri string) (Bucket, error) {
+
+	if uri == "" {
+		return nil, errors.New("Missing blob bucket URL")
+	}
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, err
+	}
+
+	drivers_mu.RLock()
+	open_func, ok := drivers[u.Scheme]
+	drivers_mu.RUnlock()
+
+	if !ok {
+		message := fmt.Sprintf("Unsupported blob scheme '%s', must be one of: %s", u.Scheme, strings.Join(Schemes(), ", "))
+		return nil, errors.New(message)
+	}
+
+	return open_func(ctx, u)
+}
+
+// cleanKey turns key in to a relative path that can't point outside of the
+// bucket it's used with
+
+func cleanKey(key string) string {
+
+	return strings.TrimPrefix(path.Clean("/"+key), "/")
+}
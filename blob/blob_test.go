@@ -0,0 +1,268 @@
+package blob
+
+import (
+	"context"
+	iiiferrors "github.com/thisisaaronland/go-iiif/errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestCleanKey(t *testing.T) {
+
+	tests := map[string]string{
+		"test.jpg":              "test.jpg",
+		"/test.jpg":             "test.jpg",
+		"a/b/../test.jpg":       "a/test.jpg",
+		"../../etc/passwd":      "etc/passwd",
+		"a/../../../etc/passwd": "etc/passwd",
+		"./a//b/./test.jpg":     "a/b/test.jpg",
+		"..":                    "",
+		"":                      "",
+	}
+
+	for key, expected := range tests {
+
+		cleaned := cleanKey(key)
+
+		if cleaned != expected {
+			t.Fatalf("Expected '%s' to be cleaned to '%s', got '%s'", key, expected, cleaned)
+		}
+	}
+}
+
+func TestOpenBucket(t *testing.T) {
+
+	schemes := strings.Join(Schemes(), ",")
+
+	if schemes != "file,mem,s3" {
+		t.Fatalf("Unexpected schemes '%s'", schemes)
+	}
+
+	_, err := OpenBucket(context.Background(), "ftp://example.com/images")
+
+	if err == nil || !strings.Contains(err.Error(), "Unsupported blob scheme") {
+		t.Fatalf("Expected an unsupported scheme to fail, got %v", err)
+	}
+
+	_, err = OpenBucket(context.Background(), "")
+
+	if err == nil {
+		t.Fatal("Expected an empty URL to fail")
+	}
+}
+
+func TestMemBucket(t *testing.T) {
+
+	ctx := context.Background()
+
+	b, err := OpenBucket(ctx, "mem://test-bucket")
+
+	if err != nil {
+		t.Fatalf("Failed to open mem bucket, %s", err)
+	}
+
+	testBucket(t, b)
+
+	// buckets with the same name are the same bucket, and blobs are copied
+	// in and out of them
+
+	b1, _ := OpenBucket(ctx, "mem://test-shared")
+	b2, _ := OpenBucket(ctx, "mem://test-shared")
+	other, _ := OpenBucket(ctx, "mem://test-other")
+
+	body := []byte("hello")
+
+	opts := WriterOptions{
+		Metadata: map[string]string{"source": "test"},
+	}
+
+	err = b1.WriteAll(ctx, "test.jpg", body, &opts)
+
+	if err != nil {
+		t.Fatalf("Failed to write blob, %s", err)
+	}
+
+	body[0] = 'j'
+	opts.Metadata["source"] = "changed"
+
+	attrs, err := b2.Attributes(ctx, "test.jpg")
+
+	if err != nil || attrs.Metadata["source"] != "test" {
+		t.Fatalf("Expected the blob to be unchanged, got %+v %v", attrs, err)
+	}
+
+	attrs.Metadata["source"] = "changed"
+
+	fh, attrs, err := b2.NewReader(ctx, "test.jpg")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := ioutil.ReadAll(fh)
+	fh.Close()
+
+	if string(data) != "hello" || attrs.Metadata["source"] != "test" {
+		t.Fatalf("Expected the blob to be unchanged, got '%s' %+v", data, attrs)
+	}
+
+	_, err = other.Attributes(ctx, "test.jpg")
+
+	if err == nil {
+		t.Fatal("Expected buckets with different names to be different buckets")
+	}
+}
+
+// testBucket checks the behaviour that every bucket should have, using b
+// which should be empty
+
+func testBucket(t *testing.T, b Bucket) {
+
+	ctx := context.Background()
+
+	_, _, err := b.NewReader(ctx, "a/test.jpg")
+
+	if !iiiferrors.IsNotFound(err) {
+		t.Fatalf("Expected a NotFound error reading a missing blob, got %v", err)
+	}
+
+	_, err = b.Attributes(ctx, "a/test.jpg")
+
+	if !iiiferrors.IsNotFound(err) {
+		t.Fatalf("Expected a NotFound error for a missing blob's attributes, got %v", err)
+	}
+
+	opts := WriterOptions{
+		ContentType: "image/jpeg",
+		Metadata: map[string]string{
+			"Iiif-Expires": "2030-01-02T03:04:05Z",
+		},
+	}
+
+	err = b.WriteAll(ctx, "a/test.jpg", []byte("hello"), &opts)
+
+	if err != nil {
+		t.Fatalf("Failed to write blob, %s", err)
+	}
+
+	fh, attrs, err := b.NewReader(ctx, "a/test.jpg")
+
+	if err != nil {
+		t.Fatalf("Failed to read blob, %s", err)
+	}
+
+	body, err := ioutil.ReadAll(fh)
+	fh.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(body) != "hello" {
+		t.Fatalf("Unexpected body '%s'", body)
+	}
+
+	if attrs.Size != 5 || attrs.ModTime.IsZero() || attrs.ETag == "" {
+		t.Fatalf("Unexpected attributes %+v", attrs)
+	}
+
+	if attrs.ContentType != "image/jpeg" || attrs.Metadata["Iiif-Expires"] != "2030-01-02T03:04:05Z" {
+		t.Fatalf("Expected content type and metadata to round-trip, got %+v", attrs)
+	}
+
+	// keys are cleaned so they can't point outside the bucket, and
+	// different spellings of the same key are the same blob
+
+	for _, key := range []string{"/a/test.jpg", "a/b/../test.jpg", "../a/test.jpg"} {
+
+		_, err := b.Attributes(ctx, key)
+
+		if err != nil {
+			t.Fatalf("Expected '%s' to be the same blob as 'a/test.jpg', %s", key, err)
+		}
+	}
+
+	// writing a blob without options replaces its attributes too
+
+	err = b.WriteAll(ctx, "a/test.jpg", []byte("hello, again"), nil)
+
+	if err != nil {
+		t.Fatalf("Failed to write blob, %s", err)
+	}
+
+	attrs, err = b.Attributes(ctx, "a/test.jpg")
+
+	if err != nil {
+		t.Fatalf("Failed to get attributes, %s", err)
+	}
+
+	if attrs.Size != 12 || attrs.ContentType != "" || len(attrs.Metadata) != 0 {
+		t.Fatalf("Expected attributes to be replaced, got %+v", attrs)
+	}
+
+	for _, key := range []string{"a/other.jpg", "b/test.jpg", "ab.jpg"} {
+
+		err := b.WriteAll(ctx, key, []byte(key), nil)
+
+		if err != nil {
+			t.Fatalf("Failed to write %s, %s", key, err)
+		}
+	}
+
+	list := func(prefix string) string {
+
+		keys := make([]string, 0)
+
+		err := b.List(ctx, prefix, func(key string, attrs *Attributes) error {
+
+			if attrs.Size <= 0 {
+				t.Fatalf("Expected %s to be listed with its size", key)
+			}
+
+			keys = append(keys, key)
+			return nil
+		})
+
+		if err != nil {
+			t.Fatalf("Failed to list '%s', %s", prefix, err)
+		}
+
+		return strings.Join(keys, ",")
+	}
+
+	if keys := list("a/"); keys != "a/other.jpg,a/test.jpg" {
+		t.Fatalf("Unexpected keys '%s'", keys)
+	}
+
+	if keys := list(""); keys != "a/other.jpg,a/test.jpg,ab.jpg,b/test.jpg" {
+		t.Fatalf("Unexpected keys '%s'", keys)
+	}
+
+	err = b.Delete(ctx, "a/test.jpg")
+
+	if err != nil {
+		t.Fatalf("Failed to delete blob, %s", err)
+	}
+
+	err = b.Delete(ctx, "a/test.jpg")
+
+	if err != nil {
+		t.Fatalf("Expected deleting a missing blob to succeed, %s", err)
+	}
+
+	_, _, err = b.NewReader(ctx, "a/test.jpg")
+
+	if !iiiferrors.IsNotFound(err) {
+		t.Fatalf("Expected a NotFound error reading a deleted blob, got %v", err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	_, err = b.Attributes(cancelled, "a/other.jpg")
+
+	if err != context.Canceled {
+		t.Fatalf("Expected a cancelled context to fail, got %v", err)
+	}
+}
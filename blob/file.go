@@ -0,0 +1,387 @@
+package blob
+
+// FileBucket keeps blobs as files in a directory, which is the path of its URL:
+//
+//	file:///usr/local/iiif/images
+//
+// The directory must already exist unless the URL has a "create_dir=true"
+// query parameter. Content types and metadata, if there are any, are kept in a
+// JSON "sidecar" file next to each blob whose name ends in ".attrs". Blobs are
+// written to a temporary file first and then renamed so readers never see a
+// partially written blob.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	iiiferrors "github.com/thisisaaronland/go-iiif/errors"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const fileAttrsSuffix = ".attrs"
+const fileTempPrefix = ".iiif-tmp-"
+
+type FileBucket struct {
+	Bucket
+	root string
+}
+
+type fileAttrs struct {
+	ContentType string            `json:"content_type,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+func init() {
+
+	Register("file", func(ctx context.Context, u *url.URL) (Bucket, error) {
+
+		// "file://images" is almost certainly meant to be a relative path
+		// but it's really a host called "images" so don't guess
+
+		if u.Host != "" && u.Host != "localhost" {
+			message := fmt.Sprintf("File bucket URL has a host ('%s'), paths must be absolute", u.Host)
+			return nil, errors.New(message)
+		}
+
+		create := u.Query().Get("create_dir") == "true"
+
+		b, err := NewFileBucket(filepath.FromSlash(u.Path), create)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return b, nil
+	})
+}
+
+func NewFileBucket(root string, create bool) (*FileBucket, error) {
+
+	if root == "" {
+		return nil, errors.New("File bucket has no path")
+	}
+
+	if create {
+
+		err := os.MkdirAll(root, 0755)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	info, err := os.Stat(root)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		message := fmt.Sprintf("File bucket path '%s' is not a directory", root)
+		return nil, errors.New(message)
+	}
+
+	b := FileBucket{
+		root: root,
+	}
+
+	return &b, nil
+}
+
+func (b *FileBucket) NewReader(ctx context.Context, key string) (io.ReadCloser, *Attributes, error) {
+
+	err := ctx.Err()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	abs_path, err := b.path(key)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fh, err := os.Open(abs_path)
+
+	if os.IsNotExist(err) {
+		return nil, nil, iiiferrors.NewNotFoundError(key, err)
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info, err := fh.Stat()
+
+	if err != nil {
+		fh.Close()
+		return nil, nil, err
+	}
+
+	attrs, err := b.attributes(abs_path, info)
+
+	if err != nil {
+		fh.Close()
+		return nil, nil, err
+	}
+
+	return fh, attrs, nil
+}
+
+func (b *FileBucket) Attributes(ctx context.Context, key string) (*Attributes, error) {
+
+	err := ctx.Err()
+
+	if err != nil {
+		return nil, err
+	}
+
+	abs_path, err := b.path(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(abs_path)
+
+	if os.IsNotExist(err) {
+		return nil, iiiferrors.NewNotFoundError(key, err)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return b.attributes(abs_path, info)
+}
+
+func (b *FileBucket) WriteAll(ctx context.Context, key string, body []byte, opts *WriterOptions) error {
+
+	err := ctx.Err()
+
+	if err != nil {
+		return err
+	}
+
+	abs_path, err := b.path(key)
+
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(abs_path), 0755)
+
+	if err != nil {
+		return err
+	}
+
+	// the sidecar is written (or removed) first so that a reader never sees
+	// a new blob with old attributes
+
+	attrs_path := abs_path + fileAttrsSuffix
+
+	if opts != nil && (opts.ContentType != "" || len(opts.Metadata) > 0) {
+
+		attrs := fileAttrs{
+			ContentType: opts.ContentType,
+			Metadata:    opts.Metadata,
+		}
+
+		enc, err := json.Marshal(attrs)
+
+		if err != nil {
+			return err
+		}
+
+		err = writeFileAtomic(attrs_path, enc)
+
+		if err != nil {
+			return err
+		}
+
+	} else {
+
+		err := os.Remove(attrs_path)
+
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return writeFileAtomic(abs_path, body)
+}
+
+func (b *FileBucket) Delete(ctx context.Context, key string) error {
+
+	err := ctx.Err()
+
+	if err != nil {
+		return err
+	}
+
+	abs_path, err := b.path(key)
+
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(abs_path)
+
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	err = os.Remove(abs_path + fileAttrsSuffix)
+
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+func (b *FileBucket) List(ctx context.Context, prefix string, fn ListFunc) error {
+
+	return filepath.Walk(b.root, func(abs_path string, info os.FileInfo, err error) error {
+
+		if err != nil {
+			return err
+		}
+
+		err = ctx.Err()
+
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		name := info.Name()
+
+		if strings.HasSuffix(name, fileAttrsSuffix) || strings.HasPrefix(name, fileTempPrefix) {
+			return nil
+		}
+
+		rel_path, err := filepath.Rel(b.root, abs_path)
+
+		if err != nil {
+			return err
+		}
+
+		key := filepath.ToSlash(rel_path)
+
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		attrs := Attributes{
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		}
+
+		return fn(key, &attrs)
+	})
+}
+
+func (b *FileBucket) Local() bool {
+	return true
+}
+
+func (b *FileBucket) Close() error {
+	return nil
+}
+
+func (b *FileBucket) path(key string) (string, error) {
+
+	key = cleanKey(key)
+
+	if key == "" {
+		return "", errors.New("Invalid blob key")
+	}
+
+	if strings.HasSuffix(key, fileAttrsSuffix) {
+		message := fmt.Sprintf("Invalid blob key '%s', keys can not end in %s", key, fileAttrsSuffix)
+		return "", errors.New(message)
+	}
+
+	return filepath.Join(b.root, filepath.FromSlash(key)), nil
+}
+
+// the ETag is weak because it's derived from the size and modification time
+// rather than the contents of the file
+
+func (b *FileBucket) attributes(abs_path string, info os.FileInfo) (*Attributes, error) {
+
+	attrs := Attributes{
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		ETag:    fmt.Sprintf("W/\"%x-%x\"", info.Size(), info.ModTime().UnixNano()),
+	}
+
+	enc, err := ioutil.ReadFile(abs_path + fileAttrsSuffix)
+
+	if os.IsNotExist(err) {
+		return &attrs, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var sidecar fileAttrs
+
+	err = json.Unmarshal(enc, &sidecar)
+
+	if err != nil {
+		message := fmt.Sprintf("Failed to parse attributes for %s, %s", abs_path, err)
+		return nil, errors.New(message)
+	}
+
+	attrs.ContentType = sidecar.ContentType
+	attrs.Metadata = sidecar.Metadata
+
+	return &attrs, nil
+}
+
+func writeFileAtomic(abs_path string, body []byte) error {
+
+	fh, err := ioutil.TempFile(filepath.Dir(abs_path), fileTempPrefix)
+
+	if err != nil {
+		return err
+	}
+
+	tmp_path := fh.Name()
+
+	_, err = fh.Write(body)
+
+	if err == nil {
+		err = fh.Close()
+	} else {
+		fh.Close()
+	}
+
+	if err == nil {
+		err = os.Chmod(tmp_path, 0644)
+	}
+
+	if err == nil {
+		err = os.Rename(tmp_path, abs_path)
+	}
+
+	if err != nil {
+		os.Remove(tmp_path)
+		return err
+	}
+
+	return nil
+}
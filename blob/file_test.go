@@ -0,0 +1,111 @@
+package blob
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileBucket(t *testing.T) {
+
+	root, err := ioutil.TempDir("", "iiif-blob")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(root)
+
+	ctx := context.Background()
+	bucket_root := filepath.Join(root, "bucket")
+
+	// buckets are only created if they're asked to be
+
+	_, err = OpenBucket(ctx, "file://"+filepath.ToSlash(bucket_root))
+
+	if err == nil {
+		t.Fatal("Expected a missing directory to fail")
+	}
+
+	_, err = OpenBucket(ctx, "file://images")
+
+	if err == nil {
+		t.Fatal("Expected a file URL with a host to fail")
+	}
+
+	u := url.URL{
+		Scheme:   "file",
+		Path:     filepath.ToSlash(bucket_root),
+		RawQuery: "create_dir=true",
+	}
+
+	b, err := OpenBucket(ctx, u.String())
+
+	if err != nil {
+		t.Fatalf("Expected create_dir to create the directory, %s", err)
+	}
+
+	testBucket(t, b)
+
+	// a key that tries to climb out of the bucket ends up inside it, with
+	// an attributes sidecar that can't be written as a blob
+
+	err = b.WriteAll(ctx, "../../outside.jpg", []byte("hello"), &WriterOptions{ContentType: "image/jpeg"})
+
+	if err != nil {
+		t.Fatalf("Failed to write blob, %s", err)
+	}
+
+	_, err = os.Stat(filepath.Join(root, "outside.jpg"))
+
+	if !os.IsNotExist(err) {
+		t.Fatalf("Expected nothing to be written outside the bucket, %v", err)
+	}
+
+	for _, name := range []string{"outside.jpg", "outside.jpg" + fileAttrsSuffix} {
+
+		_, err = os.Stat(filepath.Join(bucket_root, name))
+
+		if err != nil {
+			t.Fatalf("Expected %s to be written inside the bucket, %s", name, err)
+		}
+	}
+
+	for _, key := range []string{"", "/", "..", "outside.jpg" + fileAttrsSuffix} {
+
+		err := b.WriteAll(ctx, key, []byte("hello"), nil)
+
+		if err == nil {
+			t.Fatalf("Expected key '%s' to be invalid", key)
+		}
+	}
+
+	// leftover temporary files and sidecars aren't listed
+
+	ioutil.WriteFile(filepath.Join(bucket_root, fileTempPrefix+"123"), []byte("partial"), 0644)
+
+	err = b.List(ctx, "outside", func(key string, attrs *Attributes) error {
+
+		if key != "outside.jpg" {
+			t.Fatalf("Unexpected key '%s'", key)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to list blobs, %s", err)
+	}
+
+	err = b.List(ctx, fileTempPrefix, func(key string, attrs *Attributes) error {
+		t.Fatalf("Unexpected key '%s'", key)
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to list blobs, %s", err)
+	}
+}
@@ -0,0 +1,237 @@
+package blob
+
+// MemBucket keeps blobs in memory. Buckets are named by the host (and path) of
+// their URL and every bucket with the same name in a process is the same
+// bucket, so "mem://scratch" used as both a source and a cache will find the
+// same blobs. "mem://" is a bucket too, just one without a name.
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	iiiferrors "github.com/thisisaaronland/go-iiif/errors"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type MemBucket struct {
+	Bucket
+	lock  *sync.RWMutex
+	blobs map[string]*memBlob
+}
+
+type memBlob struct {
+	body  []byte
+	attrs *Attributes
+}
+
+var mem_buckets_mu = new(sync.Mutex)
+var mem_buckets = make(map[string]*MemBucket)
+
+func init() {
+
+	Register("mem", func(ctx context.Context, u *url.URL) (Bucket, error) {
+
+		b, err := NewMemBucket(u.Host + u.Path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return b, nil
+	})
+}
+
+func NewMemBucket(name string) (*MemBucket, error) {
+
+	mem_buckets_mu.Lock()
+	defer mem_buckets_mu.Unlock()
+
+	b, ok := mem_buckets[name]
+
+	if ok {
+		return b, nil
+	}
+
+	b = &MemBucket{
+		lock:  new(sync.RWMutex),
+		blobs: make(map[string]*memBlob),
+	}
+
+	mem_buckets[name] = b
+	return b, nil
+}
+
+func (b *MemBucket) NewReader(ctx context.Context, key string) (io.ReadCloser, *Attributes, error) {
+
+	blob, err := b.blob(ctx, key)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(blob.body)), copyAttributes(blob.attrs), nil
+}
+
+func (b *MemBucket) Attributes(ctx context.Context, key string) (*Attributes, error) {
+
+	blob, err := b.blob(ctx, key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return copyAttributes(blob.attrs), nil
+}
+
+func (b *MemBucket) WriteAll(ctx context.Context, key string, body []byte, opts *WriterOptions) error {
+
+	err := ctx.Err()
+
+	if err != nil {
+		return err
+	}
+
+	// copy body in case the caller reuses it
+
+	data := make([]byte, len(body))
+	copy(data, body)
+
+	attrs := Attributes{
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+		ETag:    fmt.Sprintf("\"%x\"", md5.Sum(data)),
+	}
+
+	if opts != nil {
+
+		attrs.ContentType = opts.ContentType
+
+		if len(opts.Metadata) > 0 {
+
+			attrs.Metadata = make(map[string]string)
+
+			for k, v := range opts.Metadata {
+				attrs.Metadata[k] = v
+			}
+		}
+	}
+
+	blob := memBlob{
+		body:  data,
+		attrs: &attrs,
+	}
+
+	b.lock.Lock()
+	b.blobs[cleanKey(key)] = &blob
+	b.lock.Unlock()
+
+	return nil
+}
+
+func (b *MemBucket) Delete(ctx context.Context, key string) error {
+
+	err := ctx.Err()
+
+	if err != nil {
+		return err
+	}
+
+	b.lock.Lock()
+	delete(b.blobs, cleanKey(key))
+	b.lock.Unlock()
+
+	return nil
+}
+
+func (b *MemBucket) List(ctx context.Context, prefix string, fn ListFunc) error {
+
+	// collect the matching keys first so that fn can write to (or delete
+	// from) the bucket without deadlocking
+
+	b.lock.RLock()
+
+	keys := make([]string, 0)
+	attrs := make(map[string]*Attributes)
+
+	for key, blob := range b.blobs {
+
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+			attrs[key] = copyAttributes(blob.attrs)
+		}
+	}
+
+	b.lock.RUnlock()
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+
+		err := ctx.Err()
+
+		if err != nil {
+			return err
+		}
+
+		err = fn(key, attrs[key])
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close doesn't do anything because other users of the bucket might still
+// need its blobs
+
+func (b *MemBucket) Local() bool {
+	return true
+}
+
+func (b *MemBucket) Close() error {
+	return nil
+}
+
+func (b *MemBucket) blob(ctx context.Context, key string) (*memBlob, error) {
+
+	err := ctx.Err()
+
+	if err != nil {
+		return nil, err
+	}
+
+	b.lock.RLock()
+	blob, ok := b.blobs[cleanKey(key)]
+	b.lock.RUnlock()
+
+	if !ok {
+		return nil, iiiferrors.NewNotFoundError(key, nil)
+	}
+
+	return blob, nil
+}
+
+func copyAttributes(attrs *Attributes) *Attributes {
+
+	a := *attrs
+
+	if attrs.Metadata != nil {
+
+		a.Metadata = make(map[string]string)
+
+		for k, v := range attrs.Metadata {
+			a.Metadata[k] = v
+		}
+	}
+
+	return &a
+}
@@ -0,0 +1,160 @@
+package blob
+
+// S3Bucket keeps blobs in S3 (or an S3-compatible service) using the same code
+// as the S3 source and cache. The host of the URL is the name of the bucket,
+// its path is a prefix for all keys and the rest of the S3 config is taken from
+// its query parameters:
+//
+//	s3://your-bucket/some/prefix?region=us-east-1&credentials=env:
+//
+// The query parameters are "region", "credentials", "endpoint", "path_style",
+// "acl", "storage_class", "server_side_encryption", "kms_key_id" and
+// "cache_control", all of which mean the same thing as they do for the S3
+// cache.
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	iiifaws "github.com/thisisaaronland/go-iiif/aws"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type S3Bucket struct {
+	Bucket
+	S3 *iiifaws.S3Connection
+}
+
+func init() {
+
+	Register("s3", func(ctx context.Context, u *url.URL) (Bucket, error) {
+
+		b, err := NewS3Bucket(u)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return b, nil
+	})
+}
+
+func NewS3Bucket(u *url.URL) (*S3Bucket, error) {
+
+	if u.Host == "" {
+		return nil, errors.New("S3 bucket URL has no bucket")
+	}
+
+	q := u.Query()
+
+	s3cfg := iiifaws.S3Config{
+		Bucket:               u.Host,
+		Prefix:               strings.Trim(u.Path, "/"),
+		Region:               q.Get("region"),
+		Credentials:          q.Get("credentials"),
+		Endpoint:             q.Get("endpoint"),
+		PathStyle:            q.Get("path_style") == "true",
+		ACL:                  q.Get("acl"),
+		StorageClass:         q.Get("storage_class"),
+		ServerSideEncryption: q.Get("server_side_encryption"),
+		KMSKeyID:             q.Get("kms_key_id"),
+		CacheControl:         q.Get("cache_control"),
+	}
+
+	conn, err := iiifaws.NewS3Connection(s3cfg)
+
+	if err != nil {
+		return nil, err
+	}
+
+	b := S3Bucket{
+		S3: conn,
+	}
+
+	return &b, nil
+}
+
+func (b *S3Bucket) NewReader(ctx context.Context, key string) (io.ReadCloser, *Attributes, error) {
+
+	rsp, err := b.S3.GetWithContext(ctx, cleanKey(key))
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	attrs := s3Attributes(rsp.ContentLength, rsp.LastModified, rsp.ETag, rsp.ContentType, rsp.Metadata)
+	return rsp.Body, attrs, nil
+}
+
+func (b *S3Bucket) Attributes(ctx context.Context, key string) (*Attributes, error) {
+
+	rsp, err := b.S3.HeadWithContext(ctx, cleanKey(key))
+
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := s3Attributes(rsp.ContentLength, rsp.LastModified, rsp.ETag, rsp.ContentType, rsp.Metadata)
+	return attrs, nil
+}
+
+func (b *S3Bucket) WriteAll(ctx context.Context, key string, body []byte, opts *WriterOptions) error {
+
+	if opts == nil {
+		return b.S3.PutWithContext(ctx, cleanKey(key), body, nil)
+	}
+
+	put_opts := iiifaws.PutOptions{
+		ContentType: opts.ContentType,
+		Metadata:    opts.Metadata,
+	}
+
+	return b.S3.PutWithContext(ctx, cleanKey(key), body, &put_opts)
+}
+
+func (b *S3Bucket) Delete(ctx context.Context, key string) error {
+
+	return b.S3.DeleteWithContext(ctx, cleanKey(key))
+}
+
+func (b *S3Bucket) List(ctx context.Context, prefix string, fn ListFunc) error {
+
+	return b.S3.List(ctx, prefix, func(key string, obj *s3.Object) error {
+
+		attrs := Attributes{
+			Size:    aws.Int64Value(obj.Size),
+			ModTime: aws.TimeValue(obj.LastModified),
+			ETag:    aws.StringValue(obj.ETag),
+		}
+
+		return fn(key, &attrs)
+	})
+}
+
+func (b *S3Bucket) Close() error {
+	return nil
+}
+
+func s3Attributes(size *int64, modtime *time.Time, etag *string, content_type *string, metadata map[string]*string) *Attributes {
+
+	attrs := Attributes{
+		Size:        -1,
+		ModTime:     aws.TimeValue(modtime),
+		ETag:        aws.StringValue(etag),
+		ContentType: aws.StringValue(content_type),
+	}
+
+	if size != nil {
+		attrs.Size = *size
+	}
+
+	if len(metadata) > 0 {
+		attrs.Metadata = aws.StringValueMap(metadata)
+	}
+
+	return &attrs
+}
@@ -0,0 +1,229 @@
+package cache
+
+// BlobCache stores things in any bucket that the blob package knows how to
+// open, named by the "uri" property:
+//
+//	"cache": { "name": "Blob", "uri": "file:///usr/local/iiif/cache?create_dir=true", "ttl": 3600 }
+//
+// Like S3Cache, the expiry time for a key set with a TTL (or in a cache with a
+// "ttl" property) is stored in its metadata and checked when it is read, since
+// most buckets can't expire things on their own.
+
+import (
+	"context"
+	iiifblob "github.com/thisisaaronland/go-iiif/blob"
+	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	iiiferrors "github.com/thisisaaronland/go-iiif/errors"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+const blobExpiresMetadata = "Iiif-Expires"
+
+type BlobCache struct {
+	ContextCache
+	Bucket iiifblob.Bucket
+	ttl    time.Duration
+}
+
+func init() {
+
+	Register("Blob", func(cfg iiifconfig.CacheConfig) (Cache, error) {
+
+		c, err := NewBlobCache(cfg)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return c, nil
+	})
+}
+
+func NewBlobCache(cfg iiifconfig.CacheConfig) (*BlobCache, error) {
+
+	bucket, err := iiifblob.OpenBucket(context.Background(), cfg.URI)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c := BlobCache{
+		Bucket: bucket,
+		ttl:    time.Duration(cfg.TTL) * time.Second,
+	}
+
+	return &c, nil
+}
+
+func (c *BlobCache) Exists(key string) bool {
+
+	ok, _ := c.ExistsContext(context.Background(), key)
+	return ok
+}
+
+func (c *BlobCache) ExistsContext(ctx context.Context, key string) (bool, error) {
+
+	_, err := c.Stat(ctx, key)
+
+	if iiiferrors.IsNotFound(err) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (c *BlobCache) Get(key string) ([]byte, error) {
+
+	return c.GetContext(context.Background(), key)
+}
+
+func (c *BlobCache) GetContext(ctx context.Context, key string) ([]byte, error) {
+
+	fh, attrs, err := c.Bucket.NewReader(ctx, key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer fh.Close()
+
+	info := blobEntryInfo(attrs)
+
+	if isExpired(info) {
+		c.Bucket.Delete(ctx, key)
+		return nil, iiiferrors.NewNotFoundError(key, nil)
+	}
+
+	return ioutil.ReadAll(fh)
+}
+
+func (c *BlobCache) Stat(ctx context.Context, key string) (*EntryInfo, error) {
+
+	attrs, err := c.Bucket.Attributes(ctx, key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	info := blobEntryInfo(attrs)
+
+	if isExpired(info) {
+		c.Bucket.Delete(ctx, key)
+		return nil, iiiferrors.NewNotFoundError(key, nil)
+	}
+
+	return info, nil
+}
+
+func (c *BlobCache) Set(key string, body []byte) error {
+
+	return c.SetWithOptions(context.Background(), key, body, nil)
+}
+
+func (c *BlobCache) SetWithOptions(ctx context.Context, key string, body []byte, opts *SetOptions) error {
+
+	metadata := make(map[string]string)
+	ttl := c.ttl
+
+	write_opts := iiifblob.WriterOptions{
+		Metadata: metadata,
+	}
+
+	if opts != nil {
+
+		write_opts.ContentType = opts.ContentType
+
+		for k, v := range opts.Metadata {
+			metadata[k] = v
+		}
+
+		if opts.TTL > 0 {
+			ttl = opts.TTL
+		}
+	}
+
+	if ttl > 0 {
+		expires := time.Now().Add(ttl)
+		metadata[blobExpiresMetadata] = expires.UTC().Format(time.RFC3339)
+	}
+
+	return c.Bucket.WriteAll(ctx, key, body, &write_opts)
+}
+
+func (c *BlobCache) Unset(key string) error {
+
+	return c.UnsetContext(context.Background(), key)
+}
+
+func (c *BlobCache) UnsetContext(ctx context.Context, key string) error {
+
+	return c.Bucket.Delete(ctx, key)
+}
+
+// List lists all the keys that start with prefix. Like S3Cache it doesn't
+// bother checking whether they've expired since, for some buckets, that would
+// mean a request for every key.
+
+func (c *BlobCache) List(ctx context.Context, prefix string, fn ListFunc) error {
+
+	return c.Bucket.List(ctx, prefix, func(key string, attrs *iiifblob.Attributes) error {
+		return fn(key)
+	})
+}
+
+func (c *BlobCache) Stats(ctx context.Context) (*CacheStats, error) {
+
+	stats := CacheStats{}
+
+	err := c.Bucket.List(ctx, "", func(key string, attrs *iiifblob.Attributes) error {
+
+		stats.Keys += 1
+		stats.Bytes += attrs.Size
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
+
+func blobEntryInfo(attrs *iiifblob.Attributes) *EntryInfo {
+
+	info := EntryInfo{
+		Size:        attrs.Size,
+		ModTime:     attrs.ModTime,
+		ContentType: attrs.ContentType,
+	}
+
+	if len(attrs.Metadata) > 0 {
+
+		info.Metadata = make(map[string]string)
+
+		for k, v := range attrs.Metadata {
+
+			if strings.EqualFold(k, blobExpiresMetadata) {
+
+				t, err := time.Parse(time.RFC3339, v)
+
+				if err == nil {
+					info.Expires = t
+				}
+
+				continue
+			}
+
+			info.Metadata[k] = v
+		}
+	}
+
+	return &info
+}
@@ -0,0 +1,187 @@
+package cache
+
+import (
+	"context"
+	iiifblob "github.com/thisisaaronland/go-iiif/blob"
+	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	iiiferrors "github.com/thisisaaronland/go-iiif/errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBlobCache(t *testing.T) {
+
+	cfg := iiifconfig.CacheConfig{
+		Name: "Blob",
+		URI:  "mem://test-cache",
+		TTL:  60,
+	}
+
+	c, err := NewBlobCache(cfg)
+
+	if err != nil {
+		t.Fatalf("Failed to create Blob cache, %s", err)
+	}
+
+	ctx := context.Background()
+
+	_, err = c.Get("test.jpg")
+
+	if !iiiferrors.IsNotFound(err) {
+		t.Fatalf("Expected a NotFound error for a missing key, got %v", err)
+	}
+
+	_, err = c.Stat(ctx, "test.jpg")
+
+	if !iiiferrors.IsNotFound(err) {
+		t.Fatalf("Expected a NotFound error for a missing key, got %v", err)
+	}
+
+	if c.Exists("test.jpg") {
+		t.Fatal("Expected a missing key not to exist")
+	}
+
+	opts := SetOptions{
+		ContentType: "image/jpeg",
+		Metadata: map[string]string{
+			"Source": "test",
+		},
+	}
+
+	err = c.SetWithOptions(ctx, "test.jpg", []byte("hello"), &opts)
+
+	if err != nil {
+		t.Fatalf("Failed to set key, %s", err)
+	}
+
+	body, err := c.Get("test.jpg")
+
+	if err != nil || string(body) != "hello" {
+		t.Fatalf("Expected 'hello', got '%s' and %v", body, err)
+	}
+
+	info, err := c.Stat(ctx, "test.jpg")
+
+	if err != nil {
+		t.Fatalf("Failed to stat key, %s", err)
+	}
+
+	if info.Size != 5 || info.ContentType != "image/jpeg" || info.Metadata["Source"] != "test" {
+		t.Fatalf("Unexpected info %+v", info)
+	}
+
+	// the expiry time is stored as metadata but reported separately
+
+	if _, ok := info.Metadata[blobExpiresMetadata]; ok {
+		t.Fatalf("Expected the expiry time to be hidden, got %v", info.Metadata)
+	}
+
+	if d := time.Until(info.Expires); d <= 0 || d > time.Minute {
+		t.Fatalf("Expected key to expire in about 1m, got %s", d)
+	}
+
+	attrs, err := c.Bucket.Attributes(ctx, "test.jpg")
+
+	if err != nil || attrs.Metadata[blobExpiresMetadata] == "" {
+		t.Fatalf("Expected an expiry time in the blob's metadata, got %+v %v", attrs, err)
+	}
+
+	err = c.Unset("test.jpg")
+
+	if err != nil {
+		t.Fatalf("Failed to unset key, %s", err)
+	}
+
+	if c.Exists("test.jpg") {
+		t.Fatal("Expected key to be removed")
+	}
+
+	// keys that have expired are removed when they are read
+
+	expired_opts := iiifblob.WriterOptions{
+		Metadata: map[string]string{
+			blobExpiresMetadata: time.Now().Add(-time.Minute).UTC().Format(time.RFC3339),
+		},
+	}
+
+	for _, key := range []string{"stat.jpg", "get.jpg"} {
+
+		err = c.Bucket.WriteAll(ctx, key, []byte("hello"), &expired_opts)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = c.Stat(ctx, "stat.jpg")
+
+	if !iiiferrors.IsNotFound(err) {
+		t.Fatalf("Expected a NotFound error for an expired key, got %v", err)
+	}
+
+	_, err = c.Get("get.jpg")
+
+	if !iiiferrors.IsNotFound(err) {
+		t.Fatalf("Expected a NotFound error for an expired key, got %v", err)
+	}
+
+	for _, key := range []string{"stat.jpg", "get.jpg"} {
+
+		_, err = c.Bucket.Attributes(ctx, key)
+
+		if !iiiferrors.IsNotFound(err) {
+			t.Fatalf("Expected expired key %s to be deleted, got %v", key, err)
+		}
+	}
+
+	// and a TTL set with the key wins over the cache's
+
+	err = c.SetWithOptions(ctx, "short.jpg", []byte("hello"), &SetOptions{TTL: time.Second})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err = c.Stat(ctx, "short.jpg")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if time.Until(info.Expires) > 2*time.Second {
+		t.Fatalf("Expected key to expire in about 1s, got %s", time.Until(info.Expires))
+	}
+
+	// keys are listed by prefix and counted
+
+	for _, key := range []string{"a/1.jpg", "a/2.jpg", "b/1.jpg"} {
+
+		err := c.Set(key, []byte("hello"))
+
+		if err != nil {
+			t.Fatalf("Failed to set %s, %s", key, err)
+		}
+	}
+
+	keys := make([]string, 0)
+
+	err = c.List(ctx, "a/", func(key string) error {
+		keys = append(keys, key)
+		return nil
+	})
+
+	if err != nil || strings.Join(keys, ",") != "a/1.jpg,a/2.jpg" {
+		t.Fatalf("Unexpected keys %v, %v", keys, err)
+	}
+
+	stats, err := c.Stats(ctx)
+
+	if err != nil {
+		t.Fatalf("Failed to get stats, %s", err)
+	}
+
+	if stats.Keys != 4 || stats.Bytes != 20 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+}
@@ -0,0 +1,816 @@
+package cache
+
+// BoltCache stores keys in a single bbolt database file rather than as one file
+// per key, which is kinder to filesystems (and backups) when there are millions
+// of tiles. A seeded tile pyramid can be shipped as one file and served as-is
+// by opening it with "read_only": true, which also lets more than one process
+// use it at once (bbolt only allows one process to open a file for writing).
+//
+// The options passed to SetWithOptions, or the expiry time when the cache has a
+// TTL, are stored as JSON in a second bucket. Expired keys are treated as
+// missing and removed by a background janitor, which is stopped by Close. bbolt files never shrink on
+// their own so Compact copies the live keys to a new file and replaces the old
+// one with it.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	iiiferrors "github.com/thisisaaronland/go-iiif/errors"
+	bolt "go.etcd.io/bbolt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var boltDataBucket = []byte("iiif")
+var boltMetaBucket = []byte("iiif.meta")
+
+// the number of keys read or written per transaction when listing, expiring
+// or compacting a cache
+
+const boltBatchSize = 1000
+
+type BoltCache struct {
+	ContextCache
+	db        *bolt.DB
+	path      string
+	ttl       time.Duration
+	read_only bool
+	mu        *sync.RWMutex // guards db, which Compact replaces
+	hits      int64         // atomic
+	misses    int64         // atomic
+	done      chan bool
+	once      *sync.Once
+}
+
+type boltMeta struct {
+	Expires     time.Time         `json:"expires,omitempty"`
+	ContentType string            `json:"content_type,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+func init() {
+
+	Register("Bolt", func(cfg iiifconfig.CacheConfig) (Cache, error) {
+
+		c, err := NewBoltCache(cfg)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return c, nil
+	})
+}
+
+// NewBoltCache opens (or creates, unless cfg.ReadOnly is true) the bbolt
+// database at cfg.Path.
+
+func NewBoltCache(cfg iiifconfig.CacheConfig) (*BoltCache, error) {
+
+	if cfg.Path == "" {
+		return nil, errors.New("Bolt cache is missing a path")
+	}
+
+	if cfg.TTL < 0 {
+		return nil, errors.New("Bolt cache TTL can not be negative")
+	}
+
+	if cfg.ReadOnly {
+
+		_, err := os.Stat(cfg.Path)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	c := BoltCache{
+		path:      cfg.Path,
+		ttl:       time.Duration(cfg.TTL) * time.Second,
+		read_only: cfg.ReadOnly,
+		mu:        new(sync.RWMutex),
+		done:      make(chan bool),
+		once:      new(sync.Once),
+	}
+
+	db, err := c.open(c.path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.db = db
+
+	if !c.read_only {
+
+		interval := time.Hour
+
+		if c.ttl > 0 {
+			interval = janitorInterval(c.ttl)
+		}
+
+		go c.janitor(interval)
+	}
+
+	return &c, nil
+}
+
+func (c *BoltCache) Exists(key string) bool {
+
+	ok, _ := c.ExistsContext(context.Background(), key)
+	return ok
+}
+
+func (c *BoltCache) ExistsContext(ctx context.Context, key string) (bool, error) {
+
+	_, err := c.Stat(ctx, key)
+
+	if iiiferrors.IsNotFound(err) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (c *BoltCache) Get(key string) ([]byte, error) {
+
+	return c.GetContext(context.Background(), key)
+}
+
+func (c *BoltCache) GetContext(ctx context.Context, key string) ([]byte, error) {
+
+	var body []byte
+
+	_, err := c.view(ctx, key, func(v []byte) {
+
+		// values are only valid for the life of the transaction
+
+		body = make([]byte, len(v))
+		copy(body, v)
+	})
+
+	if iiiferrors.IsNotFound(err) {
+		c.count(false)
+		return nil, err
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.count(true)
+	return body, nil
+}
+
+func (c *BoltCache) Stat(ctx context.Context, key string) (*EntryInfo, error) {
+
+	var size int64
+
+	meta, err := c.view(ctx, key, func(v []byte) {
+		size = int64(len(v))
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	info := EntryInfo{
+		Size: size,
+	}
+
+	if meta != nil {
+		info.Expires = meta.Expires
+		info.ContentType = meta.ContentType
+		info.Metadata = meta.Metadata
+	}
+
+	return &info, nil
+}
+
+func (c *BoltCache) Set(key string, body []byte) error {
+
+	return c.SetWithOptions(context.Background(), key, body, nil)
+}
+
+func (c *BoltCache) SetWithOptions(ctx context.Context, key string, body []byte, opts *SetOptions) error {
+
+	err := c.writable(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	meta := boltMeta{}
+	ttl := c.ttl
+
+	if opts != nil {
+
+		meta.ContentType = opts.ContentType
+		meta.Metadata = opts.Metadata
+
+		if opts.TTL > 0 {
+			ttl = opts.TTL
+		}
+	}
+
+	if ttl > 0 {
+		meta.Expires = time.Now().Add(ttl)
+	}
+
+	var meta_enc []byte
+
+	if !meta.Expires.IsZero() || meta.ContentType != "" || len(meta.Metadata) > 0 {
+
+		meta_enc, err = json.Marshal(meta)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.db.Update(func(tx *bolt.Tx) error {
+
+		err := tx.Bucket(boltDataBucket).Put([]byte(key), body)
+
+		if err != nil {
+			return err
+		}
+
+		meta_bucket := tx.Bucket(boltMetaBucket)
+
+		if meta_enc == nil {
+			return meta_bucket.Delete([]byte(key))
+		}
+
+		return meta_bucket.Put([]byte(key), meta_enc)
+	})
+}
+
+func (c *BoltCache) Unset(key string) error {
+
+	return c.UnsetContext(context.Background(), key)
+}
+
+func (c *BoltCache) UnsetContext(ctx context.Context, key string) error {
+
+	err := c.writable(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.db.Update(func(tx *bolt.Tx) error {
+		return deleteBoltKeys(tx, [][]byte{[]byte(key)})
+	})
+}
+
+// List calls fn for each (unexpired) key that starts with prefix, in order.
+// Keys are read in batches so fn is free to modify the cache.
+
+func (c *BoltCache) List(ctx context.Context, prefix string, fn ListFunc) error {
+
+	start := []byte(prefix)
+	skip_start := false
+
+	for {
+
+		keys, err := c.listBatch(ctx, start, []byte(prefix), skip_start)
+
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+
+			err := fn(key)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(keys) < boltBatchSize {
+			break
+		}
+
+		start = []byte(keys[len(keys)-1])
+		skip_start = true
+	}
+
+	return nil
+}
+
+// Stats reports the number of keys in the cache and the size of the database
+// file (rather than the sum of the size of the values in it, which would mean
+// reading all of them).
+
+func (c *BoltCache) Stats(ctx context.Context) (*CacheStats, error) {
+
+	err := ctx.Err()
+
+	if err != nil {
+		return nil, err
+	}
+
+	stats := CacheStats{}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	err = c.db.View(func(tx *bolt.Tx) error {
+
+		stats.Keys = int64(tx.Bucket(boltDataBucket).Stats().KeyN)
+		stats.Bytes = tx.Size()
+
+		stats.Hits = atomic.LoadInt64(&c.hits)
+		stats.Misses = atomic.LoadInt64(&c.misses)
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
+
+// Compact copies all the unexpired keys to a new database file and then
+// replaces the current file with it, reclaiming the space used by deleted
+// and expired keys. The cache can't be used while it is being compacted.
+
+func (c *BoltCache) Compact(ctx context.Context) error {
+
+	err := c.writable(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tmp_path := c.path + ".compact"
+
+	err = os.Remove(tmp_path)
+
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	dst, err := c.open(tmp_path)
+
+	if err != nil {
+		return err
+	}
+
+	err = copyBoltKeys(ctx, c.db, dst)
+
+	close_err := dst.Close()
+
+	if err == nil {
+		err = close_err
+	}
+
+	if err != nil {
+		os.Remove(tmp_path)
+		return err
+	}
+
+	err = c.db.Close()
+
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp_path, c.path)
+
+	if err != nil {
+
+		// carry on with the old file
+
+		db, open_err := c.open(c.path)
+
+		if open_err != nil {
+			message := fmt.Sprintf("Failed to replace %s (%s) and to reopen it (%s)", c.path, err, open_err)
+			return errors.New(message)
+		}
+
+		c.db = db
+		return err
+	}
+
+	db, err := c.open(c.path)
+
+	if err != nil {
+		return err
+	}
+
+	c.db = db
+	return nil
+}
+
+// Close stops the janitor and closes the database file. The cache can't be
+// used after it has been closed.
+
+func (c *BoltCache) Close() error {
+
+	c.once.Do(func() {
+		close(c.done)
+	})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.db.Close()
+}
+
+func (c *BoltCache) open(path string) (*bolt.DB, error) {
+
+	opts := bolt.Options{
+		Timeout:  5 * time.Second,
+		ReadOnly: c.read_only,
+	}
+
+	db, err := bolt.Open(path, 0644, &opts)
+
+	if err != nil {
+		message := fmt.Sprintf("Failed to open %s, %s", path, err)
+		return nil, errors.New(message)
+	}
+
+	if c.read_only {
+
+		err = db.View(func(tx *bolt.Tx) error {
+
+			if tx.Bucket(boltDataBucket) == nil || tx.Bucket(boltMetaBucket) == nil {
+				message := fmt.Sprintf("%s is not a go-iiif cache", path)
+				return errors.New(message)
+			}
+
+			return nil
+		})
+
+	} else {
+
+		err = db.Update(func(tx *bolt.Tx) error {
+
+			for _, name := range [][]byte{boltDataBucket, boltMetaBucket} {
+
+				_, err := tx.CreateBucketIfNotExists(name)
+
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+	}
+
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// view calls fn with the value for key, if it exists and hasn't expired, and
+// returns its metadata
+
+func (c *BoltCache) view(ctx context.Context, key string, fn func([]byte)) (*boltMeta, error) {
+
+	err := ctx.Err()
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var meta *boltMeta
+
+	err = c.db.View(func(tx *bolt.Tx) error {
+
+		v := tx.Bucket(boltDataBucket).Get([]byte(key))
+
+		if v == nil {
+			return iiiferrors.NewNotFoundError(key, errors.New("cache miss"))
+		}
+
+		m, err := readBoltMeta(tx, []byte(key))
+
+		if err != nil {
+			return err
+		}
+
+		if m != nil && !m.Expires.IsZero() && time.Now().After(m.Expires) {
+			return iiiferrors.NewNotFoundError(key, errors.New("cache miss"))
+		}
+
+		fn(v)
+		meta = m
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return meta, nil
+}
+
+func (c *BoltCache) listBatch(ctx context.Context, start []byte, prefix []byte, skip_start bool) ([]string, error) {
+
+	err := ctx.Err()
+
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0)
+	now := time.Now()
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	err = c.db.View(func(tx *bolt.Tx) error {
+
+		cur := tx.Bucket(boltDataBucket).Cursor()
+
+		for k, _ := cur.Seek(start); k != nil && bytes.HasPrefix(k, prefix); k, _ = cur.Next() {
+
+			if skip_start && bytes.Equal(k, start) {
+				continue
+			}
+
+			m, err := readBoltMeta(tx, k)
+
+			if err != nil {
+				return err
+			}
+
+			if m != nil && !m.Expires.IsZero() && now.After(m.Expires) {
+				continue
+			}
+
+			keys = append(keys, string(k))
+
+			if len(keys) == boltBatchSize {
+				break
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func (c *BoltCache) writable(ctx context.Context) error {
+
+	err := ctx.Err()
+
+	if err != nil {
+		return err
+	}
+
+	if c.read_only {
+		message := fmt.Sprintf("%s is read-only", c.path)
+		return errors.New(message)
+	}
+
+	return nil
+}
+
+func (c *BoltCache) janitor(interval time.Duration) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+
+			err := c.removeExpired()
+
+			if err == bolt.ErrDatabaseNotOpen {
+				return
+			}
+
+			if err != nil {
+				log.Printf("Failed to remove expired keys from %s, %s\n", c.path, err)
+			}
+		}
+	}
+}
+
+// removeExpired only needs to look at the metadata bucket since keys without
+// metadata never expire
+
+func (c *BoltCache) removeExpired() error {
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	expired := make([][]byte, 0)
+	now := time.Now()
+
+	err := c.db.View(func(tx *bolt.Tx) error {
+
+		return tx.Bucket(boltMetaBucket).ForEach(func(k []byte, v []byte) error {
+
+			var m boltMeta
+
+			err := json.Unmarshal(v, &m)
+
+			if err != nil {
+				return err
+			}
+
+			if !m.Expires.IsZero() && now.After(m.Expires) {
+				key := make([]byte, len(k))
+				copy(key, k)
+				expired = append(expired, key)
+			}
+
+			return nil
+		})
+	})
+
+	if err != nil {
+		return err
+	}
+
+	for len(expired) > 0 {
+
+		n := boltBatchSize
+
+		if len(expired) < n {
+			n = len(expired)
+		}
+
+		batch := expired[0:n]
+		expired = expired[n:]
+
+		err := c.db.Update(func(tx *bolt.Tx) error {
+			return deleteBoltKeys(tx, batch)
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *BoltCache) count(hit bool) {
+
+	if hit {
+		atomic.AddInt64(&c.hits, 1)
+	} else {
+		atomic.AddInt64(&c.misses, 1)
+	}
+}
+
+func readBoltMeta(tx *bolt.Tx, key []byte) (*boltMeta, error) {
+
+	v := tx.Bucket(boltMetaBucket).Get(key)
+
+	if v == nil {
+		return nil, nil
+	}
+
+	var m boltMeta
+
+	err := json.Unmarshal(v, &m)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+func deleteBoltKeys(tx *bolt.Tx, keys [][]byte) error {
+
+	data := tx.Bucket(boltDataBucket)
+	meta := tx.Bucket(boltMetaBucket)
+
+	for _, k := range keys {
+
+		err := data.Delete(k)
+
+		if err != nil {
+			return err
+		}
+
+		err = meta.Delete(k)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// copyBoltKeys copies all the unexpired keys (and their metadata) from src to
+// dst, in batches so that dst's transactions don't get too big
+
+func copyBoltKeys(ctx context.Context, src *bolt.DB, dst *bolt.DB) error {
+
+	now := time.Now()
+
+	return src.View(func(src_tx *bolt.Tx) error {
+
+		cur := src_tx.Bucket(boltDataBucket).Cursor()
+		k, v := cur.First()
+
+		for k != nil {
+
+			err := ctx.Err()
+
+			if err != nil {
+				return err
+			}
+
+			err = dst.Update(func(dst_tx *bolt.Tx) error {
+
+				data := dst_tx.Bucket(boltDataBucket)
+				meta := dst_tx.Bucket(boltMetaBucket)
+
+				for i := 0; k != nil && i < boltBatchSize; i++ {
+
+					m := src_tx.Bucket(boltMetaBucket).Get(k)
+
+					if m != nil {
+
+						var bm boltMeta
+
+						err := json.Unmarshal(m, &bm)
+
+						if err != nil {
+							return err
+						}
+
+						if !bm.Expires.IsZero() && now.After(bm.Expires) {
+							k, v = cur.Next()
+							continue
+						}
+
+						err = meta.Put(k, m)
+
+						if err != nil {
+							return err
+						}
+					}
+
+					err := data.Put(k, v)
+
+					if err != nil {
+						return err
+					}
+
+					k, v = cur.Next()
+				}
+
+				return nil
+			})
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
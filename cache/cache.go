@@ -1,7 +1,9 @@
 package cache
 
 import (
+	"context"
 	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	"time"
 )
 
 type Cache interface {
@@ -11,6 +13,59 @@ type Cache interface {
 	Unset(string) error
 }
 
+// ContextCache is a Cache whose operations can be cancelled, whose entries can
+// have their own TTL, content type and metadata and whose keys can be listed.
+// All the caches that ship with go-iiif are ContextCaches; use NewContextCache
+// for anything else.
+
+type ContextCache interface {
+	Cache
+	ExistsContext(context.Context, string) (bool, error)
+	GetContext(context.Context, string) ([]byte, error)
+	SetWithOptions(context.Context, string, []byte, *SetOptions) error
+	UnsetContext(context.Context, string) error
+	Stat(context.Context, string) (*EntryInfo, error)
+	List(context.Context, string, ListFunc) error
+	Stats(context.Context) (*CacheStats, error)
+}
+
+// SetOptions are the (optional) details of a cache entry. A TTL of zero means
+// whatever the cache's default is, which for most caches is "forever".
+
+type SetOptions struct {
+	TTL         time.Duration
+	ContentType string
+	Metadata    map[string]string
+}
+
+type EntryInfo struct {
+	Size        int64
+	ModTime     time.Time
+	Expires     time.Time // zero means never
+	ContentType string
+	Metadata    map[string]string
+}
+
+// CacheStats are the numbers a cache knows about itself. Everything other than
+// Keys and Bytes is only reported by caches that keep track of it and is zero
+// otherwise, as is MaxBytes for caches without a size limit. Keys and Bytes are
+// -1 for caches that can't count them (like Memcached).
+
+type CacheStats struct {
+	Keys        int64
+	Bytes       int64
+	MaxBytes    int64
+	Hits        int64
+	Misses      int64
+	Evictions   int64
+	Expirations int64
+}
+
+// ListFunc is called for each key that List finds. Returning an error stops
+// the listing and is returned by List.
+
+type ListFunc func(key string) error
+
 func NewImagesCacheFromConfig(config *iiifconfig.Config) (Cache, error) {
 
 	cfg := config.Images.Cache
@@ -25,17 +80,58 @@ func NewDerivativesCacheFromConfig(config *iiifconfig.Config) (Cache, error) {
 
 func NewCacheFromConfig(cfg iiifconfig.CacheConfig) (Cache, error) {
 
-	if cfg.Name == "Disk" {
-		cache, err := NewDiskCache(cfg)
-		return cache, err
-	} else if cfg.Name == "Memory" {
-		cache, err := NewMemoryCache(cfg)
-		return cache, err
-	} else if cfg.Name == "S3" {
-		cache, err := NewS3Cache(cfg)
-		return cache, err
-	} else {
-		cache, err := NewNullCache(cfg)
-		return cache, err
+	caches_mu.RLock()
+	init_func, ok := caches[cfg.Name]
+	caches_mu.RUnlock()
+
+	// for backwards compatibility anything that isn't a known cache
+	// is a null cache
+
+	if !ok {
+		return NewNullCache(cfg)
 	}
+
+	return init_func(cfg)
+}
+
+func isExpired(info *EntryInfo) bool {
+
+	if info.Expires.IsZero() {
+		return false
+	}
+
+	return time.Now().After(info.Expires)
+}
+
+// Purge removes every key that starts with prefix from a cache, for example
+// all the derivatives of an image (whose keys all start with "{ID}/"), and
+// returns the number of keys removed.
+
+func Purge(ctx context.Context, c ContextCache, prefix string) (int, error) {
+
+	keys := make([]string, 0)
+
+	err := c.List(ctx, prefix, func(key string) error {
+		keys = append(keys, key)
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+
+	for _, key := range keys {
+
+		err := c.UnsetContext(ctx, key)
+
+		if err != nil {
+			return count, err
+		}
+
+		count += 1
+	}
+
+	return count, nil
 }
@@ -0,0 +1,80 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+)
+
+// Coalescer makes sure that only one of any number of concurrent (identical)
+// requests for a given key does the actual work of fetching or generating it
+// while the others wait for and share its result. It's the thing that stops a
+// Leaflet viewer opening an uncached image from asking for the same source
+// image and the same tiles dozens of times at once. It does not remember
+// anything once a key is done; that's what caches are for.
+
+// Note that the bytes returned by Do are shared by all the callers waiting on
+// a given key and should be treated as read-only.
+
+type Coalescer struct {
+	mu    *sync.Mutex
+	calls map[string]*coalescedCall
+}
+
+type coalescedCall struct {
+	wg   *sync.WaitGroup
+	body []byte
+	err  error
+}
+
+func NewCoalescer() *Coalescer {
+
+	c := Coalescer{
+		mu:    new(sync.Mutex),
+		calls: make(map[string]*coalescedCall),
+	}
+
+	return &c
+}
+
+// Do calls fn for key unless there is already a call for key in flight, in
+// which case it waits for that call to finish and returns its results. The
+// boolean return value reports whether the results were shared with another
+// caller.
+
+func (c *Coalescer) Do(key string, fn func() ([]byte, error)) ([]byte, bool, error) {
+
+	c.mu.Lock()
+
+	call, ok := c.calls[key]
+
+	if ok {
+		c.mu.Unlock()
+		call.wg.Wait()
+		return call.body, true, call.err
+	}
+
+	// the error will be replaced by whatever fn returns unless it panics, in
+	// which case the waiting callers still need something to return
+
+	call = &coalescedCall{
+		wg:  new(sync.WaitGroup),
+		err: errors.New("Coalesced call did not complete"),
+	}
+
+	call.wg.Add(1)
+	c.calls[key] = call
+
+	c.mu.Unlock()
+
+	defer func() {
+
+		c.mu.Lock()
+		delete(c.calls, key)
+		c.mu.Unlock()
+
+		call.wg.Done()
+	}()
+
+	call.body, call.err = fn()
+	return call.body, false, call.err
+}
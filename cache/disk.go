@@ -1,17 +1,104 @@
 package cache
 
+// DiskCache stores each key as a file below a root directory. Files are
+// written to a temporary file and then renamed so that readers never see a
+// partially written file.
+//
+// If the cache has a "limit" (in megabytes) the least recently used files are
+// removed to make room for new ones. Access times are tracked in memory, and
+// seeded from each file's modification time when the cache is created, rather
+// than relying on the filesystem's atime (which is often disabled).
+//
+// If the cache has a "ttl" (in seconds) files older than that are treated as
+// missing and a background janitor removes them, along with files whose TTL
+// was set by SetWithOptions. The janitor is stopped by Close.
+//
+// Files can be laid out in one of three ways, set by the "layout" property:
+//
+//	flat		{ROOT}/{KEY} - the default
+//	sharded		{ROOT}/{HH}/{HH}/{KEY} - where HHHH is the start of the SHA-1 hash of
+//			the first part of KEY (the image's identifier, for derivatives)
+//	hashed		{ROOT}/{HH}/{HH}/{SHA-1 of KEY}
+//
+// The sharded layout spreads the top-level directories (one per image, for
+// derivatives) across 65536 buckets while keeping all of an image's
+// derivatives in the same one, so listing them only has to look there. The
+// hashed layout also keeps file names short and safe whatever the key is, at
+// the cost of not being able to List the keys in the cache.
+
 import (
+	"container/list"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/thisisaaronland/go-iiif/config"
+	iiiferrors "github.com/thisisaaronland/go-iiif/errors"
 	"io/ioutil"
-	_ "log"
+	"log"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The options passed to SetWithOptions are stored alongside the file they
+// describe in a "sidecar" file with this extension. Plain old Set doesn't
+// write one so caches that never use options look exactly like they always
+// have.
+
+const diskMetaExtension = ".iiifmeta"
+
+const diskTempPrefix = ".iiif-tmp-"
+
+const (
+	DiskLayoutFlat    = "flat"
+	DiskLayoutSharded = "sharded"
+	DiskLayoutHashed  = "hashed"
 )
 
 type DiskCache struct {
-	Cache
-	root string
+	ContextCache
+	root    string
+	layout  string
+	ttl     time.Duration
+	maxsize int64
+	size    int64
+	entries map[string]*list.Element // keyed by absolute path
+	lru     *list.List               // most recently used at the front
+	lock    *sync.Mutex
+	done    chan bool
+	once    *sync.Once
+}
+
+type diskEntry struct {
+	abs_path string
+	size     int64
+}
+
+type diskMeta struct {
+	Expires     time.Time         `json:"expires,omitempty"`
+	ContentType string            `json:"content_type,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+func init() {
+
+	Register("Disk", func(cfg config.CacheConfig) (Cache, error) {
+
+		c, err := NewDiskCache(cfg)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return c, nil
+	})
 }
 
 func NewDiskCache(cfg config.CacheConfig) (*DiskCache, error) {
@@ -23,81 +110,729 @@ func NewDiskCache(cfg config.CacheConfig) (*DiskCache, error) {
 		return nil, err
 	}
 
+	layout := cfg.Layout
+
+	if layout == "" {
+		layout = DiskLayoutFlat
+	}
+
+	if layout != DiskLayoutFlat && layout != DiskLayoutSharded && layout != DiskLayoutHashed {
+		message := fmt.Sprintf("Invalid disk cache layout '%s'", layout)
+		return nil, errors.New(message)
+	}
+
+	if cfg.TTL < 0 {
+		return nil, errors.New("Disk cache TTL can not be negative")
+	}
+
+	if cfg.Limit < 0 {
+		return nil, errors.New("Disk cache limit can not be negative")
+	}
+
 	c := DiskCache{
-		root: root,
+		root:    root,
+		layout:  layout,
+		ttl:     time.Duration(cfg.TTL) * time.Second,
+		maxsize: int64(cfg.Limit) * 1024 * 1024,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		lock:    new(sync.Mutex),
+		done:    make(chan bool),
+		once:    new(sync.Once),
+	}
+
+	if c.maxsize > 0 {
+
+		err = c.index()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if c.ttl > 0 {
+		go c.janitor(janitorInterval(c.ttl))
 	}
 
 	return &c, nil
 }
 
+// Close stops the cache's janitor, if it has one. The cache can still be used
+// after it has been closed but expired files are only removed when they are
+// read.
+
+func (c *DiskCache) Close() error {
+
+	c.once.Do(func() {
+		close(c.done)
+	})
+
+	return nil
+}
+
 func (c *DiskCache) Exists(rel_path string) bool {
 
-	abs_path := path.Join(c.root, rel_path)
+	ok, _ := c.ExistsContext(context.Background(), rel_path)
+	return ok
+}
 
-	_, err := os.Stat(abs_path)
+func (c *DiskCache) ExistsContext(ctx context.Context, rel_path string) (bool, error) {
 
-	if os.IsNotExist(err) {
-		return false
+	_, err := c.Stat(ctx, rel_path)
+
+	if iiiferrors.IsNotFound(err) {
+		return false, nil
 	}
 
-	return true
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
 }
 
 func (c *DiskCache) Get(rel_path string) ([]byte, error) {
 
-	abs_path := path.Join(c.root, rel_path)
+	return c.GetContext(context.Background(), rel_path)
+}
+
+func (c *DiskCache) GetContext(ctx context.Context, rel_path string) ([]byte, error) {
 
-	_, err := os.Stat(abs_path)
+	_, err := c.Stat(ctx, rel_path)
 
-	if os.IsNotExist(err) {
-		// fmt.Println(err)
+	if err != nil {
 		return nil, err
 	}
 
+	abs_path := c.absPath(rel_path)
+
 	body, err := ioutil.ReadFile(abs_path)
 
+	if os.IsNotExist(err) {
+		return nil, iiiferrors.NewNotFoundError(rel_path, err)
+	}
+
 	if err != nil {
 		// fmt.Println(err)
 		return nil, err
 	}
 
+	c.touch(abs_path)
+
 	return body, nil
 }
 
-func (c *DiskCache) Set(rel_path string, body []byte) error {
+// Stat returns the details for a file, removing it (and returning a not found
+// error) if it has expired.
 
-	abs_path := path.Join(c.root, rel_path)
+func (c *DiskCache) Stat(ctx context.Context, rel_path string) (*EntryInfo, error) {
 
-	root := filepath.Dir(abs_path)
+	err := ctx.Err()
 
-	_, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+
+	abs_path := c.absPath(rel_path)
+
+	info, err := c.stat(abs_path)
 
 	if os.IsNotExist(err) {
-		os.MkdirAll(root, 0755)
+		// fmt.Println(err)
+		return nil, iiiferrors.NewNotFoundError(rel_path, err)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if isExpired(info) {
+		c.remove(abs_path)
+		return nil, iiiferrors.NewNotFoundError(rel_path, nil)
+	}
+
+	return info, nil
+}
+
+func (c *DiskCache) Set(rel_path string, body []byte) error {
+
+	return c.SetWithOptions(context.Background(), rel_path, body, nil)
+}
+
+func (c *DiskCache) SetWithOptions(ctx context.Context, rel_path string, body []byte, opts *SetOptions) error {
+
+	err := ctx.Err()
+
+	if err != nil {
+		return err
+	}
+
+	size := int64(len(body))
+
+	if c.maxsize > 0 && size > c.maxsize {
+		return errors.New("Key is too big!")
 	}
 
-	fh, err := os.Create(abs_path)
+	abs_path := c.absPath(rel_path)
+
+	err = os.MkdirAll(filepath.Dir(abs_path), 0755)
 
 	if err != nil {
 		return err
 	}
 
-	defer fh.Close()
-	fh.Write(body)
-	fh.Sync()
+	// always replace (or remove) any old sidecar file so that the options
+	// for a previous version of this file don't linger
+
+	meta_path := abs_path + diskMetaExtension
+
+	if opts == nil || (opts.TTL <= 0 && opts.ContentType == "" && len(opts.Metadata) == 0) {
+
+		err = os.Remove(meta_path)
+
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+	} else {
+
+		meta := diskMeta{
+			ContentType: opts.ContentType,
+			Metadata:    opts.Metadata,
+		}
+
+		if opts.TTL > 0 {
+			meta.Expires = time.Now().Add(opts.TTL)
+		}
+
+		enc, err := json.Marshal(meta)
+
+		if err != nil {
+			return err
+		}
+
+		err = writeFileAtomic(meta_path, enc)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	err = writeFileAtomic(abs_path, body)
+
+	if err != nil {
+		return err
+	}
+
+	c.add(abs_path, size)
 
 	return nil
 }
 
 func (c *DiskCache) Unset(rel_path string) error {
 
-	abs_path := path.Join(c.root, rel_path)
+	return c.remove(c.absPath(rel_path))
+}
+
+func (c *DiskCache) UnsetContext(ctx context.Context, rel_path string) error {
 
-	_, err := os.Stat(abs_path)
+	err := ctx.Err()
+
+	if err != nil {
+		return err
+	}
+
+	return c.Unset(rel_path)
+}
+
+// List walks the cache's root directory, calling fn with the key for each file
+// that starts with prefix. Expired files are not skipped; Get and Stat (and the
+// janitor) will deal with them.
+
+func (c *DiskCache) List(ctx context.Context, prefix string, fn ListFunc) error {
+
+	if c.layout == DiskLayoutHashed {
+		return errors.New("Disk caches with a hashed layout can not be listed")
+	}
+
+	root := c.root
+
+	// all of the keys for an image (the first part of a key) are in the same
+	// shard, so if the prefix names one there's no need to look anywhere else
+
+	if c.layout == DiskLayoutSharded && strings.Contains(prefix, "/") {
+		root = filepath.Join(c.shardPath(prefix), strings.SplitN(prefix, "/", 2)[0])
+	}
+
+	return c.walk(ctx, root, func(abs_path string, fi os.FileInfo) error {
+
+		rel_path, err := c.relPath(abs_path)
+
+		if err != nil {
+			return err
+		}
+
+		if !strings.HasPrefix(rel_path, prefix) {
+			return nil
+		}
+
+		return fn(rel_path)
+	})
+}
+
+func (c *DiskCache) Stats(ctx context.Context) (*CacheStats, error) {
+
+	stats := CacheStats{
+		MaxBytes: c.maxsize,
+	}
+
+	err := c.walk(ctx, c.root, func(abs_path string, fi os.FileInfo) error {
+
+		stats.Keys += 1
+		stats.Bytes += fi.Size()
 
-	if os.IsNotExist(err) {
 		return nil
+	})
+
+	if err != nil {
+		return nil, err
 	}
 
-	return os.Remove(abs_path)
+	return &stats, nil
+}
+
+// absPath and relPath map keys to files (and back) according to the cache's
+// layout
+
+func (c *DiskCache) absPath(rel_path string) string {
+
+	if c.layout == DiskLayoutFlat {
+		return path.Join(c.root, rel_path)
+	}
+
+	if c.layout == DiskLayoutHashed {
+
+		hash := sha1.Sum([]byte(rel_path))
+		enc := hex.EncodeToString(hash[:])
+
+		return path.Join(c.root, enc[0:2], enc[2:4], enc)
+	}
+
+	return path.Join(c.shardPath(rel_path), rel_path)
+}
+
+// shardPath returns the directory that a key is stored below in a sharded
+// cache, which only depends on the first part of the key so that all of the
+// derivatives for an image end up in the same place
+
+func (c *DiskCache) shardPath(rel_path string) string {
+
+	id := strings.SplitN(strings.TrimLeft(rel_path, "/"), "/", 2)[0]
+
+	hash := sha1.Sum([]byte(id))
+	enc := hex.EncodeToString(hash[:])
+
+	return path.Join(c.root, enc[0:2], enc[2:4])
+}
+
+func (c *DiskCache) relPath(abs_path string) (string, error) {
+
+	rel_path, err := filepath.Rel(c.root, abs_path)
+
+	if err != nil {
+		return "", err
+	}
+
+	rel_path = filepath.ToSlash(rel_path)
+
+	if c.layout == DiskLayoutSharded {
+
+		parts := strings.SplitN(rel_path, "/", 3)
+
+		if len(parts) != 3 {
+			message := fmt.Sprintf("Unexpected file in sharded disk cache, %s", abs_path)
+			return "", errors.New(message)
+		}
+
+		rel_path = parts[2]
+	}
+
+	return rel_path, nil
+}
+
+// stat returns the details for a file whether or not it has expired
+
+func (c *DiskCache) stat(abs_path string) (*EntryInfo, error) {
+
+	fi, err := os.Stat(abs_path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	info := EntryInfo{
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+	}
+
+	if c.ttl > 0 {
+		info.Expires = fi.ModTime().Add(c.ttl)
+	}
+
+	meta, err := readDiskMeta(abs_path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if meta != nil {
+
+		if !meta.Expires.IsZero() && (info.Expires.IsZero() || meta.Expires.Before(info.Expires)) {
+			info.Expires = meta.Expires
+		}
+
+		info.ContentType = meta.ContentType
+		info.Metadata = meta.Metadata
+	}
+
+	return &info, nil
+}
+
+func (c *DiskCache) walk(ctx context.Context, root string, fn func(string, os.FileInfo) error) error {
+
+	return filepath.Walk(root, func(abs_path string, fi os.FileInfo, err error) error {
+
+		if err != nil {
+
+			// files can disappear (be evicted or expired) mid-walk
+
+			if os.IsNotExist(err) {
+				return nil
+			}
+
+			return err
+		}
+
+		err = ctx.Err()
+
+		if err != nil {
+			return err
+		}
+
+		if fi.IsDir() {
+			return nil
+		}
+
+		if strings.HasSuffix(abs_path, diskMetaExtension) {
+			return nil
+		}
+
+		if strings.HasPrefix(fi.Name(), diskTempPrefix) {
+			return nil
+		}
+
+		return fn(abs_path, fi)
+	})
+}
+
+// index builds the list of files used to decide what to evict when the cache
+// is full, least recently modified first
+
+func (c *DiskCache) index() error {
+
+	type indexedFile struct {
+		entry   *diskEntry
+		modtime time.Time
+	}
+
+	files := make([]*indexedFile, 0)
+
+	err := c.walk(context.Background(), c.root, func(abs_path string, fi os.FileInfo) error {
+
+		f := indexedFile{
+			entry: &diskEntry{
+				abs_path: abs_path,
+				size:     fi.Size(),
+			},
+			modtime: fi.ModTime(),
+		}
+
+		files = append(files, &f)
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modtime.Before(files[j].modtime)
+	})
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, f := range files {
+		c.entries[f.entry.abs_path] = c.lru.PushFront(f.entry)
+		c.size += f.entry.size
+	}
+
+	c.evict()
+	return nil
+}
+
+// add records a file that has just been written, evicting the least recently
+// used files if that makes the cache too big
+
+func (c *DiskCache) add(abs_path string, size int64) {
+
+	if c.maxsize == 0 {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	el, ok := c.entries[abs_path]
+
+	if ok {
+		c.size -= el.Value.(*diskEntry).size
+		c.lru.Remove(el)
+	}
+
+	e := diskEntry{
+		abs_path: abs_path,
+		size:     size,
+	}
+
+	c.entries[abs_path] = c.lru.PushFront(&e)
+	c.size += size
+
+	c.evict()
+}
+
+func (c *DiskCache) touch(abs_path string) {
+
+	if c.maxsize == 0 {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	el, ok := c.entries[abs_path]
+
+	if ok {
+		c.lru.MoveToFront(el)
+	}
+}
+
+// evict assumes that c.lock is held. It always keeps the most recently used
+// file, which add has just made sure is no bigger than the cache.
+
+func (c *DiskCache) evict() {
+
+	for c.size > c.maxsize && c.lru.Len() > 1 {
+
+		el := c.lru.Back()
+		e := el.Value.(*diskEntry)
+
+		err := removeDiskFiles(e.abs_path)
+
+		if err != nil {
+			log.Printf("Failed to evict %s from disk cache, %s\n", e.abs_path, err)
+		}
+
+		c.lru.Remove(el)
+		delete(c.entries, e.abs_path)
+
+		c.size -= e.size
+	}
+}
+
+func (c *DiskCache) remove(abs_path string) error {
+
+	err := removeDiskFiles(abs_path)
+
+	if err != nil {
+		return err
+	}
+
+	if c.maxsize == 0 {
+		return nil
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	el, ok := c.entries[abs_path]
+
+	if ok {
+		c.size -= el.Value.(*diskEntry).size
+		c.lru.Remove(el)
+		delete(c.entries, abs_path)
+	}
+
+	return nil
+}
+
+// janitor periodically removes expired files, along with any temporary files
+// left behind by writes that never finished
+
+func (c *DiskCache) janitor(interval time.Duration) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+
+			err := c.removeExpired()
+
+			if err != nil {
+				log.Printf("Failed to remove expired files from disk cache, %s\n", err)
+			}
+		}
+	}
+}
+
+func (c *DiskCache) removeExpired() error {
+
+	now := time.Now()
+
+	return filepath.Walk(c.root, func(abs_path string, fi os.FileInfo, err error) error {
+
+		if err != nil {
+
+			if os.IsNotExist(err) {
+				return nil
+			}
+
+			return err
+		}
+
+		if fi.IsDir() || strings.HasSuffix(abs_path, diskMetaExtension) {
+			return nil
+		}
+
+		if strings.HasPrefix(fi.Name(), diskTempPrefix) {
+
+			if now.Sub(fi.ModTime()) > time.Hour {
+				os.Remove(abs_path)
+			}
+
+			return nil
+		}
+
+		info, err := c.stat(abs_path)
+
+		if err != nil {
+			return nil
+		}
+
+		if isExpired(info) {
+			return c.remove(abs_path)
+		}
+
+		return nil
+	})
+}
+
+func janitorInterval(ttl time.Duration) time.Duration {
+
+	if ttl < time.Minute {
+		return time.Minute
+	}
+
+	if ttl > time.Hour {
+		return time.Hour
+	}
+
+	return ttl
+}
+
+func readDiskMeta(abs_path string) (*diskMeta, error) {
+
+	enc, err := ioutil.ReadFile(abs_path + diskMetaExtension)
+
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var meta diskMeta
+
+	err = json.Unmarshal(enc, &meta)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &meta, nil
+}
+
+func removeDiskFiles(abs_path string) error {
+
+	err := os.Remove(abs_path)
+
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	err = os.Remove(abs_path + diskMetaExtension)
+
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// writeFileAtomic writes body to a temporary file in the same directory as
+// abs_path and then renames it, so anyone reading abs_path sees either the old
+// file or the new one but never part of one.
+
+func writeFileAtomic(abs_path string, body []byte) error {
+
+	fh, err := ioutil.TempFile(filepath.Dir(abs_path), diskTempPrefix)
+
+	if err != nil {
+		return err
+	}
+
+	tmp_path := fh.Name()
+
+	_, err = fh.Write(body)
+
+	if err == nil {
+		err = fh.Sync()
+	}
+
+	close_err := fh.Close()
+
+	if err == nil {
+		err = close_err
+	}
+
+	if err == nil {
+		err = os.Chmod(tmp_path, 0644)
+	}
+
+	if err == nil {
+		err = os.Rename(tmp_path, abs_path)
+	}
+
+	if err != nil {
+		os.Remove(tmp_path)
+		return err
+	}
+
+	return nil
 }
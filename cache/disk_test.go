@@ -0,0 +1,99 @@
+package cache
+
+import (
+	"context"
+	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestDiskCacheSharded(t *testing.T) {
+
+	root, err := ioutil.TempDir("", "iiif-disk")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(root)
+
+	cfg := iiifconfig.CacheConfig{
+		Name:   "Disk",
+		Path:   root,
+		Layout: DiskLayoutSharded,
+		TTL:    3600,
+	}
+
+	c, err := NewDiskCache(cfg)
+
+	if err != nil {
+		t.Fatalf("Failed to create disk cache, %s", err)
+	}
+
+	defer c.Close()
+
+	keys := []string{
+		"test.jpg/full/full/0/default.jpg",
+		"test.jpg/0,0,256,256/256,/0/default.jpg",
+		"other.jpg/full/full/0/default.jpg",
+	}
+
+	for _, key := range keys {
+
+		err := c.Set(key, []byte("hello"))
+
+		if err != nil {
+			t.Fatalf("Failed to set %s, %s", key, err)
+		}
+	}
+
+	// all of the derivatives for an image are kept together
+
+	shard := c.shardPath("test.jpg")
+
+	for _, key := range keys[0:2] {
+
+		_, err := os.Stat(filepath.Join(shard, key))
+
+		if err != nil {
+			t.Fatalf("Expected %s to be in the same shard as test.jpg, %s", key, err)
+		}
+	}
+
+	body, err := c.Get(keys[1])
+
+	if err != nil || string(body) != "hello" {
+		t.Fatalf("Expected 'hello', got '%s' and %v", body, err)
+	}
+
+	found := make([]string, 0)
+
+	err = c.List(context.Background(), "test.jpg/", func(key string) error {
+		found = append(found, key)
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to list keys, %s", err)
+	}
+
+	sort.Strings(found)
+
+	if strings.Join(found, ",") != keys[1]+","+keys[0] {
+		t.Fatalf("Unexpected keys %v", found)
+	}
+
+	stats, err := c.Stats(context.Background())
+
+	if err != nil || stats.Keys != 3 {
+		t.Fatalf("Expected 3 keys, got %+v and %v", stats, err)
+	}
+
+	// closing the cache more than once is fine
+
+	c.Close()
+}
@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"context"
+	"errors"
+)
+
+// NewContextCache returns c if it is already a ContextCache and otherwise
+// wraps it so that it can be used as one. Wrapped caches ignore the options
+// passed to SetWithOptions and can't be listed or counted.
+
+func NewContextCache(c Cache) ContextCache {
+
+	cc, ok := c.(ContextCache)
+
+	if ok {
+		return cc
+	}
+
+	lc := legacyCache{
+		cache: c,
+	}
+
+	return &lc
+}
+
+type legacyCache struct {
+	ContextCache
+	cache Cache
+}
+
+func (lc *legacyCache) Exists(key string) bool {
+	return lc.cache.Exists(key)
+}
+
+func (lc *legacyCache) Get(key string) ([]byte, error) {
+	return lc.cache.Get(key)
+}
+
+func (lc *legacyCache) Set(key string, body []byte) error {
+	return lc.cache.Set(key, body)
+}
+
+func (lc *legacyCache) Unset(key string) error {
+	return lc.cache.Unset(key)
+}
+
+func (lc *legacyCache) ExistsContext(ctx context.Context, key string) (bool, error) {
+
+	err := ctx.Err()
+
+	if err != nil {
+		return false, err
+	}
+
+	return lc.cache.Exists(key), nil
+}
+
+func (lc *legacyCache) GetContext(ctx context.Context, key string) ([]byte, error) {
+
+	err := ctx.Err()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return lc.cache.Get(key)
+}
+
+func (lc *legacyCache) SetWithOptions(ctx context.Context, key string, body []byte, opts *SetOptions) error {
+
+	err := ctx.Err()
+
+	if err != nil {
+		return err
+	}
+
+	return lc.cache.Set(key, body)
+}
+
+func (lc *legacyCache) UnsetContext(ctx context.Context, key string) error {
+
+	err := ctx.Err()
+
+	if err != nil {
+		return err
+	}
+
+	return lc.cache.Unset(key)
+}
+
+func (lc *legacyCache) Stat(ctx context.Context, key string) (*EntryInfo, error) {
+
+	body, err := lc.GetContext(ctx, key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	info := EntryInfo{
+		Size: int64(len(body)),
+	}
+
+	return &info, nil
+}
+
+func (lc *legacyCache) List(ctx context.Context, prefix string, fn ListFunc) error {
+
+	return errors.New("This cache does not support listing keys")
+}
+
+func (lc *legacyCache) Stats(ctx context.Context) (*CacheStats, error) {
+
+	return nil, errors.New("This cache does not support stats")
+}
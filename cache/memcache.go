@@ -0,0 +1,420 @@
+package cache
+
+// MemcachedCache stores keys in one or more Memcached servers so that they can
+// be shared by a number of iiif-server instances. Like RedisCache the options
+// passed to SetWithOptions are stored in a second "#meta" key, along with the
+// size of the value so that Stat doesn't have to fetch it. Memcached can't
+// list the keys it holds so neither can this cache, and it can't count them
+// either.
+//
+// Memcached keys can't be longer than 250 bytes or contain spaces or control
+// characters so keys that would break those rules are replaced by their SHA-1
+// hash. Memcached also has a maximum item size (1MB by default) so this cache
+// is better suited to tiles than to large source images.
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/bradfitz/gomemcache/memcache"
+	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	iiiferrors "github.com/thisisaaronland/go-iiif/errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const memcacheMetaSuffix = "#meta"
+
+// Memcached treats expiration times of more than 30 days as a Unix timestamp
+// rather than a number of seconds
+
+const memcacheMaxRelativeExpiration = 30 * 24 * time.Hour
+
+type MemcachedCache struct {
+	ContextCache
+	client *memcache.Client
+	prefix string
+	ttl    time.Duration
+	mu     *sync.Mutex
+	hits   int64
+	misses int64
+}
+
+type memcacheMeta struct {
+	Size        int64             `json:"size"`
+	Expires     time.Time         `json:"expires,omitempty"`
+	ContentType string            `json:"content_type,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+func init() {
+
+	Register("Memcached", func(cfg iiifconfig.CacheConfig) (Cache, error) {
+
+		c, err := NewMemcachedCache(cfg)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return c, nil
+	})
+}
+
+// NewMemcachedCache returns a MemcachedCache for the servers in cfg.Address,
+// which is a comma-separated list of "host:port" strings.
+
+func NewMemcachedCache(cfg iiifconfig.CacheConfig) (*MemcachedCache, error) {
+
+	if cfg.Address == "" {
+		return nil, errors.New("Memcached cache is missing an address")
+	}
+
+	if cfg.TTL < 0 {
+		return nil, errors.New("Memcached cache TTL can not be negative")
+	}
+
+	servers := make([]string, 0)
+
+	for _, addr := range strings.Split(cfg.Address, ",") {
+
+		addr = strings.TrimSpace(addr)
+
+		if addr != "" {
+			servers = append(servers, addr)
+		}
+	}
+
+	ss := new(memcache.ServerList)
+
+	err := ss.SetServers(servers...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c := MemcachedCache{
+		client: memcache.NewFromSelector(ss),
+		prefix: cfg.Prefix,
+		ttl:    time.Duration(cfg.TTL) * time.Second,
+		mu:     new(sync.Mutex),
+	}
+
+	return &c, nil
+}
+
+func (c *MemcachedCache) Exists(key string) bool {
+
+	ok, _ := c.ExistsContext(context.Background(), key)
+	return ok
+}
+
+// ExistsContext has to fetch the key since Memcached has no other way of
+// checking whether a key exists.
+
+func (c *MemcachedCache) ExistsContext(ctx context.Context, key string) (bool, error) {
+
+	_, err := c.get(ctx, key)
+
+	if iiiferrors.IsNotFound(err) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (c *MemcachedCache) Get(key string) ([]byte, error) {
+
+	return c.GetContext(context.Background(), key)
+}
+
+func (c *MemcachedCache) GetContext(ctx context.Context, key string) ([]byte, error) {
+
+	body, err := c.get(ctx, key)
+
+	if iiiferrors.IsNotFound(err) {
+		c.count(false)
+		return nil, err
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.count(true)
+	return body, nil
+}
+
+// Stat only reads the "#meta" key for key, unless it has gone missing (it can
+// be evicted on its own) in which case the value is fetched to find its size.
+
+func (c *MemcachedCache) Stat(ctx context.Context, key string) (*EntryInfo, error) {
+
+	meta_body, err := c.get(ctx, key+memcacheMetaSuffix)
+
+	if iiiferrors.IsNotFound(err) {
+
+		body, err := c.get(ctx, key)
+
+		if err != nil {
+			return nil, err
+		}
+
+		info := EntryInfo{
+			Size: int64(len(body)),
+		}
+
+		return &info, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var meta memcacheMeta
+
+	err = json.Unmarshal(meta_body, &meta)
+
+	if err != nil {
+		return nil, err
+	}
+
+	info := EntryInfo{
+		Size:    meta.Size,
+		Expires: meta.Expires,
+	}
+
+	info.ContentType = meta.ContentType
+	info.ContentType = meta.ContentType
+	info.Metadata = meta.Metadata
+
+	return &info, nil
+}
+
+func (c *MemcachedCache) Set(key string, body []byte) error {
+
+	return c.SetWithOptions(context.Background(), key, body, nil)
+}
+
+func (c *MemcachedCache) SetWithOptions(ctx context.Context, key string, body []byte, opts *SetOptions) error {
+
+	err := ctx.Err()
+
+	if err != nil {
+		return err
+	}
+
+	ttl := c.ttl
+
+	if opts != nil && opts.TTL > 0 {
+		ttl = opts.TTL
+	}
+
+	expiration := memcacheExpiration(ttl)
+
+	item := memcache.Item{
+		Key:        c.memcacheKey(key),
+		Value:      body,
+		Expiration: expiration,
+	}
+
+	err = c.client.Set(&item)
+
+	if err != nil {
+		return typedMemcacheError(key, err)
+	}
+
+	meta := memcacheMeta{
+		Size: int64(len(body)),
+	}
+
+	if opts != nil {
+		meta.ContentType = opts.ContentType
+		meta.Metadata = opts.Metadata
+	}
+
+	if ttl > 0 {
+		meta.Expires = time.Now().Add(ttl)
+	}
+
+	enc, err := json.Marshal(meta)
+
+	if err != nil {
+		return err
+	}
+
+	meta_item := memcache.Item{
+		Key:        c.memcacheKey(key + memcacheMetaSuffix),
+		Value:      enc,
+		Expiration: expiration,
+	}
+
+	err = c.client.Set(&meta_item)
+
+	if err != nil {
+		return typedMemcacheError(key, err)
+	}
+
+	return nil
+}
+
+func (c *MemcachedCache) Unset(key string) error {
+
+	return c.UnsetContext(context.Background(), key)
+}
+
+func (c *MemcachedCache) UnsetContext(ctx context.Context, key string) error {
+
+	err := ctx.Err()
+
+	if err != nil {
+		return err
+	}
+
+	for _, k := range []string{key, key + memcacheMetaSuffix} {
+
+		err := c.client.Delete(c.memcacheKey(k))
+
+		if err != nil && err != memcache.ErrCacheMiss {
+			return typedMemcacheError(key, err)
+		}
+	}
+
+	return nil
+}
+
+func (c *MemcachedCache) List(ctx context.Context, prefix string, fn ListFunc) error {
+
+	return errors.New("Memcached caches can not be listed")
+}
+
+// Stats only reports the hits and misses for this instance of the cache.
+
+func (c *MemcachedCache) Stats(ctx context.Context) (*CacheStats, error) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := CacheStats{
+		Keys:   -1,
+		Bytes:  -1,
+		Hits:   c.hits,
+		Misses: c.misses,
+	}
+
+	return &stats, nil
+}
+
+func (c *MemcachedCache) get(ctx context.Context, key string) ([]byte, error) {
+
+	// the memcache package doesn't know about contexts so this is the best
+	// that can be done
+
+	err := ctx.Err()
+
+	if err != nil {
+		return nil, err
+	}
+
+	item, err := c.client.Get(c.memcacheKey(key))
+
+	if err != nil {
+		return nil, typedMemcacheError(key, err)
+	}
+
+	return item.Value, nil
+}
+
+func (c *MemcachedCache) memcacheKey(key string) string {
+
+	mc_key := c.prefix + key
+
+	if legalMemcacheKey(mc_key) {
+		return mc_key
+	}
+
+	hash := sha1.Sum([]byte(mc_key))
+	return hex.EncodeToString(hash[:])
+}
+
+func (c *MemcachedCache) count(hit bool) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if hit {
+		c.hits += 1
+	} else {
+		c.misses += 1
+	}
+}
+
+func memcacheExpiration(ttl time.Duration) int32 {
+
+	if ttl <= 0 {
+		return 0
+	}
+
+	if ttl > memcacheMaxRelativeExpiration {
+		return int32(time.Now().Add(ttl).Unix())
+	}
+
+	secs := int32(ttl / time.Second)
+
+	// zero means "never expires"
+
+	if secs < 1 {
+		secs = 1
+	}
+
+	return secs
+}
+
+func typedMemcacheError(key string, err error) error {
+
+	if err == memcache.ErrCacheMiss {
+		return iiiferrors.NewNotFoundError(key, errors.New("cache miss"))
+	}
+
+	if err == memcache.ErrNoServers {
+		return iiiferrors.NewUpstreamUnavailableError("Memcached", err)
+	}
+
+	var net_err net.Error
+
+	if errors.As(err, &net_err) {
+		return iiiferrors.NewUpstreamUnavailableError("Memcached", err)
+	}
+
+	var timeout_err *memcache.ConnectTimeoutError
+
+	if errors.As(err, &timeout_err) {
+		return iiiferrors.NewUpstreamUnavailableError("Memcached", err)
+	}
+
+	return err
+}
+
+func legalMemcacheKey(key string) bool {
+
+	if len(key) > 250 {
+		return false
+	}
+
+	for i := 0; i < len(key); i++ {
+
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,317 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	iiiferrors "github.com/thisisaaronland/go-iiif/errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMemcached speaks just enough of the Memcached text protocol (gets, set
+// and delete) for the gomemcache client, and counts the keys that are read
+
+type fakeMemcached struct {
+	mu    sync.Mutex
+	items map[string][]byte
+	exp   map[string]int
+	reads map[string]int
+}
+
+func (f *fakeMemcached) serve(l net.Listener) {
+
+	for {
+
+		conn, err := l.Accept()
+
+		if err != nil {
+			return
+		}
+
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeMemcached) handle(conn net.Conn) {
+
+	defer conn.Close()
+
+	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+
+	for {
+
+		line, err := rw.ReadString('\n')
+
+		if err != nil {
+			return
+		}
+
+		args := strings.Fields(line)
+
+		if len(args) == 0 {
+			continue
+		}
+
+		f.mu.Lock()
+
+		switch args[0] {
+		case "gets":
+
+			for _, k := range args[1:] {
+
+				f.reads[k] += 1
+
+				v, ok := f.items[k]
+
+				if ok {
+					fmt.Fprintf(rw, "VALUE %s 0 %d 1\r\n%s\r\n", k, len(v), v)
+				}
+			}
+
+			rw.WriteString("END\r\n")
+
+		case "set":
+
+			size, _ := strconv.Atoi(args[4])
+			exp, _ := strconv.Atoi(args[3])
+
+			buf := make([]byte, size+2)
+			_, err = io.ReadFull(rw, buf)
+
+			if err == nil {
+				f.items[args[1]] = buf[0:size]
+				f.exp[args[1]] = exp
+				rw.WriteString("STORED\r\n")
+			}
+
+		case "delete":
+
+			_, ok := f.items[args[1]]
+
+			if ok {
+				delete(f.items, args[1])
+				rw.WriteString("DELETED\r\n")
+			} else {
+				rw.WriteString("NOT_FOUND\r\n")
+			}
+
+		default:
+			rw.WriteString("ERROR\r\n")
+		}
+
+		f.mu.Unlock()
+		rw.Flush()
+	}
+}
+
+func TestMemcachedCache(t *testing.T) {
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+
+	f := fakeMemcached{
+		items: make(map[string][]byte),
+		exp:   make(map[string]int),
+		reads: make(map[string]int),
+	}
+
+	go f.serve(l)
+
+	cfg := iiifconfig.CacheConfig{
+		Name:    "Memcached",
+		Address: l.Addr().String(),
+		Prefix:  "iiif:",
+		TTL:     60,
+	}
+
+	c, err := NewMemcachedCache(cfg)
+
+	if err != nil {
+		t.Fatalf("Failed to create Memcached cache, %s", err)
+	}
+
+	ctx := context.Background()
+
+	_, err = c.Get("test.jpg")
+
+	if !iiiferrors.IsNotFound(err) {
+		t.Fatalf("Expected a NotFound error for a missing key, got %v", err)
+	}
+
+	opts := SetOptions{
+		ContentType: "image/jpeg",
+		Metadata: map[string]string{
+			"Source": "test",
+		},
+	}
+
+	err = c.SetWithOptions(ctx, "test.jpg", []byte("hello"), &opts)
+
+	if err != nil {
+		t.Fatalf("Failed to set key, %s", err)
+	}
+
+	f.mu.Lock()
+	exp := f.exp["iiif:test.jpg"] + f.exp["iiif:test.jpg#meta"]
+	f.mu.Unlock()
+
+	if exp != 120 {
+		t.Fatal("Expected keys to be stored with the cache's prefix and TTL")
+	}
+
+	body, err := c.Get("test.jpg")
+
+	if err != nil || string(body) != "hello" {
+		t.Fatalf("Expected 'hello', got '%s' and %v", body, err)
+	}
+
+	// Stat only needs the metadata, not the (possibly large) value
+
+	f.mu.Lock()
+	reads := f.reads["iiif:test.jpg"]
+	f.mu.Unlock()
+
+	info, err := c.Stat(ctx, "test.jpg")
+
+	if err != nil {
+		t.Fatalf("Failed to stat key, %s", err)
+	}
+
+	if info.Size != 5 || info.ContentType != "image/jpeg" || info.Metadata["Source"] != "test" {
+		t.Fatalf("Unexpected info %+v", info)
+	}
+
+	if d := time.Until(info.Expires); d <= 0 || d > time.Minute {
+		t.Fatalf("Expected key to expire in about 1m, got %s", d)
+	}
+
+	f.mu.Lock()
+	reads = f.reads["iiif:test.jpg"] - reads
+	f.mu.Unlock()
+
+	if reads != 0 {
+		t.Fatal("Expected Stat not to read the value")
+	}
+
+	// setting a key without options replaces its old ones
+
+	err = c.Set("test.jpg", []byte("hello world"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err = c.Stat(ctx, "test.jpg")
+
+	if err != nil || info.Size != 11 || info.ContentType != "" || info.Metadata != nil {
+		t.Fatalf("Unexpected info %+v and %v", info, err)
+	}
+
+	// keys whose metadata has gone missing can still be stat-ed
+
+	f.mu.Lock()
+	delete(f.items, "iiif:test.jpg#meta")
+	f.mu.Unlock()
+
+	info, err = c.Stat(ctx, "test.jpg")
+
+	if err != nil || info.Size != 11 {
+		t.Fatalf("Unexpected info %+v and %v", info, err)
+	}
+
+	// keys that Memcached won't accept are hashed
+
+	long := strings.Repeat("x", 300) + " y.jpg"
+
+	err = c.Set(long, []byte("hello"))
+
+	if err != nil {
+		t.Fatalf("Failed to set a long key, %s", err)
+	}
+
+	body, err = c.Get(long)
+
+	if err != nil || string(body) != "hello" {
+		t.Fatalf("Expected 'hello', got '%s' and %v", body, err)
+	}
+
+	f.mu.Lock()
+
+	for k := range f.items {
+
+		if len(k) > 250 || strings.Contains(k, " ") {
+			t.Errorf("Unexpected Memcached key '%s'", k)
+		}
+	}
+
+	f.mu.Unlock()
+
+	err = c.Unset("test.jpg")
+
+	if err != nil {
+		t.Fatalf("Failed to unset key, %s", err)
+	}
+
+	if c.Exists("test.jpg") {
+		t.Fatal("Expected key to be removed")
+	}
+
+	stats, err := c.Stats(ctx)
+
+	if err != nil || stats.Hits != 2 || stats.Misses != 1 {
+		t.Fatalf("Unexpected stats %+v and %v", stats, err)
+	}
+
+	err = c.List(ctx, "", func(string) error { return nil })
+
+	if err == nil {
+		t.Fatal("Expected Memcached caches not to be listable")
+	}
+
+	// and a server that isn't there is the upstream's fault
+
+	l.Close()
+
+	cfg.Address = l.Addr().String()
+
+	c, err = NewMemcachedCache(cfg)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.Get("test.jpg")
+
+	if iiiferrors.StatusCode(err, 500) != 503 {
+		t.Fatalf("Expected an UpstreamUnavailableError, got %v", err)
+	}
+}
+
+func TestMemcacheExpiration(t *testing.T) {
+
+	if memcacheExpiration(0) != 0 {
+		t.Fatal("Expected no TTL never to expire")
+	}
+
+	if memcacheExpiration(time.Millisecond) != 1 {
+		t.Fatal("Expected a TTL of less than a second to be rounded up")
+	}
+
+	ttl := 60 * 24 * time.Hour
+
+	if int64(memcacheExpiration(ttl)) < time.Now().Add(ttl).Unix()-1 {
+		t.Fatal("Expected a TTL of more than 30 days to be a Unix timestamp")
+	}
+}
@@ -1,148 +1,470 @@
 package cache
 
+// MemoryCache is a size-bounded least-recently-used cache. When adding a key
+// would make the cache bigger than its limit the keys that have gone unused
+// for the longest are evicted until there is room. Keys also expire after the
+// cache's TTL (or the TTL passed to SetWithOptions) and expired keys are
+// removed when they are next read or by a janitor that runs periodically. The
+// janitor is only started once there is something that can expire and is
+// stopped by Close.
+
 import (
+	"container/list"
+	"context"
 	"errors"
-	gocache "github.com/patrickmn/go-cache"
 	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	iiiferrors "github.com/thisisaaronland/go-iiif/errors"
 	_ "log"
+	"strings"
 	"sync"
 	"time"
 )
 
+const memoryJanitorInterval = 30 * time.Second
+
 type MemoryCache struct {
-	Cache
-	provider      *gocache.Cache
-	size          int
-	maxsize       int
-	sizemap       map[string]int
-	keys          []string
-	lock          *sync.Mutex
-	eviction_lock *sync.Mutex
+	ContextCache
+	ttl          time.Duration
+	size         int64
+	maxsize      int64
+	entries      map[string]*list.Element
+	lru          *list.List // most recently used at the front
+	lock         *sync.Mutex
+	janitor_once *sync.Once
+	close_once   *sync.Once
+	done         chan bool
+	hits         int64
+	misses       int64
+	evictions    int64
+	expirations  int64
 }
 
-func NewMemoryCache(cfg iiifconfig.CacheConfig) (*MemoryCache, error) {
+type memoryEntry struct {
+	key  string
+	body []byte
+	info *EntryInfo
+}
 
-	ttl := cfg.TTL
-	limit := cfg.Limit
-	window := time.Duration(ttl) * time.Second
+func init() {
 
-	gc := gocache.New(window, 30*time.Second)
+	Register("Memory", func(cfg iiifconfig.CacheConfig) (Cache, error) {
 
-	size := 0
-	maxsize := limit * 1024 * 1024
+		c, err := NewMemoryCache(cfg)
 
-	keys := make([]string, 0)
-	sizemap := make(map[string]int)
+		if err != nil {
+			return nil, err
+		}
 
-	/*
+		return c, nil
+	})
+}
+
+// NewMemoryCache returns a MemoryCache that holds at most cfg.Limit megabytes
+// for at most cfg.TTL seconds. A limit or TTL of zero means there isn't one.
 
-		see this - it's two separate locking mechanisms - that's because we need to account
-		for the sizemap and maxsize properties being updated during multiple Set events, one
-		of which may be trying purge old records to make room and/or the normal gocache janitor
-		cleaning up expired documents (20160911/thisisaaronland)
+func NewMemoryCache(cfg iiifconfig.CacheConfig) (*MemoryCache, error) {
 
-	*/
+	if cfg.TTL < 0 {
+		return nil, errors.New("Memory cache TTL can not be negative")
+	}
 
-	lock := new(sync.Mutex)
-	ev_lock := new(sync.Mutex)
+	if cfg.Limit < 0 {
+		return nil, errors.New("Memory cache limit can not be negative")
+	}
 
 	mc := MemoryCache{
-		provider:      gc,
-		size:          size,
-		keys:          keys,
-		maxsize:       maxsize,
-		sizemap:       sizemap,
-		lock:          lock,
-		eviction_lock: ev_lock,
+		ttl:          time.Duration(cfg.TTL) * time.Second,
+		size:         0,
+		maxsize:      int64(cfg.Limit) * 1024 * 1024,
+		entries:      make(map[string]*list.Element),
+		lru:          list.New(),
+		lock:         new(sync.Mutex),
+		janitor_once: new(sync.Once),
+		close_once:   new(sync.Once),
+		done:         make(chan bool),
 	}
 
-	gc.OnEvicted(mc.OnEvicted)
+	if mc.ttl > 0 {
+		mc.startJanitor()
+	}
 
 	return &mc, nil
 }
 
-func (mc *MemoryCache) Exists(key string) bool {
+// Close stops the cache's janitor, if it has one. The cache can still be used
+// after it has been closed but expired keys are only removed when they are
+// read (or evicted to make room for new ones).
+
+func (mc *MemoryCache) Close() error {
+
+	mc.close_once.Do(func() {
+		close(mc.done)
+	})
 
-	_, ok := mc.provider.Get(key)
+	return nil
+}
 
+func (mc *MemoryCache) Exists(key string) bool {
+
+	ok, _ := mc.ExistsContext(context.Background(), key)
 	return ok
 }
 
-func (mc *MemoryCache) Get(key string) ([]byte, error) {
+// ExistsContext reports whether key is in the cache without counting as a use
+// of it, either for the LRU or for the hit and miss counters.
+
+func (mc *MemoryCache) ExistsContext(ctx context.Context, key string) (bool, error) {
+
+	err := ctx.Err()
+
+	if err != nil {
+		return false, err
+	}
+
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
 
-	data, ok := mc.provider.Get(key)
+	el, ok := mc.entries[key]
 
 	if !ok {
-		return nil, errors.New("cache miss")
+		return false, nil
+	}
+
+	e := el.Value.(*memoryEntry)
+
+	if isExpired(e.info) {
+		mc.expire(el)
+		return false, nil
 	}
 
-	return data.([]byte), nil
+	return true, nil
 }
 
-func (mc *MemoryCache) Set(key string, data []byte) error {
+func (mc *MemoryCache) Get(key string) ([]byte, error) {
+
+	return mc.GetContext(context.Background(), key)
+}
+
+func (mc *MemoryCache) GetContext(ctx context.Context, key string) ([]byte, error) {
+
+	e, err := mc.entry(ctx, key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return e.body, nil
+}
+
+// Stat returns a copy of the details for key. Like ExistsContext it doesn't
+// count as a use of key, since revalidation and tiered caches call it for
+// every image they read.
+
+func (mc *MemoryCache) Stat(ctx context.Context, key string) (*EntryInfo, error) {
+
+	err := ctx.Err()
+
+	if err != nil {
+		return nil, err
+	}
 
 	mc.lock.Lock()
 	defer mc.lock.Unlock()
 
-	_, ok := mc.sizemap[key]
+	el, ok := mc.entries[key]
 
-	if ok {
-		return nil
+	if !ok {
+		return nil, iiiferrors.NewNotFoundError(key, errors.New("cache miss"))
+	}
+
+	e := el.Value.(*memoryEntry)
+
+	if isExpired(e.info) {
+		mc.expire(el)
+		return nil, iiiferrors.NewNotFoundError(key, errors.New("cache miss"))
 	}
 
-	size := len(data)
+	return copyEntryInfo(e.info), nil
+}
+
+func (mc *MemoryCache) Set(key string, data []byte) error {
+
+	return mc.SetWithOptions(context.Background(), key, data, nil)
+}
+
+func (mc *MemoryCache) SetWithOptions(ctx context.Context, key string, data []byte, opts *SetOptions) error {
+
+	err := ctx.Err()
 
-	if size > mc.maxsize {
+	if err != nil {
+		return err
+	}
+
+	size := int64(len(data))
+
+	if mc.maxsize > 0 && size > mc.maxsize {
 		return errors.New("Key is too big!")
 	}
 
-	if size+mc.size > mc.maxsize {
+	now := time.Now()
+	ttl := mc.ttl
 
-		for mc.size > mc.maxsize-size {
+	info := EntryInfo{
+		Size:    size,
+		ModTime: now,
+	}
 
-			for _, k := range mc.keys {
-				mc.Unset(k)
-			}
+	if opts != nil {
+
+		info.ContentType = opts.ContentType
+		info.Metadata = copyMetadata(opts.Metadata)
+
+		if opts.TTL > 0 {
+			ttl = opts.TTL
 		}
+	}
 
+	if ttl > 0 {
+		info.Expires = now.Add(ttl)
+		mc.startJanitor()
 	}
 
-	mc.eviction_lock.Lock()
-	defer mc.eviction_lock.Unlock()
+	e := memoryEntry{
+		key:  key,
+		body: data,
+		info: &info,
+	}
 
-	mc.size += size
-	mc.sizemap[key] = size
-	mc.keys = append(mc.keys, key)
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	el, ok := mc.entries[key]
+
+	if ok {
+		mc.remove(el)
+	}
+
+	if mc.maxsize > 0 {
+
+		for mc.size+size > mc.maxsize {
+
+			oldest := mc.lru.Back()
+
+			if oldest == nil {
+				break
+			}
+
+			mc.remove(oldest)
+			mc.evictions += 1
+		}
+	}
 
-	mc.provider.Set(key, data, gocache.DefaultExpiration)
+	mc.entries[key] = mc.lru.PushFront(&e)
+	mc.size += size
 
 	return nil
 }
 
 func (mc *MemoryCache) Unset(key string) error {
 
-	mc.provider.Delete(key)
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	el, ok := mc.entries[key]
+
+	if ok {
+		mc.remove(el)
+	}
+
 	return nil
 }
 
-func (mc *MemoryCache) OnEvicted(key string, value interface{}) {
+func (mc *MemoryCache) UnsetContext(ctx context.Context, key string) error {
 
-	mc.eviction_lock.Lock()
-	defer mc.eviction_lock.Unlock()
+	err := ctx.Err()
 
-	size, _ := mc.sizemap[key]
-	mc.size -= size
+	if err != nil {
+		return err
+	}
 
-	delete(mc.sizemap, key)
+	return mc.Unset(key)
+}
+
+func (mc *MemoryCache) List(ctx context.Context, prefix string, fn ListFunc) error {
+
+	// copy the keys so that fn is free to call other methods (like Unset)
+	// without deadlocking
+
+	mc.lock.Lock()
+
+	keys := make([]string, 0)
+
+	for key, el := range mc.entries {
+
+		e := el.Value.(*memoryEntry)
+
+		if isExpired(e.info) {
+			continue
+		}
+
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+
+	mc.lock.Unlock()
+
+	for _, key := range keys {
+
+		err := ctx.Err()
+
+		if err != nil {
+			return err
+		}
+
+		err = fn(key)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (mc *MemoryCache) Stats(ctx context.Context) (*CacheStats, error) {
+
+	err := ctx.Err()
+
+	if err != nil {
+		return nil, err
+	}
+
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	stats := CacheStats{
+		Keys:        int64(len(mc.entries)),
+		Bytes:       mc.size,
+		MaxBytes:    mc.maxsize,
+		Hits:        mc.hits,
+		Misses:      mc.misses,
+		Evictions:   mc.evictions,
+		Expirations: mc.expirations,
+	}
+
+	return &stats, nil
+}
+
+func (mc *MemoryCache) entry(ctx context.Context, key string) (*memoryEntry, error) {
+
+	err := ctx.Err()
+
+	if err != nil {
+		return nil, err
+	}
+
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	el, ok := mc.entries[key]
 
-	new_keys := make([]string, 0)
+	if !ok {
+		mc.misses += 1
+		return nil, iiiferrors.NewNotFoundError(key, errors.New("cache miss"))
+	}
+
+	e := el.Value.(*memoryEntry)
+
+	if isExpired(e.info) {
+		mc.expire(el)
+		mc.misses += 1
+		return nil, iiiferrors.NewNotFoundError(key, errors.New("cache miss"))
+	}
+
+	mc.lru.MoveToFront(el)
+	mc.hits += 1
 
-	for _, k := range mc.keys {
+	return e, nil
+}
+
+// remove and expire assume that mc.lock is held
+
+func (mc *MemoryCache) remove(el *list.Element) {
 
-		if k != key {
-			new_keys = append(new_keys, k)
+	e := el.Value.(*memoryEntry)
+
+	mc.lru.Remove(el)
+	delete(mc.entries, e.key)
+
+	mc.size -= e.info.Size
+}
+
+func (mc *MemoryCache) expire(el *list.Element) {
+
+	mc.remove(el)
+	mc.expirations += 1
+}
+
+func (mc *MemoryCache) startJanitor() {
+
+	mc.janitor_once.Do(func() {
+		go mc.janitor(memoryJanitorInterval)
+	})
+}
+
+func (mc *MemoryCache) janitor(interval time.Duration) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+
+		select {
+		case <-mc.done:
+			return
+		case <-ticker.C:
+			mc.removeExpired()
 		}
 	}
+}
+
+func (mc *MemoryCache) removeExpired() {
+
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	for el := mc.lru.Back(); el != nil; {
+
+		prev := el.Prev()
+
+		if isExpired(el.Value.(*memoryEntry).info) {
+			mc.expire(el)
+		}
+
+		el = prev
+	}
+}
+
+func copyEntryInfo(info *EntryInfo) *EntryInfo {
+
+	c := *info
+	c.Metadata = copyMetadata(info.Metadata)
+
+	return &c
+}
+
+func copyMetadata(metadata map[string]string) map[string]string {
+
+	if metadata == nil {
+		return nil
+	}
+
+	c := make(map[string]string)
+
+	for k, v := range metadata {
+		c[k] = v
+	}
 
-	mc.keys = new_keys
+	return c
 }
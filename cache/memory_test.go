@@ -0,0 +1,180 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	iiiferrors "github.com/thisisaaronland/go-iiif/errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryCacheLRU(t *testing.T) {
+
+	c, err := NewMemoryCache(iiifconfig.CacheConfig{Limit: 1})
+
+	if err != nil {
+		t.Fatalf("Failed to create memory cache, %s", err)
+	}
+
+	defer c.Close()
+
+	ctx := context.Background()
+	body := bytes.Repeat([]byte("x"), 400*1024)
+
+	for _, key := range []string{"a", "b"} {
+
+		err := c.Set(key, body)
+
+		if err != nil {
+			t.Fatalf("Failed to set %s, %s", key, err)
+		}
+	}
+
+	// reading "a" makes "b" the least recently used key, but asking about
+	// "b" doesn't count as using it
+
+	_, err = c.Get("a")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.Stat(ctx, "b")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !c.Exists("b") {
+		t.Fatal("Expected b to exist")
+	}
+
+	err = c.Set("c", body)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Exists("b") {
+		t.Fatal("Expected the least recently used key to be evicted")
+	}
+
+	if !c.Exists("a") || !c.Exists("c") {
+		t.Fatal("Expected the most recently used keys to be kept")
+	}
+
+	stats, err := c.Stats(ctx)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.Keys != 2 || stats.Bytes != int64(2*len(body)) || stats.Hits != 1 || stats.Misses != 0 || stats.Evictions != 1 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+
+	err = c.Set("big", bytes.Repeat([]byte("x"), 2*1024*1024))
+
+	if err == nil {
+		t.Fatal("Expected a key bigger than the cache to fail")
+	}
+}
+
+func TestMemoryCacheStat(t *testing.T) {
+
+	c, err := NewMemoryCache(iiifconfig.CacheConfig{})
+
+	if err != nil {
+		t.Fatalf("Failed to create memory cache, %s", err)
+	}
+
+	ctx := context.Background()
+
+	opts := SetOptions{
+		ContentType: "image/jpeg",
+		Metadata: map[string]string{
+			"Source": "test",
+		},
+	}
+
+	err = c.SetWithOptions(ctx, "test.jpg", []byte("hello"), &opts)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// neither the options that were passed in nor the details that are
+	// returned share anything with what is stored
+
+	opts.Metadata["Source"] = "changed"
+
+	info, err := c.Stat(ctx, "test.jpg")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Size != 5 || info.ContentType != "image/jpeg" || info.Metadata["Source"] != "test" {
+		t.Fatalf("Unexpected info %+v", info)
+	}
+
+	info.Metadata["Source"] = "changed"
+
+	info, err = c.Stat(ctx, "test.jpg")
+
+	if err != nil || info.Metadata["Source"] != "test" {
+		t.Fatalf("Unexpected info %+v and %v", info, err)
+	}
+
+	_, err = c.Stat(ctx, "missing.jpg")
+
+	if !iiiferrors.IsNotFound(err) {
+		t.Fatalf("Expected a NotFound error, got %v", err)
+	}
+
+	stats, _ := c.Stats(ctx)
+
+	if stats.Hits != 0 || stats.Misses != 0 {
+		t.Fatalf("Expected Stat not to count hits or misses, got %+v", stats)
+	}
+}
+
+func TestMemoryCacheTTL(t *testing.T) {
+
+	c, err := NewMemoryCache(iiifconfig.CacheConfig{})
+
+	if err != nil {
+		t.Fatalf("Failed to create memory cache, %s", err)
+	}
+
+	defer c.Close()
+
+	ctx := context.Background()
+
+	err = c.SetWithOptions(ctx, "test.jpg", []byte("hello"), &SetOptions{TTL: 50 * time.Millisecond})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.Get("test.jpg")
+
+	if err != nil {
+		t.Fatalf("Expected key not to have expired yet, %s", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	_, err = c.Get("test.jpg")
+
+	if !iiiferrors.IsNotFound(err) {
+		t.Fatalf("Expected a NotFound error for an expired key, got %v", err)
+	}
+
+	stats, _ := c.Stats(ctx)
+
+	if stats.Keys != 0 || stats.Expirations != 1 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+}
@@ -1,12 +1,28 @@
 package cache
 
 import (
+	"context"
 	"errors"
 	"github.com/thisisaaronland/go-iiif/config"
+	iiiferrors "github.com/thisisaaronland/go-iiif/errors"
 )
 
 type NullCache struct {
-	Cache
+	ContextCache
+}
+
+func init() {
+
+	Register("Null", func(cfg config.CacheConfig) (Cache, error) {
+
+		c, err := NewNullCache(cfg)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return c, nil
+	})
 }
 
 func NewNullCache(cfg config.CacheConfig) (*NullCache, error) {
@@ -23,7 +39,7 @@ func (c *NullCache) Exists(rel_path string) bool {
 func (c *NullCache) Get(rel_path string) ([]byte, error) {
 
 	err := errors.New("null cache is null")
-	return nil, err
+	return nil, iiiferrors.NewNotFoundError(rel_path, err)
 }
 
 func (c *NullCache) Set(rel_path string, body []byte) error {
@@ -35,3 +51,35 @@ func (c *NullCache) Unset(rel_path string) error {
 
 	return nil
 }
+
+func (c *NullCache) ExistsContext(ctx context.Context, rel_path string) (bool, error) {
+	return false, nil
+}
+
+func (c *NullCache) GetContext(ctx context.Context, rel_path string) ([]byte, error) {
+	return c.Get(rel_path)
+}
+
+func (c *NullCache) SetWithOptions(ctx context.Context, rel_path string, body []byte, opts *SetOptions) error {
+	return nil
+}
+
+func (c *NullCache) UnsetContext(ctx context.Context, rel_path string) error {
+	return nil
+}
+
+func (c *NullCache) Stat(ctx context.Context, rel_path string) (*EntryInfo, error) {
+
+	err := errors.New("null cache is null")
+	return nil, iiiferrors.NewNotFoundError(rel_path, err)
+}
+
+func (c *NullCache) List(ctx context.Context, prefix string, fn ListFunc) error {
+	return nil
+}
+
+func (c *NullCache) Stats(ctx context.Context) (*CacheStats, error) {
+
+	stats := CacheStats{}
+	return &stats, nil
+}
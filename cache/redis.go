@@ -0,0 +1,451 @@
+package cache
+
+// RedisCache stores keys in a Redis server so that they can be shared by a
+// number of iiif-server instances. Each key's body is stored as-is in a Redis
+// string, with the options passed to SetWithOptions (if there are any) stored
+// as JSON in a second key with the same name plus "#meta".
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gomodule/redigo/redis"
+	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	iiiferrors "github.com/thisisaaronland/go-iiif/errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const redisMetaSuffix = "#meta"
+
+type RedisCache struct {
+	ContextCache
+	pool   *redis.Pool
+	prefix string
+	ttl    time.Duration
+	mu     *sync.Mutex
+	hits   int64
+	misses int64
+}
+
+type redisMeta struct {
+	ContentType string            `json:"content_type,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+func init() {
+
+	Register("Redis", func(cfg iiifconfig.CacheConfig) (Cache, error) {
+
+		c, err := NewRedisCache(cfg)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return c, nil
+	})
+}
+
+// NewRedisCache returns a RedisCache for the server at cfg.Address, which is
+// either a "host:port" string or a "redis://" URL (which may include a
+// password and a database number). A non-zero cfg.DB overrides the database
+// in the URL.
+
+func NewRedisCache(cfg iiifconfig.CacheConfig) (*RedisCache, error) {
+
+	address := cfg.Address
+
+	if address == "" {
+		return nil, errors.New("Redis cache is missing an address")
+	}
+
+	if cfg.TTL < 0 {
+		return nil, errors.New("Redis cache TTL can not be negative")
+	}
+
+	opts := make([]redis.DialOption, 0)
+
+	if cfg.DB != 0 {
+		opts = append(opts, redis.DialDatabase(cfg.DB))
+	}
+
+	dial := func(ctx context.Context) (redis.Conn, error) {
+
+		if strings.HasPrefix(address, "redis://") || strings.HasPrefix(address, "rediss://") {
+			return redis.DialURLContext(ctx, address, opts...)
+		}
+
+		return redis.DialContext(ctx, "tcp", address, opts...)
+	}
+
+	pool := redis.Pool{
+		DialContext: dial,
+		MaxIdle:     10,
+		IdleTimeout: 240 * time.Second,
+		TestOnBorrow: func(conn redis.Conn, t time.Time) error {
+
+			if time.Since(t) < time.Minute {
+				return nil
+			}
+
+			_, err := conn.Do("PING")
+			return err
+		},
+	}
+
+	c := RedisCache{
+		pool:   &pool,
+		prefix: cfg.Prefix,
+		ttl:    time.Duration(cfg.TTL) * time.Second,
+		mu:     new(sync.Mutex),
+	}
+
+	return &c, nil
+}
+
+func (c *RedisCache) Exists(key string) bool {
+
+	ok, _ := c.ExistsContext(context.Background(), key)
+	return ok
+}
+
+func (c *RedisCache) ExistsContext(ctx context.Context, key string) (bool, error) {
+
+	count, err := redis.Int64(c.do(ctx, "EXISTS", c.prefix+key))
+
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+func (c *RedisCache) Get(key string) ([]byte, error) {
+
+	return c.GetContext(context.Background(), key)
+}
+
+func (c *RedisCache) GetContext(ctx context.Context, key string) ([]byte, error) {
+
+	body, err := redis.Bytes(c.do(ctx, "GET", c.prefix+key))
+
+	if err == redis.ErrNil {
+		c.count(false)
+		return nil, iiiferrors.NewNotFoundError(key, errors.New("cache miss"))
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.count(true)
+	return body, nil
+}
+
+func (c *RedisCache) Stat(ctx context.Context, key string) (*EntryInfo, error) {
+
+	conn, err := c.conn(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+
+	redis_key := c.prefix + key
+
+	conn.Send("MULTI")
+	conn.Send("STRLEN", redis_key)
+	conn.Send("PTTL", redis_key)
+	conn.Send("GET", redis_key+redisMetaSuffix)
+
+	rsp, err := redis.Values(redis.DoContext(conn, ctx, "EXEC"))
+
+	if err != nil {
+		return nil, typedRedisError(err)
+	}
+
+	size, _ := redis.Int64(rsp[0], nil)
+	pttl, _ := redis.Int64(rsp[1], nil)
+
+	// PTTL is -2 for keys that don't exist and -1 for keys that don't expire
+
+	if pttl == -2 {
+		return nil, iiiferrors.NewNotFoundError(key, errors.New("cache miss"))
+	}
+
+	info := EntryInfo{
+		Size: size,
+	}
+
+	if pttl > 0 {
+		info.Expires = time.Now().Add(time.Duration(pttl) * time.Millisecond)
+	}
+
+	enc, err := redis.Bytes(rsp[2], nil)
+
+	if err == nil {
+
+		var meta redisMeta
+
+		err = json.Unmarshal(enc, &meta)
+
+		if err != nil {
+			return nil, err
+		}
+
+		info.ContentType = meta.ContentType
+		info.Metadata = meta.Metadata
+	}
+
+	return &info, nil
+}
+
+func (c *RedisCache) Set(key string, body []byte) error {
+
+	return c.SetWithOptions(context.Background(), key, body, nil)
+}
+
+func (c *RedisCache) SetWithOptions(ctx context.Context, key string, body []byte, opts *SetOptions) error {
+
+	conn, err := c.conn(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	redis_key := c.prefix + key
+	meta_key := redis_key + redisMetaSuffix
+
+	ttl := c.ttl
+
+	if opts != nil && opts.TTL > 0 {
+		ttl = opts.TTL
+	}
+
+	var meta_enc []byte
+
+	if opts != nil && (opts.ContentType != "" || len(opts.Metadata) > 0) {
+
+		meta := redisMeta{
+			ContentType: opts.ContentType,
+			Metadata:    opts.Metadata,
+		}
+
+		meta_enc, err = json.Marshal(meta)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	set_args := []interface{}{redis_key, body}
+	meta_args := []interface{}{meta_key, meta_enc}
+
+	if ttl > 0 {
+		set_args = append(set_args, "PX", ttl.Milliseconds())
+		meta_args = append(meta_args, "PX", ttl.Milliseconds())
+	}
+
+	conn.Send("MULTI")
+	conn.Send("SET", set_args...)
+
+	if meta_enc != nil {
+		conn.Send("SET", meta_args...)
+	} else {
+		conn.Send("DEL", meta_key)
+	}
+
+	_, err = redis.DoContext(conn, ctx, "EXEC")
+
+	if err != nil {
+		return typedRedisError(err)
+	}
+
+	return nil
+}
+
+func (c *RedisCache) Unset(key string) error {
+
+	return c.UnsetContext(context.Background(), key)
+}
+
+func (c *RedisCache) UnsetContext(ctx context.Context, key string) error {
+
+	redis_key := c.prefix + key
+
+	_, err := c.do(ctx, "DEL", redis_key, redis_key+redisMetaSuffix)
+	return err
+}
+
+// List uses SCAN to find the keys that start with prefix, which means that
+// (like SCAN) a key that is added or removed while List is running may or may
+// not be included.
+
+func (c *RedisCache) List(ctx context.Context, prefix string, fn ListFunc) error {
+
+	return c.scan(ctx, prefix, func(conn redis.Conn, key string) error {
+		return fn(key)
+	})
+}
+
+// Stats counts the keys (and their sizes) with the cache's prefix, rather
+// than using DBSIZE, since a Redis database may be shared with other things.
+// Hits and misses are only those for this instance of the cache.
+
+func (c *RedisCache) Stats(ctx context.Context) (*CacheStats, error) {
+
+	stats := CacheStats{}
+
+	err := c.scan(ctx, "", func(conn redis.Conn, key string) error {
+
+		size, err := redis.Int64(redis.DoContext(conn, ctx, "STRLEN", c.prefix+key))
+
+		if err != nil {
+			return typedRedisError(err)
+		}
+
+		stats.Keys += 1
+		stats.Bytes += size
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats.Hits = c.hits
+	stats.Misses = c.misses
+
+	return &stats, nil
+}
+
+func (c *RedisCache) scan(ctx context.Context, prefix string, fn func(redis.Conn, string) error) error {
+
+	conn, err := c.conn(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	match := redisEscapePattern(c.prefix+prefix) + "*"
+	cursor := "0"
+
+	for {
+
+		rsp, err := redis.Values(redis.DoContext(conn, ctx, "SCAN", cursor, "MATCH", match, "COUNT", 1000))
+
+		if err != nil {
+			return typedRedisError(err)
+		}
+
+		cursor, err = redis.String(rsp[0], nil)
+
+		if err != nil {
+			return err
+		}
+
+		keys, err := redis.Strings(rsp[1], nil)
+
+		if err != nil {
+			return err
+		}
+
+		for _, redis_key := range keys {
+
+			if strings.HasSuffix(redis_key, redisMetaSuffix) {
+				continue
+			}
+
+			err := fn(conn, strings.TrimPrefix(redis_key, c.prefix))
+
+			if err != nil {
+				return err
+			}
+		}
+
+		if cursor == "0" {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (c *RedisCache) conn(ctx context.Context) (redis.Conn, error) {
+
+	conn, err := c.pool.GetContext(ctx)
+
+	if err != nil {
+		return nil, typedRedisError(err)
+	}
+
+	return conn, nil
+}
+
+func (c *RedisCache) do(ctx context.Context, cmd string, args ...interface{}) (interface{}, error) {
+
+	conn, err := c.conn(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+
+	rsp, err := redis.DoContext(conn, ctx, cmd, args...)
+
+	if err != nil && err != redis.ErrNil {
+		return nil, typedRedisError(err)
+	}
+
+	return rsp, err
+}
+
+func (c *RedisCache) count(hit bool) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if hit {
+		c.hits += 1
+	} else {
+		c.misses += 1
+	}
+}
+
+// typedRedisError maps network errors (a Redis server that is down or
+// unreachable, or that has gone away) to UpstreamUnavailableError
+
+func typedRedisError(err error) error {
+
+	var net_err net.Error
+
+	if errors.As(err, &net_err) {
+		return iiiferrors.NewUpstreamUnavailableError("Redis", err)
+	}
+
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return iiiferrors.NewUpstreamUnavailableError("Redis", err)
+	}
+
+	return err
+}
+
+func redisEscapePattern(s string) string {
+
+	r := strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+	return r.Replace(s)
+}
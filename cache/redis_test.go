@@ -0,0 +1,173 @@
+package cache
+
+import (
+	"context"
+	"github.com/alicebob/miniredis"
+	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	iiiferrors "github.com/thisisaaronland/go-iiif/errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRedisCache(t *testing.T) {
+
+	mr, err := miniredis.Run()
+
+	if err != nil {
+		t.Fatalf("Failed to start miniredis, %s", err)
+	}
+
+	defer mr.Close()
+
+	cfg := iiifconfig.CacheConfig{
+		Name:    "Redis",
+		Address: mr.Addr(),
+		Prefix:  "iiif:",
+		TTL:     60,
+	}
+
+	c, err := NewRedisCache(cfg)
+
+	if err != nil {
+		t.Fatalf("Failed to create Redis cache, %s", err)
+	}
+
+	ctx := context.Background()
+
+	_, err = c.Get("a/full.jpg")
+
+	if !iiiferrors.IsNotFound(err) {
+		t.Fatalf("Expected a NotFound error for a missing key, got %v", err)
+	}
+
+	err = c.Set("a/full.jpg", []byte("hello"))
+
+	if err != nil {
+		t.Fatalf("Failed to set key, %s", err)
+	}
+
+	body, err := c.Get("a/full.jpg")
+
+	if err != nil || string(body) != "hello" {
+		t.Fatalf("Unexpected body '%s', %v", body, err)
+	}
+
+	if ttl := mr.TTL("iiif:a/full.jpg"); ttl != 60*time.Second {
+		t.Fatalf("Expected the cache's TTL of 60s, got %s", ttl)
+	}
+
+	// options are stored in a #meta key with the same TTL as the key
+
+	opts := SetOptions{
+		TTL:         10 * time.Second,
+		ContentType: "image/jpeg",
+		Metadata:    map[string]string{"source": "example"},
+	}
+
+	err = c.SetWithOptions(ctx, "a/square.jpg", []byte("square"), &opts)
+
+	if err != nil {
+		t.Fatalf("Failed to set key, %s", err)
+	}
+
+	if ttl := mr.TTL("iiif:a/square.jpg" + redisMetaSuffix); ttl != 10*time.Second {
+		t.Fatalf("Expected #meta key to have a TTL of 10s, got %s", ttl)
+	}
+
+	info, err := c.Stat(ctx, "a/square.jpg")
+
+	if err != nil {
+		t.Fatalf("Failed to stat key, %s", err)
+	}
+
+	if info.Size != 6 || info.ContentType != "image/jpeg" || info.Metadata["source"] != "example" {
+		t.Fatalf("Unexpected info %+v", info)
+	}
+
+	if remaining := time.Until(info.Expires); remaining <= 0 || remaining > 10*time.Second {
+		t.Fatalf("Expected key to expire in the next 10s, got %s", remaining)
+	}
+
+	// keys that aren't ours aren't listed or counted
+
+	mr.Set("other:a/full.jpg", "nope")
+	c.Set("b/full.jpg", []byte("b"))
+
+	keys := make(map[string]bool)
+
+	err = c.List(ctx, "a/", func(key string) error {
+		keys[key] = true
+		return nil
+	})
+
+	if err != nil || len(keys) != 2 || !keys["a/full.jpg"] || !keys["a/square.jpg"] {
+		t.Fatalf("Unexpected keys %v, %v", keys, err)
+	}
+
+	stats, err := c.Stats(ctx)
+
+	if err != nil {
+		t.Fatalf("Failed to get stats, %s", err)
+	}
+
+	if stats.Keys != 3 || stats.Hits != 1 || stats.Misses != 1 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+
+	mr.FastForward(30 * time.Second)
+
+	_, err = c.Stat(ctx, "a/square.jpg")
+
+	if !iiiferrors.IsNotFound(err) {
+		t.Fatalf("Expected a NotFound error for an expired key, got %v", err)
+	}
+
+	// setting a key without options removes any old #meta key and Unset
+	// removes both
+
+	c.SetWithOptions(ctx, "a/square.jpg", []byte("square"), &opts)
+	c.Set("a/square.jpg", []byte("square"))
+
+	if mr.Exists("iiif:a/square.jpg" + redisMetaSuffix) {
+		t.Fatal("Expected #meta key to be removed")
+	}
+
+	c.SetWithOptions(ctx, "a/square.jpg", []byte("square"), &opts)
+	c.Unset("a/square.jpg")
+
+	if mr.Exists("iiif:a/square.jpg") || mr.Exists("iiif:a/square.jpg"+redisMetaSuffix) {
+		t.Fatal("Expected Unset to remove the key and its #meta key")
+	}
+}
+
+func TestRedisCacheUnavailable(t *testing.T) {
+
+	mr, err := miniredis.Run()
+
+	if err != nil {
+		t.Fatalf("Failed to start miniredis, %s", err)
+	}
+
+	c, err := NewRedisCache(iiifconfig.CacheConfig{Name: "Redis", Address: mr.Addr()})
+
+	if err != nil {
+		t.Fatalf("Failed to create Redis cache, %s", err)
+	}
+
+	mr.Close()
+
+	ctx := context.Background()
+
+	_, get_err := c.Get("test.jpg")
+	set_err := c.Set("test.jpg", []byte("hello"))
+	_, stat_err := c.Stat(ctx, "test.jpg")
+	_, exists_err := c.ExistsContext(ctx, "test.jpg")
+
+	for _, err := range []error{get_err, set_err, stat_err, exists_err} {
+
+		if iiiferrors.StatusCode(err, 0) != http.StatusServiceUnavailable {
+			t.Fatalf("Expected an unavailable Redis server to be a 503, got %v", err)
+		}
+	}
+}
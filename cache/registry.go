@@ -0,0 +1,51 @@
+package cache
+
+// See notes in source/registry.go
+
+import (
+	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	"sort"
+	"sync"
+)
+
+type CacheInitializeFunc func(cfg iiifconfig.CacheConfig) (Cache, error)
+
+var caches_mu = new(sync.RWMutex)
+var caches = make(map[string]CacheInitializeFunc)
+
+// Register makes a cache available by name, as in the "images.cache.name" and
+// "derivatives.cache.name" config properties. Like sql.Register it panics if
+// it's called twice with the same name or with a nil function.
+
+func Register(name string, init_func CacheInitializeFunc) {
+
+	caches_mu.Lock()
+	defer caches_mu.Unlock()
+
+	if init_func == nil {
+		panic("cache: Register function is nil")
+	}
+
+	_, dupe := caches[name]
+
+	if dupe {
+		panic("cache: Register called twice for cache " + name)
+	}
+
+	caches[name] = init_func
+}
+
+func Caches() []string {
+
+	caches_mu.RLock()
+	defer caches_mu.RUnlock()
+
+	names := make([]string, 0)
+
+	for name, _ := range caches {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
@@ -1,14 +1,45 @@
 package cache
 
 import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
 	iiifaws "github.com/thisisaaronland/go-iiif/aws"
 	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	iiiferrors "github.com/thisisaaronland/go-iiif/errors"
+	"io/ioutil"
+	"strings"
+	"time"
 )
 
+// S3 doesn't expire objects on its own (not without a bucket lifecycle rule,
+// anyway, which works in days rather than seconds) so the expiry time for a
+// key set with a TTL is stored in its metadata and checked when it's read. It
+// is only kept there, rather than in the object's Expires header, since that
+// header is for browsers and CDNs which have their own idea of how long things
+// should be cached for (the "http.max_age" config block).
+
+const s3ExpiresMetadata = "Iiif-Expires"
+
 type S3Cache struct {
+	ContextCache
 	S3 *iiifaws.S3Connection
 }
 
+func init() {
+
+	Register("S3", func(cfg iiifconfig.CacheConfig) (Cache, error) {
+
+		c, err := NewS3Cache(cfg)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return c, nil
+	})
+}
+
 func NewS3Cache(cfg iiifconfig.CacheConfig) (*S3Cache, error) {
 
 	bucket := cfg.Path
@@ -17,10 +48,17 @@ func NewS3Cache(cfg iiifconfig.CacheConfig) (*S3Cache, error) {
 	creds := cfg.Credentials
 
 	s3cfg := iiifaws.S3Config{
-		Bucket:      bucket,
-		Prefix:      prefix,
-		Region:      region,
-		Credentials: creds,
+		Bucket:               bucket,
+		Prefix:               prefix,
+		Region:               region,
+		Credentials:          creds,
+		Endpoint:             cfg.Endpoint,
+		PathStyle:            cfg.PathStyle,
+		ACL:                  cfg.ACL,
+		StorageClass:         cfg.StorageClass,
+		ServerSideEncryption: cfg.ServerSideEncryption,
+		KMSKeyID:             cfg.KMSKeyID,
+		CacheControl:         cfg.CacheControl,
 	}
 
 	s3, err := iiifaws.NewS3Connection(s3cfg)
@@ -38,18 +76,66 @@ func NewS3Cache(cfg iiifconfig.CacheConfig) (*S3Cache, error) {
 
 func (c *S3Cache) Exists(key string) bool {
 
-	_, err := c.S3.Head(key)
+	ok, _ := c.ExistsContext(context.Background(), key)
+	return ok
+}
+
+func (c *S3Cache) ExistsContext(ctx context.Context, key string) (bool, error) {
+
+	_, err := c.Stat(ctx, key)
+
+	if iiiferrors.IsNotFound(err) {
+		return false, nil
+	}
 
 	if err != nil {
-		return false
+		return false, err
 	}
 
-	return true
+	return true, nil
 }
 
 func (c *S3Cache) Get(key string) ([]byte, error) {
 
-	return c.S3.Get(key)
+	return c.GetContext(context.Background(), key)
+}
+
+func (c *S3Cache) GetContext(ctx context.Context, key string) ([]byte, error) {
+
+	rsp, err := c.S3.GetWithContext(ctx, key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rsp.Body.Close()
+
+	info := s3EntryInfo(rsp.ContentLength, rsp.LastModified, rsp.ContentType, rsp.Metadata)
+
+	if isExpired(info) {
+		c.S3.DeleteWithContext(ctx, key)
+		return nil, iiiferrors.NewNotFoundError(key, nil)
+	}
+
+	return ioutil.ReadAll(rsp.Body)
+}
+
+func (c *S3Cache) Stat(ctx context.Context, key string) (*EntryInfo, error) {
+
+	rsp, err := c.S3.HeadWithContext(ctx, key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	info := s3EntryInfo(rsp.ContentLength, rsp.LastModified, rsp.ContentType, rsp.Metadata)
+
+	if isExpired(info) {
+		c.S3.DeleteWithContext(ctx, key)
+		return nil, iiiferrors.NewNotFoundError(key, nil)
+	}
+
+	return info, nil
 }
 
 func (c *S3Cache) Set(key string, body []byte) error {
@@ -57,7 +143,100 @@ func (c *S3Cache) Set(key string, body []byte) error {
 	return c.S3.Put(key, body)
 }
 
+func (c *S3Cache) SetWithOptions(ctx context.Context, key string, body []byte, opts *SetOptions) error {
+
+	if opts == nil {
+		return c.S3.PutWithContext(ctx, key, body, nil)
+	}
+
+	metadata := make(map[string]string)
+
+	for k, v := range opts.Metadata {
+		metadata[k] = v
+	}
+
+	put_opts := iiifaws.PutOptions{
+		ContentType: opts.ContentType,
+		Metadata:    metadata,
+	}
+
+	if opts.TTL > 0 {
+
+		expires := time.Now().Add(opts.TTL)
+		metadata[s3ExpiresMetadata] = expires.UTC().Format(time.RFC3339)
+	}
+
+	return c.S3.PutWithContext(ctx, key, body, &put_opts)
+}
+
 func (c *S3Cache) Unset(key string) error {
 
 	return c.S3.Delete(key)
 }
+
+func (c *S3Cache) UnsetContext(ctx context.Context, key string) error {
+
+	return c.S3.DeleteWithContext(ctx, key)
+}
+
+// List lists all the keys that start with prefix. Like DiskCache it doesn't
+// bother checking whether they've expired since that would mean a HEAD request
+// for every key.
+
+func (c *S3Cache) List(ctx context.Context, prefix string, fn ListFunc) error {
+
+	return c.S3.List(ctx, prefix, func(key string, obj *s3.Object) error {
+		return fn(key)
+	})
+}
+
+func (c *S3Cache) Stats(ctx context.Context) (*CacheStats, error) {
+
+	stats := CacheStats{}
+
+	err := c.S3.List(ctx, "", func(key string, obj *s3.Object) error {
+
+		stats.Keys += 1
+		stats.Bytes += aws.Int64Value(obj.Size)
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
+
+func s3EntryInfo(size *int64, modtime *time.Time, content_type *string, metadata map[string]*string) *EntryInfo {
+
+	info := EntryInfo{
+		Size:        aws.Int64Value(size),
+		ModTime:     aws.TimeValue(modtime),
+		ContentType: aws.StringValue(content_type),
+	}
+
+	if len(metadata) > 0 {
+
+		info.Metadata = make(map[string]string)
+
+		for k, v := range metadata {
+
+			if strings.EqualFold(k, s3ExpiresMetadata) {
+
+				t, err := time.Parse(time.RFC3339, aws.StringValue(v))
+
+				if err == nil {
+					info.Expires = t
+				}
+
+				continue
+			}
+
+			info.Metadata[k] = aws.StringValue(v)
+		}
+	}
+
+	return &info
+}
@@ -0,0 +1,443 @@
+package cache
+
+// TieredCache is a stack of caches, for example memory in front of disk in
+// front of S3, that is configured as an ordered list of "tiers" each of which
+// is an ordinary cache config:
+//
+//	"cache": {
+//		"name": "Tiered",
+//		"write_policy": "through",
+//		"tiers": [
+//			{ "name": "Memory", "ttl": 300, "limit": 100 },
+//			{ "name": "Disk", "path": "example/cache", "ttl": 86400 },
+//			{ "name": "S3", "path": "your.S3.bucket", "region": "us-east-1" }
+//		]
+//	}
+//
+// Reads try each tier in order and a key found in a lower tier is copied
+// ("promoted"), along with its content type and metadata, to all the tiers above
+// it in the background. Writes go to every tier, either all
+// at once ("through", the default) or to the first tier immediately and the
+// rest in the background ("behind"). A tier's "ttl" property is the longest
+// that keys are kept in that tier, whatever kind of cache it is.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	iiiferrors "github.com/thisisaaronland/go-iiif/errors"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	WriteThrough = "through"
+	WriteBehind  = "behind"
+)
+
+// promotions happen after the read that triggered them has returned so they
+// get their own (generous) deadline rather than the reader's context
+
+const tieredPromoteTimeout = 30 * time.Second
+
+type TieredCache struct {
+	ContextCache
+	tiers        []*cacheTier
+	write_policy string
+	mu           *sync.Mutex
+	promoting    map[string]bool
+	hits         int64
+	misses       int64
+}
+
+type cacheTier struct {
+	name  string
+	cache ContextCache
+	ttl   time.Duration
+}
+
+func init() {
+
+	Register("Tiered", func(cfg iiifconfig.CacheConfig) (Cache, error) {
+
+		c, err := NewTieredCache(cfg)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return c, nil
+	})
+}
+
+func NewTieredCache(cfg iiifconfig.CacheConfig) (*TieredCache, error) {
+
+	if len(cfg.Tiers) == 0 {
+		return nil, errors.New("Tiered cache has no tiers")
+	}
+
+	write_policy := cfg.WritePolicy
+
+	if write_policy == "" {
+		write_policy = WriteThrough
+	}
+
+	if write_policy != WriteThrough && write_policy != WriteBehind {
+		message := fmt.Sprintf("Invalid tiered cache write policy '%s'", write_policy)
+		return nil, errors.New(message)
+	}
+
+	tiers := make([]*cacheTier, 0)
+
+	for _, tier_cfg := range cfg.Tiers {
+
+		c, err := NewCacheFromConfig(tier_cfg)
+
+		if err != nil {
+			return nil, err
+		}
+
+		t := cacheTier{
+			name:  tier_cfg.Name,
+			cache: NewContextCache(c),
+			ttl:   time.Duration(tier_cfg.TTL) * time.Second,
+		}
+
+		tiers = append(tiers, &t)
+	}
+
+	tc := TieredCache{
+		tiers:        tiers,
+		write_policy: write_policy,
+		mu:           new(sync.Mutex),
+		promoting:    make(map[string]bool),
+	}
+
+	return &tc, nil
+}
+
+func (tc *TieredCache) Exists(key string) bool {
+
+	ok, _ := tc.ExistsContext(context.Background(), key)
+	return ok
+}
+
+func (tc *TieredCache) ExistsContext(ctx context.Context, key string) (bool, error) {
+
+	for _, t := range tc.tiers {
+
+		ok, err := t.cache.ExistsContext(ctx, key)
+
+		if err != nil {
+			return false, err
+		}
+
+		if ok {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (tc *TieredCache) Get(key string) ([]byte, error) {
+
+	return tc.GetContext(context.Background(), key)
+}
+
+// GetContext returns key from the first tier that has it, promoting it to the
+// tiers above that one. A tier that fails (rather than just not having the
+// key) is skipped so that, say, S3 being unreachable doesn't stop the disk
+// tier from being used, but if no tier has the key and one of them failed
+// that error is returned instead of a not found error.
+
+func (tc *TieredCache) GetContext(ctx context.Context, key string) ([]byte, error) {
+
+	var last_err error
+
+	for i, t := range tc.tiers {
+
+		body, err := t.cache.GetContext(ctx, key)
+
+		if err == nil {
+
+			tc.count(true)
+			tc.promote(i, key, body)
+
+			return body, nil
+		}
+
+		ctx_err := ctx.Err()
+
+		if ctx_err != nil {
+			return nil, ctx_err
+		}
+
+		if !iiiferrors.IsNotFound(err) {
+			last_err = err
+		}
+	}
+
+	tc.count(false)
+
+	if last_err != nil {
+		return nil, last_err
+	}
+
+	return nil, iiiferrors.NewNotFoundError(key, errors.New("cache miss"))
+}
+
+func (tc *TieredCache) Stat(ctx context.Context, key string) (*EntryInfo, error) {
+
+	for _, t := range tc.tiers {
+
+		info, err := t.cache.Stat(ctx, key)
+
+		if iiiferrors.IsNotFound(err) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		return info, nil
+	}
+
+	return nil, iiiferrors.NewNotFoundError(key, errors.New("cache miss"))
+}
+
+func (tc *TieredCache) Set(key string, body []byte) error {
+
+	return tc.SetWithOptions(context.Background(), key, body, nil)
+}
+
+func (tc *TieredCache) SetWithOptions(ctx context.Context, key string, body []byte, opts *SetOptions) error {
+
+	if tc.write_policy == WriteThrough {
+		return tc.setTiers(ctx, tc.tiers, key, body, opts)
+	}
+
+	err := tc.setTiers(ctx, tc.tiers[0:1], key, body, opts)
+
+	if err != nil {
+		return err
+	}
+
+	// the request that caused this write may well be done (and its context
+	// cancelled) long before the lower tiers are
+
+	go func() {
+
+		err := tc.setTiers(context.Background(), tc.tiers[1:], key, body, opts)
+
+		if err != nil {
+			log.Printf("Failed to write %s to lower cache tiers, %s\n", key, err)
+		}
+	}()
+
+	return nil
+}
+
+func (tc *TieredCache) Unset(key string) error {
+
+	return tc.UnsetContext(context.Background(), key)
+}
+
+func (tc *TieredCache) UnsetContext(ctx context.Context, key string) error {
+
+	for _, t := range tc.tiers {
+
+		err := t.cache.UnsetContext(ctx, key)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// List lists the keys in all the tiers, each key only once.
+
+func (tc *TieredCache) List(ctx context.Context, prefix string, fn ListFunc) error {
+
+	seen := make(map[string]bool)
+
+	for _, t := range tc.tiers {
+
+		err := t.cache.List(ctx, prefix, func(key string) error {
+
+			if seen[key] {
+				return nil
+			}
+
+			seen[key] = true
+			return fn(key)
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Stats returns the number of keys and bytes in the lowest tier, which is the
+// one that holds (or will eventually hold) everything, and the hits and misses
+// for the tiered cache as a whole. Use TierStats for the details of each tier.
+
+func (tc *TieredCache) Stats(ctx context.Context) (*CacheStats, error) {
+
+	last := tc.tiers[len(tc.tiers)-1]
+
+	last_stats, err := last.cache.Stats(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	stats := CacheStats{
+		Keys:     last_stats.Keys,
+		Bytes:    last_stats.Bytes,
+		MaxBytes: last_stats.MaxBytes,
+		Hits:     tc.hits,
+		Misses:   tc.misses,
+	}
+
+	return &stats, nil
+}
+
+func (tc *TieredCache) TierStats(ctx context.Context) ([]*CacheStats, error) {
+
+	all_stats := make([]*CacheStats, 0)
+
+	for _, t := range tc.tiers {
+
+		stats, err := t.cache.Stats(ctx)
+
+		if err != nil {
+			message := fmt.Sprintf("Failed to get stats for %s tier, %s", t.name, err)
+			return nil, errors.New(message)
+		}
+
+		all_stats = append(all_stats, stats)
+	}
+
+	return all_stats, nil
+}
+
+// promote copies a key found in tier i to the tiers above it, in the
+// background, with the content type and metadata (and whatever is left of the
+// TTL) it has in tier i. A key that is already being promoted isn't promoted
+// again and failures are only logged since the key has already been found.
+
+func (tc *TieredCache) promote(i int, key string, body []byte) {
+
+	if i == 0 {
+		return
+	}
+
+	tc.mu.Lock()
+
+	if tc.promoting[key] {
+		tc.mu.Unlock()
+		return
+	}
+
+	tc.promoting[key] = true
+	tc.mu.Unlock()
+
+	go func() {
+
+		defer func() {
+			tc.mu.Lock()
+			delete(tc.promoting, key)
+			tc.mu.Unlock()
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), tieredPromoteTimeout)
+		defer cancel()
+
+		info, err := tc.tiers[i].cache.Stat(ctx, key)
+
+		if iiiferrors.IsNotFound(err) {
+			return
+		}
+
+		if err != nil {
+			log.Printf("Failed to promote %s from %s tier, %s\n", key, tc.tiers[i].name, err)
+			return
+		}
+
+		opts := SetOptions{
+			ContentType: info.ContentType,
+			Metadata:    info.Metadata,
+		}
+
+		if !info.Expires.IsZero() {
+
+			ttl := time.Until(info.Expires)
+
+			if ttl <= 0 {
+				return
+			}
+
+			opts.TTL = ttl
+		}
+
+		err = tc.setTiers(ctx, tc.tiers[0:i], key, body, &opts)
+
+		if err != nil {
+			log.Printf("Failed to promote %s, %s\n", key, err)
+		}
+	}()
+}
+
+// setTiers writes key to each of tiers, with the TTL capped by the tier's own
+// TTL, and returns the first error (after trying all of them).
+
+func (tc *TieredCache) setTiers(ctx context.Context, tiers []*cacheTier, key string, body []byte, opts *SetOptions) error {
+
+	var first_err error
+
+	for _, t := range tiers {
+
+		tier_opts := SetOptions{}
+
+		if opts != nil {
+			tier_opts = *opts
+		}
+
+		if t.ttl > 0 && (tier_opts.TTL <= 0 || tier_opts.TTL > t.ttl) {
+			tier_opts.TTL = t.ttl
+		}
+
+		err := t.cache.SetWithOptions(ctx, key, body, &tier_opts)
+
+		if err != nil && first_err == nil {
+			message := fmt.Sprintf("Failed to write %s to %s tier, %s", key, t.name, err)
+			first_err = errors.New(message)
+		}
+	}
+
+	return first_err
+}
+
+func (tc *TieredCache) count(hit bool) {
+
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	if hit {
+		tc.hits += 1
+	} else {
+		tc.misses += 1
+	}
+}
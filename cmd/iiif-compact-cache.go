@@ -0,0 +1,74 @@
+package main
+
+// Compact a "Bolt" cache, reclaiming the space used by deleted and expired
+// keys. bbolt only lets one process write to a database file at a time so
+// this needs to be run while nothing else (like iiif-server) is using it.
+
+import (
+	"context"
+	"flag"
+	iiifcache "github.com/thisisaaronland/go-iiif/cache"
+	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	"log"
+	"os"
+)
+
+func main() {
+
+	var cfg = flag.String("config", "", "Path to a valid go-iiif config file")
+	var which = flag.String("cache", "derivatives", "Which cache to compact, valid options are: derivatives, images")
+
+	flag.Parse()
+
+	if *cfg == "" {
+		log.Fatal("Missing config file")
+	}
+
+	config, err := iiifconfig.NewConfigFromFile(*cfg)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var cache_cfg iiifconfig.CacheConfig
+
+	if *which == "derivatives" {
+		cache_cfg = config.Derivatives.Cache
+	} else if *which == "images" {
+		cache_cfg = config.Images.Cache
+	} else {
+		log.Fatalf("Invalid cache '%s'", *which)
+	}
+
+	if cache_cfg.Name != "Bolt" {
+		log.Fatalf("The %s cache is a %s cache, not a Bolt cache", *which, cache_cfg.Name)
+	}
+
+	cache, err := iiifcache.NewBoltCache(cache_cfg)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer cache.Close()
+
+	before, err := os.Stat(cache_cfg.Path)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = cache.Compact(context.Background())
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	after, err := os.Stat(cache_cfg.Path)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Compacted %s from %d to %d bytes\n", cache_cfg.Path, before.Size(), after.Size())
+}
@@ -36,6 +36,7 @@ func Sorted(h map[string]FeatureDetails) []string {
 func main() {
 
 	var cfg = flag.String("config", "", "Path to a valid go-iiif config file")
+	var version = flag.String("version", "", "The version of the IIIF Image API to dump features for (default is whatever the config file says, or \"2\")")
 
 	flag.Parse()
 
@@ -49,7 +50,11 @@ func main() {
 		log.Fatal(err)
 	}
 
-	level, err := iiiflevel.NewLevelFromConfig(config, "example.com")
+	if *version == "" {
+		*version = config.Level.Version
+	}
+
+	level, err := iiiflevel.NewLevelFromConfigWithVersion(config, "example.com", *version)
 
 	if err != nil {
 		log.Fatal(err)
@@ -61,7 +66,7 @@ func main() {
 
 	//
 
-	spec, err := iiifcompliance.NewLevel2ComplianceSpec()
+	spec, err := iiifcompliance.NewComplianceSpecWithVersion(level.Compliance().Level(), level.Compliance().Version())
 
 	if err != nil {
 		log.Fatal(err)
@@ -137,7 +142,8 @@ func main() {
 
 		rules := fd[p]
 
-		fmt.Printf("\n##### [%s](http://iiif.io/api/image/2.1/index.html#%s)\n", p, p)
+		spec_version := iiifcompliance.SpecVersion(level.Compliance().Version())
+		fmt.Printf("\n##### [%s](http://iiif.io/api/image/%s/index.html#%s)\n", p, spec_version, p)
 		fmt.Printf("| feature | syntax | required (spec) | supported (spec) | required (config) | supported (config) |\n")
 		fmt.Printf("|---|---|---|---|---|---|\n")
 
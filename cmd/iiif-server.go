@@ -1,6 +1,9 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha1"
 	"encoding/json"
 	"errors"
 	"expvar"
@@ -9,13 +12,17 @@ import (
 	"github.com/facebookgo/grace/gracehttp"
 	"github.com/gorilla/mux"
 	iiifcache "github.com/thisisaaronland/go-iiif/cache"
+	iiifcompliance "github.com/thisisaaronland/go-iiif/compliance"
 	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	iiiferrors "github.com/thisisaaronland/go-iiif/errors"
 	iiifimage "github.com/thisisaaronland/go-iiif/image"
 	iiiflevel "github.com/thisisaaronland/go-iiif/level"
 	iiifprofile "github.com/thisisaaronland/go-iiif/profile"
+	iiifresolver "github.com/thisisaaronland/go-iiif/resolver"
 	iiifsource "github.com/thisisaaronland/go-iiif/source"
 	"github.com/whosonfirst/go-sanitize"
 	"log"
+	"mime"
 	"net/http"
 	"net/url"
 	"os"
@@ -32,12 +39,23 @@ var cacheSet *expvar.Int
 
 var transformsCount *expvar.Int
 var transformsAvgTime *expvar.Float
+var transformsCoalesced *expvar.Int
 
 var transforms_counter int64
 var transforms_timer int64
 
 var timers_mu *sync.Mutex
 
+// IIIFRoute is attached to the context of requests that arrive by way of one
+// of the (versioned) prefixes defined in the "level.prefixes" config block
+
+type IIIFRoute struct {
+	Version string
+	Prefix  string
+}
+
+type iiifRouteKey struct{}
+
 type IIIFParameters struct {
 	Identifier string
 	Region     string
@@ -153,6 +171,7 @@ func init() {
 
 	transformsCount = expvar.NewInt("TransformsCount")
 	transformsAvgTime = expvar.NewFloat("TransformsAvgTimeMS")
+	transformsCoalesced = expvar.NewInt("TransformsCoalesced")
 
 	transforms_counter = 0
 	transforms_timer = 0
@@ -219,8 +238,93 @@ func ExpvarHandlerFunc(host string) (http.HandlerFunc, error) {
 	return http.HandlerFunc(f), nil
 }
 
+// PublishCacheStats adds the stats for a cache to the expvar variables, but
+// only for memory caches since asking a disk or S3 cache for its stats means
+// walking every key in it.
+
+func PublishCacheStats(name string, c iiifcache.Cache) {
+
+	mc, ok := c.(*iiifcache.MemoryCache)
+
+	if !ok {
+		return
+	}
+
+	expvar.Publish(name, expvar.Func(func() interface{} {
+
+		stats, err := mc.Stats(context.Background())
+
+		if err != nil {
+			return nil
+		}
+
+		return stats
+	}))
+}
+
+func VersionHandlerFunc(version string, prefix string, next http.HandlerFunc) (http.HandlerFunc, error) {
+
+	f := func(w http.ResponseWriter, r *http.Request) {
+
+		route := IIIFRoute{
+			Version: version,
+			Prefix:  prefix,
+		}
+
+		ctx := context.WithValue(r.Context(), iiifRouteKey{}, route)
+		next(w, r.WithContext(ctx))
+	}
+
+	return http.HandlerFunc(f), nil
+}
+
+// VersionFromRequest works out which version of the Image API to speak. An
+// explicit route prefix always wins, followed by the "profile" parameter of
+// the Accept header and finally whatever the config file says (or the default)
+
+func VersionFromRequest(config *iiifconfig.Config, r *http.Request) (string, error) {
+
+	route, ok := r.Context().Value(iiifRouteKey{}).(IIIFRoute)
+
+	if ok {
+		return route.Version, nil
+	}
+
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+
+		_, params, err := mime.ParseMediaType(accept)
+
+		if err != nil {
+			continue
+		}
+
+		for _, uri := range strings.Fields(params["profile"]) {
+
+			version, ok := iiifcompliance.VersionFromContextURI(uri)
+
+			if ok {
+				return version, nil
+			}
+		}
+	}
+
+	return iiifcompliance.EnsureVersion(config.Level.Version)
+}
+
 func InfoHandlerFunc(config *iiifconfig.Config) (http.HandlerFunc, error) {
 
+	images_source, err := iiifsource.NewSourceFromConfig(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	resolver, err := iiifresolver.NewResolverFromConfig(config)
+
+	if err != nil {
+		return nil, err
+	}
+
 	f := func(w http.ResponseWriter, r *http.Request) {
 
 		parser, err := NewIIIFQueryParser(r)
@@ -237,23 +341,65 @@ func InfoHandlerFunc(config *iiifconfig.Config) (http.HandlerFunc, error) {
 			return
 		}
 
-		image, err := iiifimage.NewImageFromConfig(config, id)
+		src_id, err := resolver.Resolve(id)
 
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, err.Error(), iiiferrors.StatusCode(err, http.StatusInternalServerError))
 			return
 		}
 
 		endpoint := EndpointFromRequest(r)
 
-		level, err := iiiflevel.NewLevelFromConfig(config, endpoint)
+		version, err := VersionFromRequest(config, r)
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		level, err := iiiflevel.NewLevelFromConfigWithVersion(config, endpoint, version)
 
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		profile, err := iiifprofile.NewProfile(endpoint, image, level)
+		content_type := InfoContentType(r, level)
+		key := fmt.Sprintf("%s/%s/info.json", endpoint, id)
+
+		modtime := LastModified(images_source, src_id)
+		etag := SourceETag(version+"#"+key+"#"+content_type, modtime)
+		max_age := config.HTTP.MaxAge.Info
+
+		w.Header().Set("Vary", "Accept")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+
+		if NotModified(r, etag, modtime) {
+			ServeNotModified(w, etag, modtime, max_age)
+			return
+		}
+
+		image, err := iiifimage.NewImageFromConfig(config, src_id)
+
+		if err != nil {
+			http.Error(w, err.Error(), iiiferrors.StatusCode(err, http.StatusInternalServerError))
+			return
+		}
+
+		// info.json files describe the public identifier, not wherever
+		// the resolver says the image is actually kept
+
+		if src_id != id {
+
+			err = image.Rename(id)
+
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		profile, err := iiifprofile.NewProfileFromConfig(config, endpoint, image, level)
 
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -267,10 +413,7 @@ func InfoHandlerFunc(config *iiifconfig.Config) (http.HandlerFunc, error) {
 			return
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Write(b)
-
+		ServeBody(w, r, key, content_type, b, etag, modtime, max_age)
 	}
 
 	return http.HandlerFunc(f), nil
@@ -278,6 +421,20 @@ func InfoHandlerFunc(config *iiifconfig.Config) (http.HandlerFunc, error) {
 
 func ImageHandlerFunc(config *iiifconfig.Config, images_cache iiifcache.Cache, derivatives_cache iiifcache.Cache) (http.HandlerFunc, error) {
 
+	transforms := iiifcache.NewCoalescer()
+
+	images_source, err := iiifsource.NewSourceFromConfig(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	resolver, err := iiifresolver.NewResolverFromConfig(config)
+
+	if err != nil {
+		return nil, err
+	}
+
 	f := func(w http.ResponseWriter, r *http.Request) {
 
 		/*
@@ -306,7 +463,15 @@ func ImageHandlerFunc(config *iiifconfig.Config, images_cache iiifcache.Cache, d
 		}
 
 		endpoint := EndpointFromRequest(r)
-		level, err := iiiflevel.NewLevelFromConfig(config, endpoint)
+
+		version, err := VersionFromRequest(config, r)
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		level, err := iiiflevel.NewLevelFromConfigWithVersion(config, endpoint, version)
 
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -316,10 +481,14 @@ func ImageHandlerFunc(config *iiifconfig.Config, images_cache iiifcache.Cache, d
 		transformation, err := iiifimage.NewTransformation(level, params.Region, params.Size, params.Rotation, params.Quality, params.Format)
 
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			http.Error(w, err.Error(), iiiferrors.StatusCode(err, http.StatusBadRequest))
 			return
 		}
 
+		// derivatives are always cached using the public identifier, which
+		// is what iiif-tile-seed uses for alternate IDs, and the source image
+		// is read using whatever the resolver maps that identifier to
+
 		uri, err := transformation.ToURI(params.Identifier)
 
 		if err != nil {
@@ -327,7 +496,35 @@ func ImageHandlerFunc(config *iiifconfig.Config, images_cache iiifcache.Cache, d
 			return
 		}
 
-		body, err := derivatives_cache.Get(uri)
+		key, err := transformation.ToCacheKey(params.Identifier)
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		src_id, err := resolver.Resolve(params.Identifier)
+
+		if err != nil {
+			http.Error(w, err.Error(), iiiferrors.StatusCode(err, http.StatusInternalServerError))
+			return
+		}
+
+		// conditional requests are answered before the derivatives cache is read
+		// (or anything is transformed) if the source can say when the image was
+		// last modified without having to read it
+
+		modtime := LastModified(images_source, src_id)
+		etag := SourceETag(key, modtime)
+		max_age := config.HTTP.MaxAge.Image
+
+		if NotModified(r, etag, modtime) {
+			SetImageHeaders(w, level, endpoint, uri)
+			ServeNotModified(w, etag, modtime, max_age)
+			return
+		}
+
+		body, err := derivatives_cache.Get(key)
 
 		if err == nil {
 
@@ -336,15 +533,26 @@ func ImageHandlerFunc(config *iiifconfig.Config, images_cache iiifcache.Cache, d
 			source, _ := iiifsource.NewMemorySource(body)
 			image, _ := iiifimage.NewImageFromConfigWithSource(config, source, "cache")
 
-			w.Header().Set("Content-Type", image.ContentType())
-			w.Write(image.Body())
+			SetImageHeaders(w, level, endpoint, uri)
+			ServeBody(w, r, uri, image.ContentType(), image.Body(), etag, modtime, max_age)
 			return
 		}
 
-		image, err := iiifimage.NewImageFromConfigWithCache(config, images_cache, params.Identifier)
+		if !transformation.HasTransformation() {
 
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			image, err := iiifimage.NewImageFromConfigWithCacheAndSource(config, images_cache, images_source, src_id)
+
+			if err != nil {
+				http.Error(w, err.Error(), iiiferrors.StatusCode(err, http.StatusInternalServerError))
+				return
+			}
+
+			if modtime.IsZero() {
+				modtime = StatLastModified(r.Context(), images_source, src_id)
+			}
+
+			SetImageHeaders(w, level, endpoint, uri)
+			ServeBody(w, r, uri, image.ContentType(), image.Body(), etag, modtime, max_age)
 			return
 		}
 
@@ -353,7 +561,16 @@ func ImageHandlerFunc(config *iiifconfig.Config, images_cache iiifcache.Cache, d
 			(20160901/thisisaaronland)
 		*/
 
-		if transformation.HasTransformation() {
+		// concurrent requests for the same derivative share a single transformation
+		// (and a single cache write)
+
+		body, shared, err := transforms.Do(key, func() ([]byte, error) {
+
+			image, err := iiifimage.NewImageFromConfigWithCacheAndSource(config, images_cache, images_source, src_id)
+
+			if err != nil {
+				return nil, err
+			}
 
 			cacheMiss.Add(1)
 
@@ -362,8 +579,7 @@ func ImageHandlerFunc(config *iiifconfig.Config, images_cache iiifcache.Cache, d
 			t2 := time.Since(t1)
 
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
+				return nil, err
 			}
 
 			go func(t time.Duration) {
@@ -384,23 +600,315 @@ func ImageHandlerFunc(config *iiifconfig.Config, images_cache iiifcache.Cache, d
 				timers_mu.Unlock()
 			}(t2)
 
-			go func(k string, im iiifimage.Image) {
+			body := image.Body()
+
+			go func(k string, b []byte) {
 
-				derivatives_cache.Set(k, im.Body())
+				derivatives_cache.Set(k, b)
 				cacheSet.Add(1)
 
-			}(uri, image)
+			}(key, body)
+
+			return body, nil
+		})
+
+		if err != nil {
+			http.Error(w, err.Error(), iiiferrors.StatusCode(err, http.StatusInternalServerError))
+			return
+		}
+
+		if shared {
+			transformsCoalesced.Add(1)
 		}
 
-		w.Header().Set("Content-Type", image.ContentType())
-		w.Write(image.Body())
+		// sources that can't say when an image was last modified cheaply (like
+		// S3) are asked now, since the image has been read anyway, rather than
+		// for every request. The ETag is still derived from the body so that it
+		// is the same one that is sent when the derivative is cached.
+
+		if modtime.IsZero() {
+			modtime = StatLastModified(r.Context(), images_source, src_id)
+		}
+
+		source, _ := iiifsource.NewMemorySource(body)
+		image, _ := iiifimage.NewImageFromConfigWithSource(config, source, "cache")
+
+		SetImageHeaders(w, level, endpoint, uri)
+		ServeBody(w, r, uri, image.ContentType(), image.Body(), etag, modtime, max_age)
 		return
 	}
 
 	return http.HandlerFunc(f), nil
 }
 
-func EndpointFromRequest(r *http.Request) string {
+// BaseURIHandlerFunc redirects requests for an image's base URI to its info.json
+// file, as required by the "baseUriRedirect" feature.
+
+func BaseURIHandlerFunc(config *iiifconfig.Config) (http.HandlerFunc, error) {
+
+	f := func(w http.ResponseWriter, r *http.Request) {
+
+		parser, err := NewIIIFQueryParser(r)
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		_, err = parser.GetIIIFParameter("identifier")
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		version, err := VersionFromRequest(config, r)
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		level, err := iiiflevel.NewLevelFromConfigWithVersion(config, EndpointFromRequest(r), version)
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if !level.Compliance().Spec().HTTP["baseUriRedirect"].Supported {
+			http.NotFound(w, r)
+			return
+		}
+
+		// use the (still escaped) path so that identifiers containing slashes or
+		// other reserved characters survive the round trip; the route prefix, if
+		// there is one, is already part of the path
+
+		path := strings.TrimRight(r.URL.EscapedPath(), "/")
+		location := fmt.Sprintf("%s%s/info.json", BaseURLFromRequest(r), path)
+
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		http.Redirect(w, r, location, http.StatusSeeOther)
+	}
+
+	return http.HandlerFunc(f), nil
+}
+
+// InfoContentType returns the JSON-LD media type (with the @context URI as its
+// profile) if the client asked for it and the level supports it, and plain old
+// "application/json" otherwise.
+
+func InfoContentType(r *http.Request, level iiiflevel.Level) string {
+
+	compliance := level.Compliance()
+
+	if !compliance.Spec().HTTP["jsonldMediaType"].Supported {
+		return "application/json"
+	}
+
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+
+		media_type, _, err := mime.ParseMediaType(accept)
+
+		if err != nil {
+			continue
+		}
+
+		if media_type == "application/ld+json" {
+			return fmt.Sprintf("application/ld+json;profile=\"%s\"", iiifcompliance.ContextURI(compliance.Version()))
+		}
+	}
+
+	return "application/json"
+}
+
+// SetImageHeaders adds the CORS and (profile and canonical) Link headers to an
+// image response, for whichever of those features the level supports. The
+// canonical URI is the same one that ToURI returns and the derivatives cache uses.
+
+func SetImageHeaders(w http.ResponseWriter, level iiiflevel.Level, endpoint string, uri string) {
+
+	compliance := level.Compliance()
+	http_spec := compliance.Spec().HTTP
+
+	if http_spec["cors"].Supported {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	}
+
+	links := make([]string, 0)
+
+	if http_spec["profileLinkHeader"].Supported {
+		profile_uri := iiifcompliance.ComplianceURI(compliance.Version(), compliance.Level())
+		links = append(links, fmt.Sprintf("<%s>;rel=\"profile\"", profile_uri))
+	}
+
+	if http_spec["canonicalLinkHeader"].Supported {
+		links = append(links, fmt.Sprintf("<%s/%s>;rel=\"canonical\"", endpoint, uri))
+	}
+
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+}
+
+// ServeBody writes body with an ETag and optionally a Cache-Control header, and
+// lets http.ServeContent take care of conditional (If-None-Match,
+// If-Modified-Since) and range requests. If etag is empty it is derived from
+// key (which is usually the canonical URI), the content type and the body
+// itself. A zero modtime means that no Last-Modified header is sent.
+
+func ServeBody(w http.ResponseWriter, r *http.Request, key string, content_type string, body []byte, etag string, modtime time.Time, max_age int) {
+
+	if etag == "" {
+
+		hash := sha1.New()
+		hash.Write([]byte(key))
+		hash.Write([]byte(content_type))
+		hash.Write(body)
+
+		etag = fmt.Sprintf("\"%x\"", hash.Sum(nil))
+	}
+
+	w.Header().Set("Content-Type", content_type)
+	w.Header().Set("ETag", etag)
+
+	if max_age > 0 {
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", max_age))
+	}
+
+	http.ServeContent(w, r, "", modtime, bytes.NewReader(body))
+}
+
+// SourceETag returns a (weak) ETag for a response derived from a source image
+// that doesn't depend on the response body, so that conditional requests can be
+// answered without producing it. It is built from key, which should be unique
+// to the response (like the derivatives cache key), and the time the source
+// image was last modified. If that isn't known the ETag is empty and ServeBody
+// will hash the body instead.
+
+func SourceETag(key string, modtime time.Time) string {
+
+	if modtime.IsZero() {
+		return ""
+	}
+
+	hash := sha1.New()
+	hash.Write([]byte(key))
+	hash.Write([]byte(modtime.UTC().Format(time.RFC3339Nano)))
+
+	return fmt.Sprintf("W/\"%x\"", hash.Sum(nil))
+}
+
+// NotModified reports whether a GET or HEAD request is conditional and the
+// client's copy is still good, following the same rules as http.ServeContent:
+// If-None-Match wins if it is present and is compared (weakly) to etag,
+// otherwise If-Modified-Since is compared to modtime.
+
+func NotModified(r *http.Request, etag string, modtime time.Time) bool {
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	inm := r.Header.Get("If-None-Match")
+
+	if inm != "" {
+
+		if etag == "" {
+			return false
+		}
+
+		for _, candidate := range strings.Split(inm, ",") {
+
+			candidate = strings.TrimSpace(candidate)
+
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	ims := r.Header.Get("If-Modified-Since")
+
+	if ims == "" || modtime.IsZero() {
+		return false
+	}
+
+	t, err := http.ParseTime(ims)
+
+	if err != nil {
+		return false
+	}
+
+	return !modtime.Truncate(time.Second).After(t)
+}
+
+// ServeNotModified writes a 304 response with the same validators (and
+// Cache-Control header) that ServeBody would have sent.
+
+func ServeNotModified(w http.ResponseWriter, etag string, modtime time.Time, max_age int) {
+
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+
+	if !modtime.IsZero() {
+		w.Header().Set("Last-Modified", modtime.UTC().Format(http.TimeFormat))
+	}
+
+	if max_age > 0 {
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", max_age))
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+}
+
+// LastModified returns the time a source image was last modified or the zero
+// time if the source doesn't know (or say).
+
+func LastModified(source iiifsource.Source, id string) time.Time {
+
+	lm_source, ok := source.(iiifsource.LastModifiedSource)
+
+	if !ok {
+		return time.Time{}
+	}
+
+	t, err := lm_source.LastModified(id)
+
+	if err != nil {
+		return time.Time{}
+	}
+
+	return t
+}
+
+// StatLastModified returns the time a source image was last modified, if the
+// source is a StreamingSource that knows, or the zero time. Unlike LastModified
+// this may mean a round trip to a remote service (a HEAD request for S3 and URI
+// sources) so it should only be used when the image has had to be read anyway.
+
+func StatLastModified(ctx context.Context, source iiifsource.Source, id string) time.Time {
+
+	ss, ok := source.(iiifsource.StreamingSource)
+
+	if !ok {
+		return time.Time{}
+	}
+
+	info, err := ss.Stat(ctx, id)
+
+	if err != nil {
+		return time.Time{}
+	}
+
+	return info.ModTime
+}
+
+func BaseURLFromRequest(r *http.Request) string {
 
 	scheme := "http"
 
@@ -408,7 +916,19 @@ func EndpointFromRequest(r *http.Request) string {
 		scheme = "https"
 	}
 
-	endpoint := fmt.Sprintf("%s://%s", scheme, r.Host)
+	return fmt.Sprintf("%s://%s", scheme, r.Host)
+}
+
+func EndpointFromRequest(r *http.Request) string {
+
+	endpoint := BaseURLFromRequest(r)
+
+	route, ok := r.Context().Value(iiifRouteKey{}).(IIIFRoute)
+
+	if ok {
+		endpoint = endpoint + route.Prefix
+	}
+
 	return endpoint
 }
 
@@ -451,6 +971,15 @@ func main() {
 		log.Fatal(err)
 	}
 
+	for version, _ := range config.Level.Prefixes {
+
+		_, err = iiiflevel.NewLevelFromConfigWithVersion(config, *host, version)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	/*
 
 		Okay now we're going to set up global cache thingies for source images
@@ -471,6 +1000,9 @@ func main() {
 		log.Fatal(err)
 	}
 
+	PublishCacheStats("ImagesCache", images_cache)
+	PublishCacheStats("DerivativesCache", derivatives_cache)
+
 	InfoHandler, err := InfoHandlerFunc(config)
 
 	if err != nil {
@@ -479,6 +1011,12 @@ func main() {
 
 	HealthHandler, err := HealthHandlerFunc()
 
+	BaseURIHandler, err := BaseURIHandlerFunc(config)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	ImageHandler, err := ImageHandlerFunc(config, images_cache, derivatives_cache)
 
 	if err != nil {
@@ -490,6 +1028,30 @@ func main() {
 	// https://github.com/thisisaaronland/go-iiif/issues/4
 
 	router.HandleFunc("/status", HealthHandler)
+
+	// versioned routes need to be registered first or the (greedy) identifier
+	// pattern below will happily swallow the prefix
+
+	for version, prefix := range config.Level.Prefixes {
+
+		prefix = fmt.Sprintf("/%s", strings.Trim(prefix, "/"))
+
+		versionedInfoHandler, err := VersionHandlerFunc(version, prefix, InfoHandler)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		versionedImageHandler, err := VersionHandlerFunc(version, prefix, ImageHandler)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		router.HandleFunc(prefix+"/{identifier:.+}/info.json", versionedInfoHandler)
+		router.HandleFunc(prefix+"/{identifier:.+}/{region}/{size}/{rotation}/{quality}.{format}", versionedImageHandler)
+	}
+
 	router.HandleFunc("/{identifier:.+}/info.json", InfoHandler)
 	router.HandleFunc("/{identifier:.+}/{region}/{size}/{rotation}/{quality}.{format}", ImageHandler)
 
@@ -519,6 +1081,23 @@ func main() {
 		router.HandleFunc("/example/{ignore:.*}", exampleHandler)
 	}
 
+	// base URI redirects match (almost) anything so they go last
+
+	for version, prefix := range config.Level.Prefixes {
+
+		prefix = fmt.Sprintf("/%s", strings.Trim(prefix, "/"))
+
+		versionedBaseURIHandler, err := VersionHandlerFunc(version, prefix, BaseURIHandler)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		router.HandleFunc(prefix+"/{identifier:.+}", versionedBaseURIHandler)
+	}
+
+	router.HandleFunc("/{identifier:.+}", BaseURIHandler)
+
 	endpoint := fmt.Sprintf("%s:%d", *host, *port)
 
 	err = gracehttp.Serve(&http.Server{Addr: endpoint, Handler: router})
@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	iiifprofile "github.com/thisisaaronland/go-iiif/profile"
 	iiiftile "github.com/thisisaaronland/go-iiif/tile"
 	"github.com/whosonfirst/go-whosonfirst-csv"
 	"github.com/whosonfirst/go-whosonfirst-log"
@@ -20,7 +21,7 @@ import (
 func main() {
 
 	var cfg = flag.String("config", "", "Path to a valid go-iiif config file")
-	var sf = flag.String("scale-factors", "4", "A comma-separated list of scale factors to seed tiles with")
+	var sf = flag.String("scale-factors", "", "A comma-separated list of scale factors to seed tiles with. If empty the scale factors in the config file's \"profile\" block are used or, failing that, all the scale factors that make sense for each image")
 	var quality = flag.String("quality", "default", "A valid IIIF quality parameter - if \"default\" then the code will try to determine which format you've set as the default")
 	var format = flag.String("format", "jpg", "A valid IIIF format parameter")
 	var logfile = flag.String("logfile", "", "Write logging information to this file")
@@ -44,7 +45,9 @@ func main() {
 		golog.Fatal(err)
 	}
 
-	ts, err := iiiftile.NewTileSeed(config, 256, 256, *endpoint, *quality, *format)
+	tile_w, tile_h := iiifprofile.TileSizeFromConfig(config)
+
+	ts, err := iiiftile.NewTileSeed(config, tile_h, tile_w, *endpoint, *quality, *format)
 
 	if err != nil {
 		golog.Fatal(err)
@@ -77,10 +80,15 @@ func main() {
 	for _, s := range strings.Split(*sf, ",") {
 
 		s = strings.Trim(s, " ")
+
+		if s == "" {
+			continue
+		}
+
 		scale, err := strconv.Atoi(s)
 
 		if err != nil {
-			logger.Fatal(err.Error())
+			logger.Fatal("%s", err)
 		}
 
 		scales = append(scales, scale)
@@ -99,7 +107,7 @@ func main() {
 			reader, err := csv.NewDictReaderFromPath(path)
 
 			if err != nil {
-				logger.Fatal(err.Error())
+				logger.Fatal("%s", err)
 			}
 
 			wg := new(sync.WaitGroup)
@@ -116,21 +124,29 @@ func main() {
 				}
 
 				if err != nil {
-					logger.Fatal(err.Error())
+					logger.Fatal("%s", err)
 				}
 
-				src_id, ok := row["source_id"]
+				alt_id, ok := row["alternate_id"]
 
 				if !ok {
-					logger.Warning("Unable to determine source ID", row)
+					logger.Warning("Unable to determine alternate ID %v", row)
 					continue
 				}
 
-				alt_id, ok := row["alternate_id"]
+				// rows without a source ID are resolved the same way that
+				// iiif-server will resolve their alternate ID
 
-				if !ok {
-					logger.Warning("Unable to determine alternate ID", row)
-					continue
+				src_id, ok := row["source_id"]
+
+				if !ok || src_id == "" {
+
+					src_id, err = ts.Resolve(alt_id)
+
+					if err != nil {
+						logger.Warning("Unable to determine source ID %v, %s", row, err)
+						continue
+					}
 				}
 
 				t1 := time.Now()
@@ -182,7 +198,16 @@ func main() {
 				src_id = pointers[0]
 				alt_id = pointers[1]
 			} else {
-				src_id = pointers[0]
+
+				// a single ID is the public identifier, so find the
+				// source image the same way iiif-server would
+
+				src_id, err = ts.Resolve(pointers[0])
+
+				if err != nil {
+					logger.Fatal("%s", err)
+				}
+
 				alt_id = pointers[0]
 			}
 
@@ -197,7 +222,7 @@ func main() {
 			t2 := time.Since(t1)
 
 			if err != nil {
-				logger.Fatal(err.Error())
+				logger.Fatal("%s", err)
 			}
 
 			logger.Debug("%s time to process %d tiles: %v", id, count, t2)
@@ -41,14 +41,25 @@ type ImageCompliance struct {
 	Format   map[string]ComplianceDetails `json:"format"`
 }
 
+// ComplianceSpec is the set of required and supported features for a
+// compliance level.
+
+type ComplianceSpec struct {
+	Image ImageCompliance `json:"image"`
+	HTTP  HTTPCompliance  `json:"http"`
+}
+
 type Compliance interface {
 	Formats() []string
 	Qualities() []string
+	Supports() []string
 	DefaultQuality() (string, error)
 	IsValidImageRegion(string) (bool, error)
 	IsValidImageSize(string) (bool, error)
 	IsValidImageRotation(string) (bool, error)
 	IsValidImageQuality(string) (bool, error)
 	IsValidImageFormat(string) (bool, error)
-	Spec() *Level2ComplianceSpec
+	Spec() *ComplianceSpec
+	Version() string
+	Level() string
 }
@@ -0,0 +1,103 @@
+package compliance
+
+// http://iiif.io/api/image/2.1/compliance/#level-1-compliance
+// Level 1 is useful for (deliberately) restricted services.
+
+var level1_spec = `{
+    "image": {
+	     "region": {
+		"full":         { "syntax": "full", "required": true, "supported": true, "match": "^full$" },
+		"regionByPx":   { "syntax": "x,y,w,h", "required": true, "supported": true, "match": "^\\d+\\,\\d+\\,\\d+\\,\\d+$" },
+		"regionByPct":  { "syntax": "pct:x,y,w,h", "required": false, "supported": false, "match": "^pct\\:\\d+\\,\\d+\\,\\d+\\,\\d+$" },
+		"regionSquare": { "syntax": "square", "required": false, "supported": false, "match": "^square$" }
+	     },
+	     "size": {
+		"full":              { "syntax": "full", "required": true, "supported": true, "match": "^full$" },
+		"max":               { "syntax": "max", "required": false, "supported": false, "match": "^max$" },
+		"sizeByW":           { "syntax": "w,", "required": true, "supported": true, "match": "^\\d+\\,$" },
+		"sizeByH":           { "syntax": ",h", "required": true, "supported": true, "match": "^\\,\\d+$" },
+		"sizeByPct":         { "syntax": "pct:n", "required": true, "supported": true, "match": "^pct\\:\\d+(\\.\\d+)?$" },
+		"sizeByConfinedWh":  { "syntax": "!w,h", "required": false, "supported": false, "match": "^\\!\\d+\\,\\d+$" },
+		"sizeByDistortedWh": { "syntax": "w,h", "required": false, "supported": false, "match": "^\\d+\\,\\d+$" },
+		"sizeByWh":          { "syntax": "w,h", "required": false, "supported": false, "match": "^\\d+\\,\\d+$" }
+	     },
+	     "rotation": {
+		"none":              { "syntax": "0", "required": true, "supported": true, "match": "^0$" },
+		"rotationBy90s":     { "syntax": "90,180,270", "required": false, "supported": false, "match": "^(?:90|180|270)$" },
+		"rotationArbitrary": { "syntax": "", "required": false, "supported": false, "match": "^\\d+\\.\\d+$" },
+		"mirroring":         { "syntax": "!n", "required": false, "supported": false, "match": "^\\!\\d+$" }
+	     },
+	     "quality": {
+		"default": { "syntax": "default", "required": true, "supported": true, "match": "^default$", "default": true },
+		"color":   { "syntax": "color", "required": false, "supported": false, "match": "^colou?r$", "default": false },
+		"gray":    { "syntax": "gray", "required": false, "supported": false, "match": "gr(?:e|a)y$", "default": false },
+		"bitonal": { "syntax": "bitonal", "required": false, "supported": false, "match": "^bitonal$", "default": false }
+	     },
+	     "format": {
+		"jpg":  { "syntax": "jpg", "required": true, "supported": true, "match": "^jpe?g$" },
+		"png":  { "syntax": "png", "required": false, "supported": false, "match": "^png$" },
+		"tif":  { "syntax": "tif", "required": false, "supported": false, "match": "^tiff?$" },
+		"gif":  { "syntax": "gif", "required": false, "supported": false, "match": "^gif$" },
+		"pdf":  { "syntax": "pdf", "required": false, "supported": false, "match": "^pdf$" },
+		"jp2":  { "syntax": "jp2", "required": false, "supported": false, "match": "^jp2$" },
+		"webp": { "syntax": "webp", "required": false, "supported": false, "match": "^webp$" }
+	     }
+    },
+    "http": {
+	    "baseUriRedirect":     { "name": "base URI redirects", "required": true, "supported": true },
+	    "cors":                { "name": "CORS", "required": true, "supported": true },
+	    "jsonldMediaType":     { "name": "json-ld media type", "required": true, "supported": true },
+	    "profileLinkHeader":   { "name": "profile link header", "required": false, "supported": true },
+	    "canonicalLinkHeader": { "name": "canonical link header", "required": false, "supported": true }
+    }
+}`
+
+// http://iiif.io/api/image/3.0/compliance/#level-1-compliance
+
+var level1_spec_v3 = `{
+    "image": {
+	     "region": {
+		"full":         { "syntax": "full", "required": true, "supported": true, "match": "^full$" },
+		"regionByPx":   { "syntax": "x,y,w,h", "required": true, "supported": true, "match": "^\\d+\\,\\d+\\,\\d+\\,\\d+$" },
+		"regionByPct":  { "syntax": "pct:x,y,w,h", "required": false, "supported": false, "match": "^pct\\:\\d+(\\.\\d+)?\\,\\d+(\\.\\d+)?\\,\\d+(\\.\\d+)?\\,\\d+(\\.\\d+)?$" },
+		"regionSquare": { "syntax": "square", "required": true, "supported": true, "match": "^square$" }
+	     },
+	     "size": {
+		"max":              { "syntax": "max", "required": true, "supported": true, "match": "^max$" },
+		"sizeByW":          { "syntax": "w,", "required": true, "supported": true, "match": "^\\d+\\,$" },
+		"sizeByH":          { "syntax": ",h", "required": true, "supported": true, "match": "^\\,\\d+$" },
+		"sizeByPct":        { "syntax": "pct:n", "required": false, "supported": false, "match": "^pct\\:\\d+(\\.\\d+)?$" },
+		"sizeByConfinedWh": { "syntax": "!w,h", "required": false, "supported": false, "match": "^\\!\\d+\\,\\d+$" },
+		"sizeByWh":         { "syntax": "w,h", "required": true, "supported": true, "match": "^\\d+\\,\\d+$" },
+		"sizeUpscaling":    { "syntax": "^", "required": false, "supported": false, "match": "^\\^$" }
+	     },
+	     "rotation": {
+		"none":              { "syntax": "0", "required": true, "supported": true, "match": "^0$" },
+		"rotationBy90s":     { "syntax": "90,180,270", "required": false, "supported": false, "match": "^(?:90|180|270)$" },
+		"rotationArbitrary": { "syntax": "", "required": false, "supported": false, "match": "^\\d+\\.\\d+$" },
+		"mirroring":         { "syntax": "!n", "required": false, "supported": false, "match": "^\\!\\d+$" }
+	     },
+	     "quality": {
+		"default": { "syntax": "default", "required": true, "supported": true, "match": "^default$", "default": true },
+		"color":   { "syntax": "color", "required": false, "supported": false, "match": "^colou?r$", "default": false },
+		"gray":    { "syntax": "gray", "required": false, "supported": false, "match": "gr(?:e|a)y$", "default": false },
+		"bitonal": { "syntax": "bitonal", "required": false, "supported": false, "match": "^bitonal$", "default": false }
+	     },
+	     "format": {
+		"jpg":  { "syntax": "jpg", "required": true, "supported": true, "match": "^jpe?g$" },
+		"png":  { "syntax": "png", "required": false, "supported": false, "match": "^png$" },
+		"tif":  { "syntax": "tif", "required": false, "supported": false, "match": "^tiff?$" },
+		"gif":  { "syntax": "gif", "required": false, "supported": false, "match": "^gif$" },
+		"pdf":  { "syntax": "pdf", "required": false, "supported": false, "match": "^pdf$" },
+		"jp2":  { "syntax": "jp2", "required": false, "supported": false, "match": "^jp2$" },
+		"webp": { "syntax": "webp", "required": false, "supported": false, "match": "^webp$" }
+	     }
+    },
+    "http": {
+	    "baseUriRedirect":     { "name": "base URI redirects", "required": true, "supported": true },
+	    "cors":                { "name": "CORS", "required": true, "supported": true },
+	    "jsonldMediaType":     { "name": "json-ld media type", "required": true, "supported": true },
+	    "profileLinkHeader":   { "name": "profile link header", "required": false, "supported": true },
+	    "canonicalLinkHeader": { "name": "canonical link header", "required": false, "supported": true }
+    }
+}`
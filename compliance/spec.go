@@ -0,0 +1,432 @@
+package compliance
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	iiiferrors "github.com/thisisaaronland/go-iiif/errors"
+	_ "log"
+	"regexp"
+	"strings"
+)
+
+/*
+
+All of the compliance levels are really just a different spec (which is to say
+a different set of required and supported features) so they are all the same
+ComplianceLevel and the only thing that changes is which of the specs in
+level0.go, level1.go and level2.go it is built from.
+
+*/
+
+type ComplianceLevel struct {
+	spec    *ComplianceSpec
+	version string
+	level   string
+}
+
+// ComplianceLevel doesn't embed Compliance, so that a missing method fails to
+// compile rather than panicking at runtime, which means checking it here
+
+var _ Compliance = (*ComplianceLevel)(nil)
+
+var specs = map[string]map[string]string{
+	"0": {
+		Version2: level0_spec,
+		Version3: level0_spec_v3,
+	},
+	"1": {
+		Version2: level1_spec,
+		Version3: level1_spec_v3,
+	},
+	"2": {
+		Version2: level2_spec,
+		Version3: level2_spec_v3,
+	},
+}
+
+// NewComplianceWithVersion returns the (configured) Compliance for a compliance
+// level, as in the "level.compliance" config property, and a version of the
+// Image API.
+
+func NewComplianceWithVersion(config *iiifconfig.Config, level string, version string) (*ComplianceLevel, error) {
+
+	version, err := EnsureVersion(version)
+
+	if err != nil {
+		return nil, err
+	}
+
+	spec, err := NewComplianceSpecWithVersion(level, version)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = configureComplianceSpec(spec, config, version)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c := ComplianceLevel{
+		spec:    spec,
+		version: version,
+		level:   level,
+	}
+
+	return &c, nil
+}
+
+// NewComplianceSpecWithVersion returns the (unconfigured) spec for a compliance
+// level, as in the "level.compliance" config property, and a version of the
+// Image API.
+
+func NewComplianceSpecWithVersion(level string, version string) (*ComplianceSpec, error) {
+
+	version, err := EnsureVersion(version)
+
+	if err != nil {
+		return nil, err
+	}
+
+	raw, ok := specs[level]
+
+	if !ok {
+		message := fmt.Sprintf("Invalid compliance level '%s'", level)
+		return nil, errors.New(message)
+	}
+
+	return newComplianceSpec(raw[version])
+}
+
+func newComplianceSpec(raw string) (*ComplianceSpec, error) {
+
+	spec := ComplianceSpec{}
+	err := json.Unmarshal([]byte(raw), &spec)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &spec, nil
+}
+
+func configureComplianceSpec(spec *ComplianceSpec, config *iiifconfig.Config, version string) error {
+
+	feature_block := func(block string) (map[string]ComplianceDetails, error) {
+
+		var possible map[string]ComplianceDetails
+
+		if block == "region" {
+			possible = spec.Image.Region
+		} else if block == "size" {
+			possible = spec.Image.Size
+		} else if block == "rotation" {
+			possible = spec.Image.Rotation
+		} else if block == "quality" {
+			possible = spec.Image.Quality
+		} else if block == "format" {
+			possible = spec.Image.Format
+		} else {
+			message := fmt.Sprintf("Unknown block %s", block)
+			return nil, errors.New(message)
+		}
+
+		return possible, nil
+	}
+
+	// the HTTP features have their own (simpler) data model so they get their
+	// own toggle function, mostly so that the server's behaviour and what gets
+	// reported in info.json files can be kept in sync
+
+	toggle_http_features := func(features []string, toggle bool) error {
+
+		for _, f := range features {
+
+			details, ok := spec.HTTP[f]
+
+			if !ok {
+				message := fmt.Sprintf("Undefined feature %s for block (http)", f)
+				return errors.New(message)
+			}
+
+			details.Supported = toggle
+			spec.HTTP[f] = details
+		}
+
+		return nil
+	}
+
+	toggle_features := func(stuff iiifconfig.FeaturesToggle, toggle bool) error {
+
+		for block, features := range stuff {
+
+			if block == "http" {
+
+				err := toggle_http_features(features, toggle)
+
+				if err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			possible, err := feature_block(block)
+
+			if err != nil {
+				return err
+			}
+
+			for _, f := range features {
+
+				details, ok := possible[f]
+
+				/*
+
+					The features block in the config file is shared by all the versions of
+					the Image API so it's entirely possible that it names something that
+					only exists in 2.1 (like sizeByDistortedWh) so only be strict about
+					undefined features for the default version.
+
+				*/
+
+				if !ok && version != DefaultVersion {
+					continue
+				}
+
+				if !ok {
+					message := fmt.Sprintf("Undefined feature %s for block (%s)", f, block)
+					return errors.New(message)
+				}
+
+				details.Supported = toggle
+				possible[f] = details
+			}
+		}
+
+		return nil
+	}
+
+	append_features := func(stuff iiifconfig.FeaturesAppend) error {
+
+		for block, features := range stuff {
+
+			possible, err := feature_block(block)
+
+			if err != nil {
+				return err
+			}
+
+			for name, details := range features {
+
+				possible[name] = ComplianceDetails{
+					Syntax:    details.Syntax,
+					Required:  details.Required,
+					Supported: details.Supported,
+					Match:     details.Match,
+				}
+			}
+		}
+
+		return nil
+	}
+
+	err := append_features(config.Features.Append)
+
+	if err != nil {
+		return err
+	}
+
+	err = toggle_features(config.Features.Enable, true)
+
+	if err != nil {
+		return err
+	}
+
+	return toggle_features(config.Features.Disable, false)
+}
+
+func (c *ComplianceLevel) IsValidImageRegion(region string) (bool, error) {
+
+	return c.isvalid("region", region)
+}
+
+func (c *ComplianceLevel) IsValidImageSize(size string) (bool, error) {
+
+	// http://iiif.io/api/image/3.0/#42-size - the "^" prefix is its own feature
+	// (sizeUpscaling) and may be combined with any of the other size syntaxes
+
+	if c.version == Version3 && strings.HasPrefix(size, "^") {
+
+		ok, err := c.isvalid("size", "^")
+
+		if !ok {
+			return false, err
+		}
+
+		size = strings.TrimPrefix(size, "^")
+	}
+
+	return c.isvalid("size", size)
+}
+
+func (c *ComplianceLevel) IsValidImageRotation(rotation string) (bool, error) {
+
+	return c.isvalid("rotation", rotation)
+}
+
+func (c *ComplianceLevel) IsValidImageQuality(quality string) (bool, error) {
+
+	return c.isvalid("quality", quality)
+}
+
+func (c *ComplianceLevel) IsValidImageFormat(format string) (bool, error) {
+
+	return c.isvalid("format", format)
+}
+
+func (c *ComplianceLevel) Formats() []string {
+
+	return c.properties(c.spec.Image.Format)
+}
+
+func (c *ComplianceLevel) Qualities() []string {
+
+	return c.properties(c.spec.Image.Quality)
+}
+
+func (c *ComplianceLevel) Supports() []string {
+
+	supports := make([]string, 0)
+
+	for _, s := range c.properties(c.spec.Image.Region) {
+		supports = append(supports, s)
+	}
+
+	for _, s := range c.properties(c.spec.Image.Size) {
+		supports = append(supports, s)
+	}
+
+	for _, s := range c.properties(c.spec.Image.Rotation) {
+		supports = append(supports, s)
+	}
+
+	for name, details := range c.spec.HTTP {
+
+		if !details.Supported {
+			continue
+		}
+
+		supports = append(supports, name)
+	}
+
+	return supports
+}
+
+func (c *ComplianceLevel) isvalid(property string, value string) (bool, error) {
+
+	var sect map[string]ComplianceDetails
+
+	if property == "region" {
+		sect = c.spec.Image.Region
+	} else if property == "size" {
+		sect = c.spec.Image.Size
+	} else if property == "rotation" {
+		sect = c.spec.Image.Rotation
+	} else if property == "quality" {
+		sect = c.spec.Image.Quality
+	} else if property == "format" {
+		sect = c.spec.Image.Format
+	} else {
+		message := fmt.Sprintf("Unknown property %s", property)
+		return false, errors.New(message)
+	}
+
+	ok := false
+
+	for name, details := range sect {
+
+		// log.Printf("%s %t (%s = %s)", name, details.Supported, property, value)
+
+		re, err := regexp.Compile(details.Match)
+
+		if err != nil {
+			return false, err
+		}
+
+		if !re.MatchString(value) {
+			continue
+		}
+
+		if !details.Supported {
+			message := fmt.Sprintf("Unsupported IIIF %s feature (%s) %s", SpecVersion(c.version), name, value)
+			return false, iiiferrors.NewUnsupportedFeatureError(property, name, errors.New(message))
+		}
+
+		// log.Printf("%s %s MATCH %s", name, property, value)
+		ok = true
+		break
+
+	}
+
+	if !ok {
+		message := fmt.Sprintf("Invalid IIIF %s feature property %s %s", SpecVersion(c.version), property, value)
+		return false, iiiferrors.NewInvalidParameterError(property, errors.New(message))
+	}
+
+	return true, nil
+}
+
+func (c *ComplianceLevel) properties(sect map[string]ComplianceDetails) []string {
+
+	properties := make([]string, 0)
+
+	for name, details := range sect {
+
+		if !details.Supported {
+			continue
+		}
+
+		properties = append(properties, name)
+	}
+
+	return properties
+}
+
+func (c *ComplianceLevel) Spec() *ComplianceSpec {
+
+	return c.spec
+}
+
+func (c *ComplianceLevel) Version() string {
+
+	return c.version
+}
+
+func (c *ComplianceLevel) DefaultQuality() (string, error) {
+
+	quality := ""
+
+	for q, details := range c.spec.Image.Quality {
+
+		if details.Supported && details.Default {
+			quality = q
+			break
+		}
+
+	}
+
+	if quality == "" {
+		return "", errors.New("Unable to determine default quality")
+	}
+
+	return quality, nil
+}
+
+func (c *ComplianceLevel) Level() string {
+
+	return c.level
+}
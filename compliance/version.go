@@ -0,0 +1,89 @@
+package compliance
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// These are the (major) versions of the IIIF Image API that go-iiif knows how to
+// speak. They are the same values that appear in the @context URIs and in the
+// "level.version" and "level.prefixes" config blocks.
+
+// http://iiif.io/api/image/2.1/
+// http://iiif.io/api/image/3.0/
+
+const (
+	Version2 = "2"
+	Version3 = "3"
+)
+
+const DefaultVersion = Version2
+
+func Versions() []string {
+	return []string{Version2, Version3}
+}
+
+func IsValidVersion(version string) bool {
+
+	for _, v := range Versions() {
+
+		if v == version {
+			return true
+		}
+	}
+
+	return false
+}
+
+func EnsureVersion(version string) (string, error) {
+
+	if version == "" {
+		return DefaultVersion, nil
+	}
+
+	if !IsValidVersion(version) {
+		message := fmt.Sprintf("Unsupported IIIF Image API version '%s'", version)
+		return "", errors.New(message)
+	}
+
+	return version, nil
+}
+
+// SpecVersion returns the full version number of the spec that go-iiif implements
+// for a given major version, for example "2.1".
+
+func SpecVersion(version string) string {
+
+	if version == Version3 {
+		return "3.0"
+	}
+
+	return "2.1"
+}
+
+func ContextURI(version string) string {
+	return fmt.Sprintf("http://iiif.io/api/image/%s/context.json", version)
+}
+
+// ComplianceURI returns the URI for a compliance level, as in the "level.compliance"
+// config property, which is used both in info.json files and profile Link headers.
+
+func ComplianceURI(version string, level string) string {
+	return fmt.Sprintf("http://iiif.io/api/image/%s/level%s.json", version, level)
+}
+
+// VersionFromContextURI returns the API version for a @context URI, which is
+// also what clients send as the "profile" parameter of an Accept header.
+
+func VersionFromContextURI(uri string) (string, bool) {
+
+	for _, v := range Versions() {
+
+		if strings.TrimSpace(uri) == ContextURI(v) {
+			return v, true
+		}
+	}
+
+	return "", false
+}
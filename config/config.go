@@ -11,12 +11,17 @@ type Config struct {
         Features    FeaturesConfig    `json:"features"`
 	Images      ImagesConfig      `json:"images"`
 	Derivatives DerivativesConfig `json:"derivatives"`
+	Profile	    ProfileConfig     `json:"profile,omitempty"`
 	Flickr	    FlickrConfig      `json:"flickr,omitempty"`
 	Primitive   PrimitiveConfig   `json:"primitive,omitempty"`
+	HTTP	    HTTPConfig	      `json:"http,omitempty"`
+	Limits	    LimitsConfig      `json:"limits,omitempty"`
 }
 
 type LevelConfig struct {
      Compliance string `json:"compliance"`
+     Version	string `json:"version,omitempty"`
+     Prefixes	map[string]string `json:"prefixes,omitempty"`
 }
 
 type FeaturesConfig struct {
@@ -36,9 +41,38 @@ type FeaturesDetails struct {
 	Match     string `json:"match,omitempty"`
 }
 
+type ProfileConfig struct {
+	Tiles TilesConfig `json:"tiles,omitempty"`
+	Sizes []int	  `json:"sizes,omitempty"`
+}
+
+type TilesConfig struct {
+	Width	     int   `json:"width,omitempty"`
+	Height	     int   `json:"height,omitempty"`
+	ScaleFactors []int `json:"scale_factors,omitempty"`
+}
+
+type LimitsConfig struct {
+	MaxWidth	int `json:"max_width,omitempty"`
+	MaxHeight	int `json:"max_height,omitempty"`
+	MaxArea		int `json:"max_area,omitempty"`
+	MaxSourcePixels	int `json:"max_source_pixels,omitempty"`
+	MaxSourceBytes	int `json:"max_source_bytes,omitempty"`
+}
+
+type HTTPConfig struct {
+	MaxAge MaxAgeConfig `json:"max_age,omitempty"`
+}
+
+type MaxAgeConfig struct {
+	Info  int `json:"info,omitempty"`
+	Image int `json:"image,omitempty"`
+}
+
 type ImagesConfig struct {
 	Source SourceConfig `json:"source"`
 	Cache  CacheConfig  `json:"cache"`
+	Resolver ResolverConfig `json:"resolver,omitempty"`
 }
 
 type DerivativesConfig struct {
@@ -56,6 +90,62 @@ type SourceConfig struct {
 	Region string `json:"region,omitempty"`
 	Credentials string `json:"credentials,omitempty"`
 	Tmpdir string `json:"tmpdir,omitempty"`	
+	Endpoint string `json:"endpoint,omitempty"`
+	PathStyle bool `json:"path_style,omitempty"`
+	Sources map[string]SourceConfig `json:"sources,omitempty"`
+	Routes []SourceRouteConfig `json:"routes,omitempty"`
+	Fallback []string `json:"fallback,omitempty"`
+	Timeout int `json:"timeout,omitempty"`
+	Retries int `json:"retries,omitempty"`
+	Backoff int `json:"backoff,omitempty"`
+	MaxBytes int `json:"max_bytes,omitempty"`
+	ContentTypes []string `json:"content_types,omitempty"`
+	Revalidate int `json:"revalidate,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Auth SourceAuthConfig `json:"auth,omitempty"`
+	Match string `json:"match,omitempty"`
+	MaxOpen int `json:"max_open,omitempty"`
+	Driver string `json:"driver,omitempty"`
+	DSN string `json:"dsn,omitempty"`
+	Query string `json:"query,omitempty"`
+	StatQuery string `json:"stat_query,omitempty"`
+	MaxOpenConns int `json:"max_open_conns,omitempty"`
+	MaxIdleConns int `json:"max_idle_conns,omitempty"`
+	ConnMaxLifetime int `json:"conn_max_lifetime,omitempty"`
+	URI string `json:"uri,omitempty"`
+}
+
+type SourceAuthConfig struct {
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	PasswordEnv string `json:"password_env,omitempty"`
+	Token string `json:"token,omitempty"`
+	TokenFile string `json:"token_file,omitempty"`
+	TokenEnv string `json:"token_env,omitempty"`
+	TokenScheme string `json:"token_scheme,omitempty"`
+}
+
+type SourceRouteConfig struct {
+	Prefix string `json:"prefix,omitempty"`
+	Match string `json:"match,omitempty"`
+	Sources []string `json:"sources"`
+	StripPrefix bool `json:"strip_prefix,omitempty"`
+}
+
+type ResolverConfig struct {
+	Name string `json:"name,omitempty"`
+	Template string `json:"template,omitempty"`
+	Rules []ResolverRuleConfig `json:"rules,omitempty"`
+	Path string `json:"path,omitempty"`
+	Hash string `json:"hash,omitempty"`
+	Depth int `json:"depth,omitempty"`
+	Width int `json:"width,omitempty"`
+	Strict bool `json:"strict,omitempty"`
+}
+
+type ResolverRuleConfig struct {
+	Match string `json:"match"`
+	Replace string `json:"replace"`
 }
 
 type FlickrConfig struct {
@@ -75,6 +165,20 @@ type CacheConfig struct {
 	Prefix string `json:"prefix,omitempty"`
 	Region string `json:"region,omitempty"`
 	Credentials string `json:"credentials,omitempty"`
+	Tiers []CacheConfig `json:"tiers,omitempty"`
+	WritePolicy string `json:"write_policy,omitempty"`
+	Layout string `json:"layout,omitempty"`
+	Address string `json:"address,omitempty"`
+	DB int `json:"db,omitempty"`
+	ReadOnly bool `json:"read_only,omitempty"`
+	Endpoint string `json:"endpoint,omitempty"`
+	PathStyle bool `json:"path_style,omitempty"`
+	ACL string `json:"acl,omitempty"`
+	StorageClass string `json:"storage_class,omitempty"`
+	ServerSideEncryption string `json:"server_side_encryption,omitempty"`
+	KMSKeyID string `json:"kms_key_id,omitempty"`
+	CacheControl string `json:"cache_control,omitempty"`
+	URI string `json:"uri,omitempty"`
 }
 
 func NewConfigFromFile(file string) (*Config, error) {
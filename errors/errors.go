@@ -0,0 +1,222 @@
+package errors
+
+// These are the errors that the source, cache, compliance and image packages
+// return when something goes wrong in a way that a caller (like iiif-server)
+// might want to act on. Each one wraps the error that caused it so they can be
+// tested for using the standard library's errors.As function, for example:
+//
+//	var e *iiiferrors.NotFoundError
+//
+//	if errors.As(err, &e) {
+//		// 404 or whatever
+//	}
+//
+// Each one also reports the HTTP status code that the IIIF Image API spec says
+// it should map to.
+
+// http://iiif.io/api/image/2.1/#server-responses
+// http://iiif.io/api/image/3.0/#7-server-responses
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+type NotFoundError struct {
+	Identifier string
+	Err        error
+}
+
+func NewNotFoundError(id string, err error) error {
+
+	e := NotFoundError{
+		Identifier: id,
+		Err:        err,
+	}
+
+	return &e
+}
+
+func (e *NotFoundError) Error() string {
+
+	if e.Err == nil {
+		return fmt.Sprintf("Not found: %s", e.Identifier)
+	}
+
+	return e.Err.Error()
+}
+
+func (e *NotFoundError) Unwrap() error {
+	return e.Err
+}
+
+func (e *NotFoundError) StatusCode() int {
+	return http.StatusNotFound
+}
+
+// UnsupportedFeatureError is a request for a (valid) IIIF feature that has
+// not been enabled for the current compliance level.
+
+type UnsupportedFeatureError struct {
+	Parameter string
+	Feature   string
+	Err       error
+}
+
+func NewUnsupportedFeatureError(parameter string, feature string, err error) error {
+
+	e := UnsupportedFeatureError{
+		Parameter: parameter,
+		Feature:   feature,
+		Err:       err,
+	}
+
+	return &e
+}
+
+func (e *UnsupportedFeatureError) Error() string {
+
+	if e.Err == nil {
+		return fmt.Sprintf("Unsupported feature %s for %s", e.Feature, e.Parameter)
+	}
+
+	return e.Err.Error()
+}
+
+func (e *UnsupportedFeatureError) Unwrap() error {
+	return e.Err
+}
+
+func (e *UnsupportedFeatureError) StatusCode() int {
+	return http.StatusNotImplemented
+}
+
+// InvalidParameterError is a malformed IIIF parameter, or one that can't be
+// applied to a given image (like a region that is entirely outside of it).
+
+type InvalidParameterError struct {
+	Parameter string
+	Err       error
+}
+
+func NewInvalidParameterError(parameter string, err error) error {
+
+	e := InvalidParameterError{
+		Parameter: parameter,
+		Err:       err,
+	}
+
+	return &e
+}
+
+func (e *InvalidParameterError) Error() string {
+
+	if e.Err == nil {
+		return fmt.Sprintf("Invalid %s", e.Parameter)
+	}
+
+	return e.Err.Error()
+}
+
+func (e *InvalidParameterError) Unwrap() error {
+	return e.Err
+}
+
+func (e *InvalidParameterError) StatusCode() int {
+	return http.StatusBadRequest
+}
+
+// UpstreamUnavailableError is a source or a cache that couldn't be reached or
+// that failed in a way that may work if tried again later.
+
+type UpstreamUnavailableError struct {
+	Upstream string
+	Err      error
+}
+
+func NewUpstreamUnavailableError(upstream string, err error) error {
+
+	e := UpstreamUnavailableError{
+		Upstream: upstream,
+		Err:      err,
+	}
+
+	return &e
+}
+
+func (e *UpstreamUnavailableError) Error() string {
+
+	if e.Err == nil {
+		return fmt.Sprintf("%s is unavailable", e.Upstream)
+	}
+
+	return e.Err.Error()
+}
+
+func (e *UpstreamUnavailableError) Unwrap() error {
+	return e.Err
+}
+
+func (e *UpstreamUnavailableError) StatusCode() int {
+	return http.StatusServiceUnavailable
+}
+
+// LimitExceededError is a source image that is bigger (in bytes or pixels) than
+// the server has been configured to process.
+
+type LimitExceededError struct {
+	Limit string
+	Err   error
+}
+
+func NewLimitExceededError(limit string, err error) error {
+
+	e := LimitExceededError{
+		Limit: limit,
+		Err:   err,
+	}
+
+	return &e
+}
+
+func (e *LimitExceededError) Error() string {
+
+	if e.Err == nil {
+		return fmt.Sprintf("Exceeds %s limit", e.Limit)
+	}
+
+	return e.Err.Error()
+}
+
+func (e *LimitExceededError) Unwrap() error {
+	return e.Err
+}
+
+func (e *LimitExceededError) StatusCode() int {
+	return http.StatusForbidden
+}
+
+type statusCoder interface {
+	StatusCode() int
+}
+
+// StatusCode returns the HTTP status code for the first error in err's chain
+// that has one, or default_code if there isn't one.
+
+func StatusCode(err error, default_code int) int {
+
+	var e statusCoder
+
+	if errors.As(err, &e) {
+		return e.StatusCode()
+	}
+
+	return default_code
+}
+
+func IsNotFound(err error) bool {
+
+	var e *NotFoundError
+	return errors.As(err, &e)
+}
@@ -1,12 +1,13 @@
 package image
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	iiifcache "github.com/thisisaaronland/go-iiif/cache"
 	iiifconfig "github.com/thisisaaronland/go-iiif/config"
 	iiifsource "github.com/thisisaaronland/go-iiif/source"
 	_ "log"
-	"os"
 )
 
 type Image interface {
@@ -25,40 +26,77 @@ type Dimensions interface {
 	Width() int
 }
 
+// concurrent requests for the same (uncached) source image share a single read
+
+var sources *iiifcache.Coalescer
+
+func init() {
+	sources = iiifcache.NewCoalescer()
+}
+
 func NewImageFromConfigWithCache(config *iiifconfig.Config, cache iiifcache.Cache, id string) (Image, error) {
 
-	var image Image
+	source, err := iiifsource.NewSourceFromConfig(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewImageFromConfigWithCacheAndSource(config, cache, source, id)
+}
+
+// NewImageFromConfigWithCacheAndSource is NewImageFromConfigWithCache for
+// callers, like iiif-server's handlers, that already have a source and want
+// every image (and revalidation) read through it
+
+func NewImageFromConfigWithCacheAndSource(config *iiifconfig.Config, cache iiifcache.Cache, source iiifsource.Source, id string) (Image, error) {
 
 	body, err := cache.Get(id)
 
-	if err == nil {
+	if err == nil && config.Images.Source.Revalidate > 0 {
 
-		source, err := iiifsource.NewMemorySource(body)
+		body, err = revalidateSource(config, cache, source, id, body)
 
 		if err != nil {
 			return nil, err
 		}
+	}
 
-		image, err = NewImageFromConfigWithSource(config, source, id)
+	if err != nil {
 
-		if err != nil {
-			return nil, err
-		}
+		// the key includes the source details because nothing says there is only
+		// one config file per process
+
+		src := config.Images.Source
+		key := fmt.Sprintf("%s#%s#%s", src.Name, src.Path, id)
 
-	} else {
+		body, _, err = sources.Do(key, func() ([]byte, error) {
 
-		image, err = NewImageFromConfig(config, id)
+			body, info, err := readSource(context.Background(), config, source, id)
+
+			if err != nil {
+				return nil, err
+			}
+
+			conditional := iiifsource.IsConditional(source, id)
+
+			go setSource(config, cache, id, body, info, conditional)
+
+			return body, nil
+		})
 
 		if err != nil {
 			return nil, err
 		}
+	}
 
-		go func() {
-			cache.Set(id, image.Body())
-		}()
+	mem_source, err := iiifsource.NewMemorySource(body)
+
+	if err != nil {
+		return nil, err
 	}
 
-	return image, nil
+	return NewImageFromConfigWithSource(config, mem_source, id)
 
 }
 
@@ -75,27 +113,16 @@ func NewImageFromConfig(config *iiifconfig.Config, id string) (Image, error) {
 
 func NewImageFromConfigWithSource(config *iiifconfig.Config, source iiifsource.Source, id string) (Image, error) {
 
-	if config.Graphics.Source.Name == "VIPS" {
-
-		/*
-			http://www.vips.ecs.soton.ac.uk/supported/7.42/doc/html/libvips/VipsImage.html#vips-image-new-temp-file
-		*/
-
-		if config.Graphics.Source.Tmpdir != "" {
-
-			tmpdir := config.Graphics.Source.Tmpdir
-
-			_, err := os.Stat(tmpdir)
+	name := config.Graphics.Source.Name
 
-			if os.IsNotExist(err) {
-				return nil, err
-			}
-
-			os.Setenv("TMPDIR", tmpdir)
-		}
+	engines_mu.RLock()
+	init_func, ok := engines[name]
+	engines_mu.RUnlock()
 
-		return NewVIPSImageFromConfigWithSource(config, source, id)
+	if !ok {
+		message := fmt.Sprintf("Unknown graphics source '%s'", name)
+		return nil, errors.New(message)
 	}
 
-	return nil, errors.New("Unknown graphics source")
+	return init_func(config, source, id)
 }
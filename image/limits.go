@@ -0,0 +1,117 @@
+package image
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	iiiferrors "github.com/thisisaaronland/go-iiif/errors"
+	iiifsource "github.com/thisisaaronland/go-iiif/source"
+	"io"
+	"io/ioutil"
+)
+
+// These are the limits on source images, as defined in the "limits" config
+// block. They are meant to be checked before an image is decoded so that it
+// can't exhaust the memory of the machine doing the decoding. Limits on the
+// size of derivatives are enforced by Transformation.SizeInstructions.
+
+func CheckSourceBytes(config *iiifconfig.Config, id string, body []byte) error {
+
+	max := config.Limits.MaxSourceBytes
+
+	if max > 0 && len(body) > max {
+		return sourceBytesError(id, int64(len(body)), max)
+	}
+
+	return nil
+}
+
+// ReadSource reads an image from a source without reading more than the
+// max_source_bytes limit allows. If the source knows how big the image is
+// that is checked before anything is read at all.
+
+func ReadSource(ctx context.Context, config *iiifconfig.Config, src iiifsource.Source, id string) ([]byte, error) {
+
+	body, _, err := readSource(ctx, config, src, id)
+	return body, err
+}
+
+func readSource(ctx context.Context, config *iiifconfig.Config, src iiifsource.Source, id string) ([]byte, *iiifsource.SourceInfo, error) {
+
+	fh, info, err := iiifsource.NewStreamingSource(src).Open(ctx, id)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer fh.Close()
+
+	body, err := readBody(config, id, fh, info)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return body, info, nil
+}
+
+func readBody(config *iiifconfig.Config, id string, fh io.Reader, info *iiifsource.SourceInfo) ([]byte, error) {
+
+	max := config.Limits.MaxSourceBytes
+
+	if max <= 0 {
+		return ioutil.ReadAll(fh)
+	}
+
+	if info != nil && info.Size > int64(max) {
+		return nil, sourceBytesError(id, info.Size, max)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(fh, int64(max)+1))
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = CheckSourceBytes(config, id, body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return body, nil
+}
+
+func sourceBytesError(id string, size int64, max int) error {
+
+	message := fmt.Sprintf("Source image %s is %d bytes which exceeds the limit of %d bytes", id, size, max)
+	return iiiferrors.NewLimitExceededError("max_source_bytes", errors.New(message))
+}
+
+// CheckSourcePixels relies on im.Dimensions() only reading the image's header
+// which is true for libvips (bimg) images that haven't been transformed yet
+
+func CheckSourcePixels(config *iiifconfig.Config, id string, im Image) error {
+
+	max := config.Limits.MaxSourcePixels
+
+	if max <= 0 {
+		return nil
+	}
+
+	dims, err := im.Dimensions()
+
+	if err != nil {
+		return err
+	}
+
+	pixels := dims.Width() * dims.Height()
+
+	if pixels > max {
+		message := fmt.Sprintf("Source image %s is %d pixels which exceeds the limit of %d pixels", id, pixels, max)
+		return iiiferrors.NewLimitExceededError("max_source_pixels", errors.New(message))
+	}
+
+	return nil
+}
@@ -0,0 +1,476 @@
+package image
+
+// This is a pure-Go graphics engine that uses the standard library and the
+// golang.org/x/image packages and so doesn't need cgo or libvips. It is not
+// as fast as libvips (or as feature complete, see below) but it is good enough
+// for small deployments and for places where libvips isn't available.
+
+// Things it doesn't do: arbitrary rotations (only multiples of 90 degrees) and
+// encoding WEBP images (it can decode them though).
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	iiiferrors "github.com/thisisaaronland/go-iiif/errors"
+	iiifsource "github.com/thisisaaronland/go-iiif/source"
+	"golang.org/x/image/draw"
+	"image"
+	"image/color"
+	_ "log"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type NativeImage struct {
+	Image
+	config    *iiifconfig.Config
+	source    iiifsource.Source
+	source_id string
+	id        string
+	body      []byte
+	format    string
+	goimg     image.Image
+}
+
+type NativeDimensions struct {
+	Dimensions
+	width  int
+	height int
+}
+
+func (d *NativeDimensions) Height() int {
+	return d.height
+}
+
+func (d *NativeDimensions) Width() int {
+	return d.width
+}
+
+func init() {
+
+	Register("Native", func(config *iiifconfig.Config, src iiifsource.Source, id string) (Image, error) {
+
+		im, err := NewNativeImageFromConfigWithSource(config, src, id)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return im, nil
+	})
+}
+
+func NewNativeImageFromConfigWithSource(config *iiifconfig.Config, src iiifsource.Source, id string) (*NativeImage, error) {
+
+	body, err := ReadSource(context.Background(), config, src, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	im := NativeImage{
+		config:    config,
+		source:    src,
+		source_id: id,
+		id:        id,
+	}
+
+	err = im.Update(body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = CheckSourcePixels(config, id, &im)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &im, nil
+}
+
+// Update replaces the body of the image, working out its format (but without
+// decoding it) in the process.
+
+func (im *NativeImage) Update(body []byte) error {
+
+	_, format, err := image.DecodeConfig(bytes.NewReader(body))
+
+	if err != nil {
+		return err
+	}
+
+	im.body = body
+	im.format = format
+	im.goimg = nil
+
+	return nil
+}
+
+func (im *NativeImage) Body() []byte {
+
+	return im.body
+}
+
+func (im *NativeImage) Format() string {
+
+	return im.format
+}
+
+func (im *NativeImage) ContentType() string {
+
+	format := im.Format()
+
+	if format == "jpg" || format == "jpeg" {
+		return "image/jpeg"
+	} else if format == "png" {
+		return "image/png"
+	} else if format == "webp" {
+		return "image/webp"
+	} else if format == "tif" || format == "tiff" {
+		return "image/tiff"
+	} else if format == "gif" {
+		return "image/gif"
+	} else {
+		return ""
+	}
+}
+
+func (im *NativeImage) Identifier() string {
+	return im.id
+}
+
+func (im *NativeImage) Rename(id string) error {
+	im.id = id
+	return nil
+}
+
+// Dimensions returns the size of the image being transformed, if there is one,
+// and otherwise reads them from the header of the image's body
+
+func (im *NativeImage) Dimensions() (Dimensions, error) {
+
+	if im.goimg != nil {
+
+		bounds := im.goimg.Bounds()
+
+		d := NativeDimensions{
+			width:  bounds.Dx(),
+			height: bounds.Dy(),
+		}
+
+		return &d, nil
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(im.body))
+
+	if err != nil {
+		return nil, err
+	}
+
+	d := NativeDimensions{
+		width:  cfg.Width,
+		height: cfg.Height,
+	}
+
+	return &d, nil
+}
+
+// http://iiif.io/api/image/2.1/#order-of-implementation
+
+func (im *NativeImage) Transform(t *Transformation) error {
+
+	goimg, err := IIIFImageToGolangImage(im)
+
+	if err != nil {
+		return err
+	}
+
+	// see notes in Dimensions - the various *Instructions methods need to
+	// see the image as it is at each step and not the original body
+
+	im.goimg = goimg
+	defer func() { im.goimg = nil }()
+
+	if t.Region != "full" {
+
+		rgi, err := t.RegionInstructions(im)
+
+		if err != nil {
+			return err
+		}
+
+		im.goimg = cropImage(im.goimg, rgi)
+	}
+
+	si, err := t.SizeInstructions(im)
+
+	if err != nil {
+		return err
+	}
+
+	im.goimg = resizeImage(im.goimg, si)
+
+	ri, err := t.RotationInstructions(im)
+
+	if err != nil {
+		return err
+	}
+
+	if ri.Flip {
+		im.goimg = mirrorImage(im.goimg)
+	}
+
+	angle := ri.Angle % 360
+
+	if angle%90 != 0 {
+		message := fmt.Sprintf("Native graphics engine does not support arbitrary rotations (%d)", ri.Angle)
+		return iiiferrors.NewUnsupportedFeatureError("rotation", "rotationArbitrary", errors.New(message))
+	}
+
+	for i := int64(0); i < angle/90; i++ {
+		im.goimg = rotateImage90(im.goimg)
+	}
+
+	if t.Quality == "color" || t.Quality == "default" {
+		// do nothing.
+	} else if t.Quality == "gray" {
+		im.goimg = grayImage(im.goimg)
+	} else if t.Quality == "bitonal" {
+		im.goimg = bitonalImage(im.goimg)
+	} else {
+		// this should be trapped above
+	}
+
+	fi, err := t.FormatInstructions(im)
+
+	if err != nil {
+		return err
+	}
+
+	content_type := ""
+
+	if fi.Format == "jpg" {
+		content_type = "image/jpeg"
+	} else if fi.Format == "png" {
+		content_type = "image/png"
+	} else if fi.Format == "tif" {
+		content_type = "image/tiff"
+	} else if fi.Format == "gif" {
+		content_type = "image/gif"
+	} else {
+		msg := fmt.Sprintf("Unsupported image format '%s'", fi.Format)
+		return iiiferrors.NewUnsupportedFeatureError("format", fi.Format, errors.New(msg))
+	}
+
+	body, err := GolangImageToBytes(im.goimg, content_type)
+
+	if err != nil {
+		return err
+	}
+
+	err = im.Update(body)
+
+	if err != nil {
+		return err
+	}
+
+	// See notes in VIPSImage.Transform - the difference being that there is
+	// no need to worry about GIF files here
+
+	if t.Quality == "dither" {
+
+		err = DitherImage(im)
+
+		if err != nil {
+			return err
+		}
+
+	} else if strings.HasPrefix(t.Quality, "primitive:") {
+
+		parts := strings.Split(t.Quality, ":")
+		parts = strings.Split(parts[1], ",")
+
+		mode, err := strconv.Atoi(parts[0])
+
+		if err != nil {
+			return err
+		}
+
+		iters, err := strconv.Atoi(parts[1])
+
+		if err != nil {
+			return err
+		}
+
+		max_iters := im.config.Primitive.MaxIterations
+
+		if max_iters > 0 && iters > max_iters {
+			return errors.New("Invalid primitive iterations")
+		}
+
+		alpha, err := strconv.Atoi(parts[2])
+
+		if err != nil {
+			return err
+		}
+
+		if alpha > 255 {
+			return errors.New("Invalid primitive alpha")
+		}
+
+		animated := false
+
+		if fi.Format == "gif" {
+			animated = true
+		}
+
+		opts := PrimitiveOptions{
+			Alpha:      alpha,
+			Mode:       mode,
+			Iterations: iters,
+			Size:       0,
+			Animated:   animated,
+		}
+
+		err = PrimitiveImage(im, opts)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func cropImage(src image.Image, rgi *RegionInstruction) image.Image {
+
+	bounds := src.Bounds()
+
+	r := image.Rect(rgi.X, rgi.Y, rgi.X+rgi.Width, rgi.Y+rgi.Height)
+	r = r.Add(bounds.Min).Intersect(bounds)
+
+	dst := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(dst, dst.Bounds(), src, r.Min, draw.Src)
+
+	return dst
+}
+
+// resizeImage follows the same rules that bimg does for SizeInstruction: only
+// Force allows an image to be distorted, a width or height of zero means "scale
+// proportionally" and nothing gets bigger unless Enlarge is true.
+
+func resizeImage(src image.Image, si *SizeInstruction) image.Image {
+
+	bounds := src.Bounds()
+
+	sw := bounds.Dx()
+	sh := bounds.Dy()
+
+	tw := sw
+	th := sh
+
+	if si.Force && si.Width > 0 && si.Height > 0 {
+
+		tw = si.Width
+		th = si.Height
+
+	} else {
+
+		scale := 1.
+
+		if si.Width > 0 && si.Height > 0 {
+			scale = math.Min(float64(si.Width)/float64(sw), float64(si.Height)/float64(sh))
+		} else if si.Width > 0 {
+			scale = float64(si.Width) / float64(sw)
+		} else if si.Height > 0 {
+			scale = float64(si.Height) / float64(sh)
+		}
+
+		if scale > 1. && !si.Enlarge {
+			scale = 1.
+		}
+
+		tw = int(math.Max(1, math.Round(float64(sw)*scale)))
+		th = int(math.Max(1, math.Round(float64(sh)*scale)))
+	}
+
+	if tw == sw && th == sh {
+		return src
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Src, nil)
+
+	return dst
+}
+
+func mirrorImage(src image.Image) image.Image {
+
+	bounds := src.Bounds()
+	w := bounds.Dx()
+	h := bounds.Dy()
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dst.Set(w-1-x, y, src.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+
+	return dst
+}
+
+// rotateImage90 rotates an image 90 degrees clockwise
+
+func rotateImage90(src image.Image) image.Image {
+
+	bounds := src.Bounds()
+	w := bounds.Dx()
+	h := bounds.Dy()
+
+	dst := image.NewRGBA(image.Rect(0, 0, h, w))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dst.Set(h-1-y, x, src.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+
+	return dst
+}
+
+func grayImage(src image.Image) image.Image {
+
+	bounds := src.Bounds()
+
+	dst := image.NewGray(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), src, bounds.Min, draw.Src)
+
+	return dst
+}
+
+func bitonalImage(src image.Image) image.Image {
+
+	gray := grayImage(src).(*image.Gray)
+
+	for i, v := range gray.Pix {
+
+		if v < 128 {
+			gray.Pix[i] = 0
+		} else {
+			gray.Pix[i] = 255
+		}
+	}
+
+	dst := image.NewPaletted(gray.Bounds(), color.Palette{color.Black, color.White})
+	draw.Draw(dst, dst.Bounds(), gray, image.Point{}, draw.Src)
+
+	return dst
+}
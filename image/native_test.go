@@ -0,0 +1,156 @@
+package image
+
+import (
+	"bytes"
+	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	iiiferrors "github.com/thisisaaronland/go-iiif/errors"
+	iiiflevel "github.com/thisisaaronland/go-iiif/level"
+	iiifsource "github.com/thisisaaronland/go-iiif/source"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"testing"
+)
+
+func TestNativeImageTransform(t *testing.T) {
+
+	// a 40x20 image that is red on the left and blue on the right
+
+	goimg := image.NewRGBA(image.Rect(0, 0, 40, 20))
+
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 40; x++ {
+
+			if x < 20 {
+				goimg.Set(x, y, color.RGBA{255, 0, 0, 255})
+			} else {
+				goimg.Set(x, y, color.RGBA{0, 0, 255, 255})
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	err := png.Encode(&buf, goimg)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := iiifconfig.Config{}
+
+	config.Features.Enable = iiifconfig.FeaturesToggle{
+		"quality": []string{"gray"},
+		"format":  []string{"webp"},
+	}
+
+	level, err := iiiflevel.NewLevel2(&config, "http://localhost")
+
+	if err != nil {
+		t.Fatalf("Failed to create level, %s", err)
+	}
+
+	red := color.RGBA{255, 0, 0, 255}
+	blue := color.RGBA{0, 0, 255, 255}
+
+	tests := []struct {
+		region       string
+		size         string
+		rotation     string
+		quality      string
+		format       string
+		width        int
+		height       int
+		top_left     color.Color
+		content_type string
+	}{
+		{"full", "full", "0", "default", "png", 40, 20, red, "image/png"},
+		{"20,0,20,20", "full", "0", "default", "png", 20, 20, blue, "image/png"},
+		{"pct:0,0,50,100", "full", "0", "default", "png", 20, 20, red, "image/png"},
+		{"full", "10,", "0", "default", "png", 10, 5, red, "image/png"},
+		{"full", "80,", "0", "default", "png", 40, 20, red, "image/png"},
+		{"full", "!10,10", "0", "default", "png", 10, 5, red, "image/png"},
+		{"full", "full", "90", "default", "png", 20, 40, red, "image/png"},
+		{"full", "full", "180", "default", "png", 40, 20, blue, "image/png"},
+		{"full", "full", "!0", "default", "png", 40, 20, blue, "image/png"},
+		{"full", "full", "0", "gray", "png", 40, 20, color.Gray{76}, "image/png"},
+		{"full", "full", "0", "bitonal", "png", 40, 20, color.Gray{0}, "image/png"},
+		{"full", "full", "0", "default", "jpg", 40, 20, nil, "image/jpeg"},
+	}
+
+	for _, test := range tests {
+
+		src, err := iiifsource.NewMemorySource(buf.Bytes())
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		im, err := NewNativeImageFromConfigWithSource(&config, src, "test.png")
+
+		if err != nil {
+			t.Fatalf("Failed to create image, %s", err)
+		}
+
+		tr, err := NewTransformation(level, test.region, test.size, test.rotation, test.quality, test.format)
+
+		if err != nil {
+			t.Fatalf("Failed to create transformation %+v, %s", test, err)
+		}
+
+		err = im.Transform(tr)
+
+		if err != nil {
+			t.Fatalf("Failed to transform image %+v, %s", test, err)
+		}
+
+		if im.ContentType() != test.content_type {
+			t.Fatalf("Expected %s for %+v, got %s", test.content_type, test, im.ContentType())
+		}
+
+		result, _, err := image.Decode(bytes.NewReader(im.Body()))
+
+		if err != nil {
+			t.Fatalf("Failed to decode transformed image %+v, %s", test, err)
+		}
+
+		bounds := result.Bounds()
+
+		if bounds.Dx() != test.width || bounds.Dy() != test.height {
+			t.Fatalf("Expected %dx%d for %+v, got %dx%d", test.width, test.height, test, bounds.Dx(), bounds.Dy())
+		}
+
+		if test.top_left == nil {
+			continue
+		}
+
+		r1, g1, b1, _ := result.At(bounds.Min.X, bounds.Min.Y).RGBA()
+		r2, g2, b2, _ := test.top_left.RGBA()
+
+		if r1>>8 != r2>>8 || g1>>8 != g2>>8 || b1>>8 != b2>>8 {
+			t.Fatalf("Expected the top left pixel of %+v to be %v, got %v", test, test.top_left, result.At(bounds.Min.X, bounds.Min.Y))
+		}
+	}
+
+	// WEBP is a valid format (with the feature enabled above) but the
+	// native engine can only decode it
+
+	src, _ := iiifsource.NewMemorySource(buf.Bytes())
+	im, err := NewNativeImageFromConfigWithSource(&config, src, "test.png")
+
+	if err != nil {
+		t.Fatalf("Failed to create image, %s", err)
+	}
+
+	tr, err := NewTransformation(level, "full", "full", "0", "default", "webp")
+
+	if err != nil {
+		t.Fatalf("Failed to create transformation, %s", err)
+	}
+
+	err = im.Transform(tr)
+
+	if iiiferrors.StatusCode(err, 0) != http.StatusNotImplemented {
+		t.Fatalf("Expected WEBP to be an unsupported feature, got %v", err)
+	}
+}
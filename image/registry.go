@@ -0,0 +1,52 @@
+package image
+
+// See notes in source/registry.go - the names here are the ones used by the
+// "graphics.source.name" config property.
+
+import (
+	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	iiifsource "github.com/thisisaaronland/go-iiif/source"
+	"sort"
+	"sync"
+)
+
+type ImageInitializeFunc func(config *iiifconfig.Config, source iiifsource.Source, id string) (Image, error)
+
+var engines_mu = new(sync.RWMutex)
+var engines = make(map[string]ImageInitializeFunc)
+
+// Register makes a graphics engine available by name. Like sql.Register it
+// panics if it's called twice with the same name or with a nil function.
+
+func Register(name string, init_func ImageInitializeFunc) {
+
+	engines_mu.Lock()
+	defer engines_mu.Unlock()
+
+	if init_func == nil {
+		panic("image: Register function is nil")
+	}
+
+	_, dupe := engines[name]
+
+	if dupe {
+		panic("image: Register called twice for graphics engine " + name)
+	}
+
+	engines[name] = init_func
+}
+
+func Engines() []string {
+
+	engines_mu.RLock()
+	defer engines_mu.RUnlock()
+
+	names := make([]string, 0)
+
+	for name, _ := range engines {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
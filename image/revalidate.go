@@ -0,0 +1,148 @@
+package image
+
+// If the "revalidate" property of the images source config block is set then
+// images read from sources that can make conditional requests (see
+// iiifsource.ConditionalSource) are stored in the images cache along with the
+// validators (ETag and Last-Modified) that the source returned. Once a cached
+// image is more than "revalidate" seconds old the source is asked whether it
+// has changed and the image is only read again if it has. If the source says
+// the image no longer exists it is removed from the cache. If the source fails
+// in any other way the cached image is used, rather than failing the request.
+//
+// This only works with caches that implement iiifcache.ContextCache, which is
+// all of the caches that ship with go-iiif. The cache's own TTL still applies
+// so, if you're using revalidation, it should be longer than "revalidate" (or
+// 0, for no TTL at all).
+
+import (
+	"context"
+	"fmt"
+	iiifcache "github.com/thisisaaronland/go-iiif/cache"
+	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	iiiferrors "github.com/thisisaaronland/go-iiif/errors"
+	iiifsource "github.com/thisisaaronland/go-iiif/source"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const metaETag = "Iiif-Etag"
+const metaLastModified = "Iiif-Last-Modified"
+const metaChecked = "Iiif-Checked"
+
+// setSource stores an image that has just been read from its source in the
+// images cache along with, if it will be revalidated later, its validators
+
+func setSource(config *iiifconfig.Config, cache iiifcache.Cache, id string, body []byte, info *iiifsource.SourceInfo, conditional bool) error {
+
+	cc, ok := cache.(iiifcache.ContextCache)
+
+	if !ok || !conditional || info == nil || config.Images.Source.Revalidate <= 0 {
+		return cache.Set(id, body)
+	}
+
+	meta := make(map[string]string)
+	meta[metaChecked] = strconv.FormatInt(time.Now().Unix(), 10)
+
+	if info.ETag != "" {
+		meta[metaETag] = info.ETag
+	}
+
+	if !info.ModTime.IsZero() {
+		meta[metaLastModified] = info.ModTime.UTC().Format(http.TimeFormat)
+	}
+
+	opts := iiifcache.SetOptions{
+		Metadata: meta,
+	}
+
+	return cc.SetWithOptions(context.Background(), id, body, &opts)
+}
+
+// revalidateSource returns cached, which is the cached copy of id, if it is
+// still fresh or hasn't changed and otherwise the (new) image read from source
+
+func revalidateSource(config *iiifconfig.Config, cache iiifcache.Cache, source iiifsource.Source, id string, cached []byte) ([]byte, error) {
+
+	cc, ok := cache.(iiifcache.ContextCache)
+
+	if !ok {
+		return cached, nil
+	}
+
+	cs, ok := source.(iiifsource.ConditionalSource)
+
+	if !ok {
+		return cached, nil
+	}
+
+	ctx := context.Background()
+
+	entry, err := cc.Stat(ctx, id)
+
+	if err != nil {
+		return cached, nil
+	}
+
+	// images that were cached without any validators (because their
+	// source can't make conditional requests) are never revalidated
+
+	checked, err := strconv.ParseInt(entry.Metadata[metaChecked], 10, 64)
+
+	if err != nil {
+		return cached, nil
+	}
+
+	max_age := time.Duration(config.Images.Source.Revalidate) * time.Second
+
+	if time.Since(time.Unix(checked, 0)) < max_age {
+		return cached, nil
+	}
+
+	src := config.Images.Source
+	key := fmt.Sprintf("%s#%s#%s#revalidate", src.Name, src.Path, id)
+
+	body, _, err := sources.Do(key, func() ([]byte, error) {
+
+		info := iiifsource.SourceInfo{
+			ETag: entry.Metadata[metaETag],
+		}
+
+		t, err := http.ParseTime(entry.Metadata[metaLastModified])
+
+		if err == nil {
+			info.ModTime = t
+		}
+
+		fh, new_info, err := cs.OpenIfChanged(ctx, id, &info)
+
+		if err == iiifsource.ErrNotModified {
+			go setSource(config, cache, id, cached, &info, true)
+			return cached, nil
+		}
+
+		if err != nil {
+
+			if iiiferrors.IsNotFound(err) {
+				cc.UnsetContext(ctx, id)
+				return nil, err
+			}
+
+			return cached, nil
+		}
+
+		defer fh.Close()
+
+		new_body, err := readBody(config, id, fh, new_info)
+
+		if err != nil {
+			return cached, nil
+		}
+
+		go setSource(config, cache, id, new_body, new_info, true)
+
+		return new_body, nil
+	})
+
+	return body, err
+}
@@ -0,0 +1,212 @@
+package image
+
+import (
+	"context"
+	iiifcache "github.com/thisisaaronland/go-iiif/cache"
+	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	iiiferrors "github.com/thisisaaronland/go-iiif/errors"
+	iiifsource "github.com/thisisaaronland/go-iiif/source"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// setStale stores body in cache as though it was last checked an hour ago,
+// with etag as its validator
+
+func setStale(t *testing.T, cache iiifcache.ContextCache, id string, body string, etag string) {
+
+	meta := map[string]string{
+		metaChecked: strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10),
+		metaETag:    etag,
+	}
+
+	opts := iiifcache.SetOptions{
+		Metadata: meta,
+	}
+
+	err := cache.SetWithOptions(context.Background(), id, []byte(body), &opts)
+
+	if err != nil {
+		t.Fatalf("Failed to set %s, %s", id, err)
+	}
+}
+
+// waitForCache waits for the goroutine that stores a revalidated image to
+// update id's entry in cache
+
+func waitForCache(t *testing.T, cache iiifcache.ContextCache, id string, fn func(*iiifcache.EntryInfo, []byte) bool) {
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for time.Now().Before(deadline) {
+
+		info, err := cache.Stat(context.Background(), id)
+
+		if err == nil {
+
+			body, err := cache.Get(id)
+
+			if err == nil && fn(info, body) {
+				return
+			}
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("Timed out waiting for %s to be updated in the cache", id)
+}
+
+func TestRevalidateSource(t *testing.T) {
+
+	var requests int32
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		atomic.AddInt32(&requests, 1)
+
+		switch r.URL.Path {
+		case "/missing.jpg":
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case "/down.jpg":
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		if r.Header.Get("If-None-Match") == `"v2"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("ETag", `"v2"`)
+		w.Write([]byte("new"))
+	}))
+
+	defer ts.Close()
+
+	config := iiifconfig.Config{}
+
+	config.Images.Source = iiifconfig.SourceConfig{
+		Name:       "URI",
+		Path:       ts.URL + "/{id}",
+		Revalidate: 60,
+	}
+
+	source, err := iiifsource.NewSourceFromConfig(&config)
+
+	if err != nil {
+		t.Fatalf("Failed to create source, %s", err)
+	}
+
+	cache, err := iiifcache.NewMemoryCache(iiifconfig.CacheConfig{})
+
+	if err != nil {
+		t.Fatalf("Failed to create cache, %s", err)
+	}
+
+	defer cache.Close()
+
+	// images that were checked recently aren't checked again
+
+	err = setSource(&config, cache, "fresh.jpg", []byte("fresh"), &iiifsource.SourceInfo{ETag: `"v1"`}, true)
+
+	if err != nil {
+		t.Fatalf("Failed to set source, %s", err)
+	}
+
+	body, err := revalidateSource(&config, cache, source, "fresh.jpg", []byte("fresh"))
+
+	if err != nil || string(body) != "fresh" {
+		t.Fatalf("Expected the cached image, got '%s' and %v", body, err)
+	}
+
+	// images cached from sources that can't make conditional requests are
+	// stored without validators, and never revalidated
+
+	err = setSource(&config, cache, "plain.jpg", []byte("plain"), &iiifsource.SourceInfo{}, false)
+
+	if err != nil {
+		t.Fatalf("Failed to set source, %s", err)
+	}
+
+	info, err := cache.Stat(context.Background(), "plain.jpg")
+
+	if err != nil || info.Metadata[metaChecked] != "" {
+		t.Fatalf("Expected no validators, got %+v %v", info, err)
+	}
+
+	body, err = revalidateSource(&config, cache, source, "plain.jpg", []byte("plain"))
+
+	if err != nil || string(body) != "plain" {
+		t.Fatalf("Expected the cached image, got '%s' and %v", body, err)
+	}
+
+	if n := atomic.LoadInt32(&requests); n != 0 {
+		t.Fatalf("Expected no requests for fresh images, got %d", n)
+	}
+
+	// images that haven't changed are kept, and checked again later
+
+	setStale(t, cache, "same.jpg", "same", `"v2"`)
+
+	body, err = revalidateSource(&config, cache, source, "same.jpg", []byte("same"))
+
+	if err != nil || string(body) != "same" {
+		t.Fatalf("Expected the cached image, got '%s' and %v", body, err)
+	}
+
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Fatalf("Expected 1 conditional request, got %d", n)
+	}
+
+	waitForCache(t, cache, "same.jpg", func(info *iiifcache.EntryInfo, body []byte) bool {
+
+		checked, _ := strconv.ParseInt(info.Metadata[metaChecked], 10, 64)
+		return time.Since(time.Unix(checked, 0)) < time.Minute && info.Metadata[metaETag] == `"v2"`
+	})
+
+	// images that have changed are read again, along with their new
+	// validators
+
+	setStale(t, cache, "changed.jpg", "old", `"v1"`)
+
+	body, err = revalidateSource(&config, cache, source, "changed.jpg", []byte("old"))
+
+	if err != nil || string(body) != "new" {
+		t.Fatalf("Expected the new image, got '%s' and %v", body, err)
+	}
+
+	waitForCache(t, cache, "changed.jpg", func(info *iiifcache.EntryInfo, body []byte) bool {
+		return string(body) == "new" && info.Metadata[metaETag] == `"v2"`
+	})
+
+	// images that have gone away are removed from the cache
+
+	setStale(t, cache, "missing.jpg", "missing", `"v1"`)
+
+	_, err = revalidateSource(&config, cache, source, "missing.jpg", []byte("missing"))
+
+	if !iiiferrors.IsNotFound(err) {
+		t.Fatalf("Expected a NotFound error, got %v", err)
+	}
+
+	if cache.Exists("missing.jpg") {
+		t.Fatal("Expected a missing image to be removed from the cache")
+	}
+
+	// but if the source is having a bad day the cached image is used
+
+	setStale(t, cache, "down.jpg", "down", `"v1"`)
+
+	body, err = revalidateSource(&config, cache, source, "down.jpg", []byte("down"))
+
+	if err != nil || string(body) != "down" {
+		t.Fatalf("Expected the cached image, got '%s' and %v", body, err)
+	}
+}
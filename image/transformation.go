@@ -3,6 +3,8 @@ package image
 import (
 	"errors"
 	"fmt"
+	iiifcompliance "github.com/thisisaaronland/go-iiif/compliance"
+	iiiferrors "github.com/thisisaaronland/go-iiif/errors"
 	iiiflevel "github.com/thisisaaronland/go-iiif/level"
 	"math"
 	"net/url"
@@ -79,6 +81,8 @@ func NewTransformation(level iiiflevel.Level, region string, size string, rotati
 	}
 
 	// http://iiif.io/api/image/2.1/#canonical-uri-syntax (sigh...)
+	// http://iiif.io/api/image/3.0/#47-canonical-uri-syntax is less sigh-y about
+	// this but we still want both versions to use the same canonical quality
 
 	if quality == "default" {
 
@@ -128,13 +132,52 @@ func (t *Transformation) ToURI(id string) (string, error) {
 	return uri, nil
 }
 
+// ToCacheKey returns the key for a derivative in the derivatives cache. The
+// same URI doesn't always mean the same thing in 2.1 and 3.0 (upscaling, for
+// example) so derivatives for anything but the default version are cached
+// below a "{VERSION}/" prefix. Derivatives for the default version are cached
+// using their canonical URI, as they always have been, so that existing caches
+// (and tiles seeded ahead of time) keep working.
+
+func (t *Transformation) ToCacheKey(id string) (string, error) {
+
+	uri, err := t.ToURI(id)
+
+	if err != nil {
+		return "", err
+	}
+
+	version := t.Version()
+
+	if version == iiifcompliance.DefaultVersion {
+		return uri, nil
+	}
+
+	key := fmt.Sprintf("%s/%s", version, uri)
+	return key, nil
+}
+
+func (t *Transformation) Version() string {
+
+	return t.level.Compliance().Version()
+}
+
 func (t *Transformation) HasTransformation() bool {
 
 	if t.Region != "full" {
 		return true
 	}
 
-	if t.Size != "full" {
+	if t.Size != "full" && t.Size != "max" {
+		return true
+	}
+
+	// "full" and "max" might still mean scaling an image down to fit inside the
+	// configured limits and that can't be known without looking at the image
+
+	max_w, max_h, max_area := t.sizeLimits()
+
+	if max_w > 0 || max_h > 0 || max_area > 0 {
 		return true
 	}
 
@@ -162,24 +205,24 @@ func (t *Transformation) RegionInstructions(im Image) (*RegionInstruction, error
 
 	if t.Region == "square" {
 
-		var x int
-		var y int
+		// the largest centered square that fits in the image
+
+		x := 0
+		y := 0
+		side := width
 
 		if width < height {
 			y = (height - width) / 2.
-			x = width
 		} else {
 			x = (width - height) / 2.
-			y = height
+			side = height
 		}
 
-		y = x
-
 		instruction := RegionInstruction{
 			X:      x,
 			Y:      y,
-			Width:  width,
-			Height: height,
+			Width:  side,
+			Height: side,
 		}
 
 		return &instruction, nil
@@ -193,31 +236,31 @@ func (t *Transformation) RegionInstructions(im Image) (*RegionInstruction, error
 
 		if len(sizes) != 4 {
 			message := fmt.Sprintf("Invalid region")
-			return nil, errors.New(message)
+			return nil, iiiferrors.NewInvalidParameterError("region", errors.New(message))
 		}
 
 		x, err := strconv.ParseInt(sizes[0], 10, 64)
 
 		if err != nil {
-			return nil, err
+			return nil, iiiferrors.NewInvalidParameterError("region", err)
 		}
 
 		y, err := strconv.ParseInt(sizes[1], 10, 64)
 
 		if err != nil {
-			return nil, err
+			return nil, iiiferrors.NewInvalidParameterError("region", err)
 		}
 
 		w, err := strconv.ParseInt(sizes[2], 10, 64)
 
 		if err != nil {
-			return nil, err
+			return nil, iiiferrors.NewInvalidParameterError("region", err)
 		}
 
 		h, err := strconv.ParseInt(sizes[3], 10, 64)
 
 		if err != nil {
-			return nil, err
+			return nil, iiiferrors.NewInvalidParameterError("region", err)
 		}
 
 		instruction := RegionInstruction{
@@ -250,6 +293,14 @@ func (t *Transformation) RegionInstructions(im Image) (*RegionInstruction, error
 			instruction.Height = height - instruction.Y
 		}
 
+		// http://iiif.io/api/image/2.1/#region - a region entirely outside of
+		// the image (or with no width or height) is a bad request
+
+		if instruction.Width <= 0 || instruction.Height <= 0 {
+			message := fmt.Sprintf("Invalid region %s for image with dimensions %d,%d", t.Region, width, height)
+			return nil, iiiferrors.NewInvalidParameterError("region", errors.New(message))
+		}
+
 		return &instruction, nil
 
 	}
@@ -259,32 +310,32 @@ func (t *Transformation) RegionInstructions(im Image) (*RegionInstruction, error
 		sizes := strings.Split(arr[1], ",")
 
 		if len(sizes) != 4 {
-			message := fmt.Sprintf("Invalid region", t.Region)
-			return nil, errors.New(message)
+			message := fmt.Sprintf("Invalid region %s", t.Region)
+			return nil, iiiferrors.NewInvalidParameterError("region", errors.New(message))
 		}
 
 		px, err := strconv.ParseFloat(sizes[0], 64)
 
 		if err != nil {
-			return nil, err
+			return nil, iiiferrors.NewInvalidParameterError("region", err)
 		}
 
 		py, err := strconv.ParseFloat(sizes[1], 64)
 
 		if err != nil {
-			return nil, err
+			return nil, iiiferrors.NewInvalidParameterError("region", err)
 		}
 
 		pw, err := strconv.ParseFloat(sizes[2], 64)
 
 		if err != nil {
-			return nil, err
+			return nil, iiiferrors.NewInvalidParameterError("region", err)
 		}
 
 		ph, err := strconv.ParseFloat(sizes[3], 64)
 
 		if err != nil {
-			return nil, err
+			return nil, iiiferrors.NewInvalidParameterError("region", err)
 		}
 
 		w := int(math.Ceil(float64(width) * pw / 100.))
@@ -305,29 +356,85 @@ func (t *Transformation) RegionInstructions(im Image) (*RegionInstruction, error
 	}
 
 	message := fmt.Sprintf("Unrecognized region")
-	return nil, errors.New(message)
+	return nil, iiiferrors.NewInvalidParameterError("region", errors.New(message))
 
 }
 
 func (t *Transformation) SizeInstructions(im Image) (*SizeInstruction, error) {
 
-	sizeError := "IIIF 2.1 `size` argument is not recognized: %#v"
+	version := t.Version()
+	sizeError := fmt.Sprintf("IIIF %s `size` argument is not recognized: %%#v", iiifcompliance.SpecVersion(version))
+
+	dims, err := im.Dimensions()
+
+	if err != nil {
+		return nil, err
+	}
+
+	width := dims.Width()
+	height := dims.Height()
 
 	w := 0
 	h := 0
 	force := false
 	enlarge := false
 
-	arr := strings.Split(t.Size, ":")
+	// http://iiif.io/api/image/3.0/#42-size
+
+	size := t.Size
+	upscale := false
+
+	if version == iiifcompliance.Version3 && strings.HasPrefix(size, "^") {
+		size = strings.TrimPrefix(size, "^")
+		upscale = true
+	}
+
+	if size == "full" || size == "max" {
+
+		w = width
+		h = height
+
+		// "max" is the largest size that the configured limits allow (which is
+		// bigger than the region for "^max") where as "full" is always the size
+		// of the region and is subject to the same limits as everything else
+
+		if size == "max" {
+
+			scale := t.maxScale(width, height, upscale)
+
+			if scale != 1. {
+				w = int(math.Max(1, math.Floor(float64(width)*scale)))
+				h = int(math.Max(1, math.Floor(float64(height)*scale)))
+				force = true
+			}
+		}
+
+		err := t.checkSizeLimits(w, h)
+
+		if err != nil {
+			return nil, err
+		}
+
+		instruction := SizeInstruction{
+			Height:  h,
+			Width:   w,
+			Enlarge: upscale,
+			Force:   force,
+		}
+
+		return &instruction, nil
+	}
+
+	arr := strings.Split(size, ":")
 
 	if len(arr) == 1 {
 
-		best := strings.HasPrefix(t.Size, "!")
+		best := strings.HasPrefix(size, "!")
 		sizes := strings.Split(strings.Trim(arr[0], "!"), ",")
 
 		if len(sizes) != 2 {
 			message := fmt.Sprintf(sizeError, t.Size)
-			return nil, errors.New(message)
+			return nil, iiiferrors.NewInvalidParameterError("size", errors.New(message))
 		}
 
 		wi, err_w := strconv.ParseInt(sizes[0], 10, 64)
@@ -335,7 +442,7 @@ func (t *Transformation) SizeInstructions(im Image) (*SizeInstruction, error) {
 
 		if err_w != nil && err_h != nil {
 			message := fmt.Sprintf(sizeError, t.Size)
-			return nil, errors.New(message)
+			return nil, iiiferrors.NewInvalidParameterError("size", errors.New(message))
 
 		} else if err_w == nil && err_h == nil {
 
@@ -356,6 +463,47 @@ func (t *Transformation) SizeInstructions(im Image) (*SizeInstruction, error) {
 			h = int(hi)
 		}
 
+		if version == iiifcompliance.Version3 {
+
+			// in 3.0 nothing gets bigger than the region unless you ask for it with "^"
+
+			enlarge = upscale
+
+			if !upscale && !best && (w > width || h > height) {
+				message := fmt.Sprintf("IIIF 3.0 `size` argument %#v is larger than the region (%d,%d) and does not allow upscaling", t.Size, width, height)
+				return nil, iiiferrors.NewInvalidParameterError("size", errors.New(message))
+			}
+		}
+
+		// work out the final dimensions of the image, which may not be w and h,
+		// so they can be checked against the configured limits
+
+		out_w := w
+		out_h := h
+
+		if w != 0 && h != 0 && best {
+
+			scale := math.Min(float64(w)/float64(width), float64(h)/float64(height))
+
+			if scale > 1. && !enlarge {
+				scale = 1.
+			}
+
+			out_w = int(math.Floor(float64(width) * scale))
+			out_h = int(math.Floor(float64(height) * scale))
+
+		} else if h == 0 {
+			out_h = int(math.Ceil(float64(height) * float64(w) / float64(width)))
+		} else if w == 0 {
+			out_w = int(math.Ceil(float64(width) * float64(h) / float64(height)))
+		}
+
+		err := t.checkSizeLimits(out_w, out_h)
+
+		if err != nil {
+			return nil, err
+		}
+
 		instruction := SizeInstruction{
 			Height:  h,
 			Width:   w,
@@ -371,25 +519,32 @@ func (t *Transformation) SizeInstructions(im Image) (*SizeInstruction, error) {
 
 		if err != nil {
 			err := errors.New("invalid size")
-			return nil, err
+			return nil, iiiferrors.NewInvalidParameterError("size", err)
 		}
 
-		dims, err := im.Dimensions()
+		if version == iiifcompliance.Version3 {
 
-		if err != nil {
-			return nil, err
-		}
+			if !upscale && pct > 100. {
+				message := fmt.Sprintf("IIIF 3.0 `size` argument %#v is larger than 100%% and does not allow upscaling", t.Size)
+				return nil, iiiferrors.NewInvalidParameterError("size", errors.New(message))
+			}
 
-		width := dims.Width()
-		height := dims.Height()
+			enlarge = upscale
+		}
 
 		w = int(math.Ceil(pct / 100 * float64(width)))
 		h = int(math.Ceil(pct / 100 * float64(height)))
 
+		err = t.checkSizeLimits(w, h)
+
+		if err != nil {
+			return nil, err
+		}
+
 	} else {
 
 		message := fmt.Sprintf(sizeError, t.Size)
-		return nil, errors.New(message)
+		return nil, iiiferrors.NewInvalidParameterError("size", errors.New(message))
 	}
 
 	instruction := SizeInstruction{
@@ -403,16 +558,80 @@ func (t *Transformation) SizeInstructions(im Image) (*SizeInstruction, error) {
 
 }
 
+// sizeLimits returns the maximum width, height and area of an image as defined
+// in the "limits" config block, where zero means there isn't one. Per the 3.0
+// spec if there is a maximum width but no maximum height then it is assumed to
+// be the same as the width.
+
+func (t *Transformation) sizeLimits() (int, int, int) {
+
+	limits := t.level.Limits()
+
+	max_w := limits.MaxWidth
+	max_h := limits.MaxHeight
+
+	if max_h == 0 {
+		max_h = max_w
+	}
+
+	return max_w, max_h, limits.MaxArea
+}
+
+// maxScale returns the factor by which an image needs to be scaled to be as big
+// as the configured limits allow, which will never be more than 1 unless upscale
+// is true and which is 1 if there are no limits.
+
+func (t *Transformation) maxScale(width int, height int, upscale bool) float64 {
+
+	max_w, max_h, max_area := t.sizeLimits()
+
+	scale := math.Inf(1)
+
+	if max_w > 0 {
+		scale = math.Min(scale, float64(max_w)/float64(width))
+	}
+
+	if max_h > 0 {
+		scale = math.Min(scale, float64(max_h)/float64(height))
+	}
+
+	if max_area > 0 {
+		scale = math.Min(scale, math.Sqrt(float64(max_area)/float64(width*height)))
+	}
+
+	if math.IsInf(scale, 1) {
+		return 1.
+	}
+
+	if scale > 1. && !upscale {
+		return 1.
+	}
+
+	return scale
+}
+
+func (t *Transformation) checkSizeLimits(w int, h int) error {
+
+	max_w, max_h, max_area := t.sizeLimits()
+
+	if (max_w > 0 && w > max_w) || (max_h > 0 && h > max_h) || (max_area > 0 && w*h > max_area) {
+		message := fmt.Sprintf("Size %#v (%d,%d) exceeds the maximum allowed size", t.Size, w, h)
+		return iiiferrors.NewInvalidParameterError("size", errors.New(message))
+	}
+
+	return nil
+}
+
 func (t *Transformation) RotationInstructions(im Image) (*RotationInstruction, error) {
 
-	rotationError := "IIIF 2.1 `rotation` argument is not recognized: %#v"
+	rotationError := fmt.Sprintf("IIIF %s `rotation` argument is not recognized: %%#v", iiifcompliance.SpecVersion(t.Version()))
 
 	flip := strings.HasPrefix(t.Rotation, "!")
 	angle, err := strconv.ParseInt(strings.Trim(t.Rotation, "!"), 10, 64)
 
 	if err != nil {
 		message := fmt.Sprintf(rotationError, t.Rotation)
-		return nil, errors.New(message)
+		return nil, iiiferrors.NewInvalidParameterError("rotation", errors.New(message))
 
 	}
 
@@ -446,7 +665,7 @@ func (t *Transformation) FormatInstructions(im Image) (*FormatInstruction, error
 	}
 
 	if fmt == "" {
-		return nil, errors.New("failed to determine format")
+		return nil, iiiferrors.NewInvalidParameterError("format", errors.New("failed to determine format"))
 	}
 
 	instruction := FormatInstruction{
@@ -1,3 +1,6 @@
+//go:build !novips
+// +build !novips
+
 package image
 
 // https://github.com/h2non/bimg
@@ -5,6 +8,7 @@ package image
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	iiifconfig "github.com/thisisaaronland/go-iiif/config"
@@ -13,6 +17,7 @@ import (
 	"image"
 	"image/gif"
 	_ "log"
+	"os"
 	"strconv"
 	"strings"
 )
@@ -64,9 +69,40 @@ func (dims *GolangImageDimensions) Height() int {
 	return bounds.Max.Y
 }
 
+func init() {
+
+	Register("VIPS", func(config *iiifconfig.Config, src iiifsource.Source, id string) (Image, error) {
+
+		/*
+			http://www.vips.ecs.soton.ac.uk/supported/7.42/doc/html/libvips/VipsImage.html#vips-image-new-temp-file
+		*/
+
+		if config.Graphics.Source.Tmpdir != "" {
+
+			tmpdir := config.Graphics.Source.Tmpdir
+
+			_, err := os.Stat(tmpdir)
+
+			if os.IsNotExist(err) {
+				return nil, err
+			}
+
+			os.Setenv("TMPDIR", tmpdir)
+		}
+
+		im, err := NewVIPSImageFromConfigWithSource(config, src, id)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return im, nil
+	})
+}
+
 func NewVIPSImageFromConfigWithSource(config *iiifconfig.Config, src iiifsource.Source, id string) (*VIPSImage, error) {
 
-	body, err := src.Read(id)
+	body, err := ReadSource(context.Background(), config, src, id)
 
 	if err != nil {
 		return nil, err
@@ -97,6 +133,12 @@ func NewVIPSImageFromConfigWithSource(config *iiifconfig.Config, src iiifsource.
 		See also: https://github.com/h2non/bimg/issues/41
 	*/
 
+	err = CheckSourcePixels(config, id, &im)
+
+	if err != nil {
+		return nil, err
+	}
+
 	return &im, nil
 }
 
@@ -232,29 +274,17 @@ func (im *VIPSImage) Transform(t *Transformation) error {
 
 	}
 
-	dims, err := im.Dimensions()
+	si, err := t.SizeInstructions(im)
 
 	if err != nil {
 		return err
 	}
 
 	opts = bimg.Options{
-		Width:  dims.Width(),  // opts.AreaWidth,
-		Height: dims.Height(), // opts.AreaHeight,
-	}
-
-	if t.Size != "max" && t.Size != "full" {
-
-		si, err := t.SizeInstructions(im)
-
-		if err != nil {
-			return err
-		}
-
-		opts.Height = si.Height
-		opts.Width = si.Width
-		opts.Enlarge = si.Enlarge
-		opts.Force = si.Force
+		Width:   si.Width,
+		Height:  si.Height,
+		Enlarge: si.Enlarge,
+		Force:   si.Force,
 	}
 
 	ri, err := t.RotationInstructions(im)
@@ -9,37 +9,80 @@ very much related but somehow seem like they should be in separate namespaces. I
 */
 
 import (
-	"errors"
-	"fmt"
 	iiifcompliance "github.com/thisisaaronland/go-iiif/compliance"
 	iiifconfig "github.com/thisisaaronland/go-iiif/config"
 )
 
 type Level interface {
 	Compliance() iiifcompliance.Compliance
+	Limits() iiifconfig.LimitsConfig
+}
+
+// ComplianceLevel is the Level for all of the compliance levels, since they
+// only differ in the compliance spec (which is to say the set of required and
+// supported features) they are built from.
+
+type ComplianceLevel struct {
+	Level      `json:"-"`
+	Formats    []string                  `json:"formats"`
+	Qualities  []string                  `json:"qualities"`
+	Supports   []string                  `json:"supports"`
+	MaxWidth   int                       `json:"maxWidth,omitempty"`
+	MaxHeight  int                       `json:"maxHeight,omitempty"`
+	MaxArea    int                       `json:"maxArea,omitempty"`
+	compliance iiifcompliance.Compliance `json:"-"`
+	limits     iiifconfig.LimitsConfig   `json:"-"`
 }
 
 func NewLevelFromConfig(config *iiifconfig.Config, endpoint string) (Level, error) {
 
-	compliance := config.Level.Compliance
+	return NewLevelFromConfigWithVersion(config, endpoint, config.Level.Version)
+}
+
+func NewLevelFromConfigWithVersion(config *iiifconfig.Config, endpoint string, version string) (Level, error) {
+
+	return NewComplianceLevelWithVersion(config, endpoint, config.Level.Compliance, version)
+}
+
+func NewLevel2(config *iiifconfig.Config, endpoint string) (*ComplianceLevel, error) {
 
-	if compliance == "0" {
+	return NewLevel2WithVersion(config, endpoint, config.Level.Version)
+}
 
-		message := fmt.Sprintf("Unsupported compliance level '%s'", compliance)
-		return nil, errors.New(message)
+func NewLevel2WithVersion(config *iiifconfig.Config, endpoint string, version string) (*ComplianceLevel, error) {
 
-	} else if compliance == "1" {
+	return NewComplianceLevelWithVersion(config, endpoint, "2", version)
+}
 
-		message := fmt.Sprintf("Unsupported compliance level '%s'", compliance)
-		return nil, errors.New(message)
-	} else if compliance == "2" {
+// NewComplianceLevelWithVersion returns the Level for a compliance level, as in
+// the "level.compliance" config property, and a version of the Image API.
 
-		return NewLevel2(config, endpoint)
+func NewComplianceLevelWithVersion(config *iiifconfig.Config, endpoint string, level string, version string) (*ComplianceLevel, error) {
 
-	} else {
+	compliance, err := iiifcompliance.NewComplianceWithVersion(config, level, version)
 
-		message := fmt.Sprintf("Invalid compliance level '%s'", compliance)
-		return nil, errors.New(message)
+	if err != nil {
+		return nil, err
+	}
 
+	l := ComplianceLevel{
+		Formats:    compliance.Formats(),
+		Qualities:  compliance.Qualities(),
+		Supports:   compliance.Supports(),
+		MaxWidth:   config.Limits.MaxWidth,
+		MaxHeight:  config.Limits.MaxHeight,
+		MaxArea:    config.Limits.MaxArea,
+		compliance: compliance,
+		limits:     config.Limits,
 	}
+
+	return &l, nil
+}
+
+func (l *ComplianceLevel) Compliance() iiifcompliance.Compliance {
+	return l.compliance
+}
+
+func (l *ComplianceLevel) Limits() iiifconfig.LimitsConfig {
+	return l.limits
 }
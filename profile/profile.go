@@ -2,8 +2,11 @@ package profile
 
 import (
 	"fmt"
+	iiifcompliance "github.com/thisisaaronland/go-iiif/compliance"
+	iiifconfig "github.com/thisisaaronland/go-iiif/config"
 	iiifimage "github.com/thisisaaronland/go-iiif/image"
 	iiiflevel "github.com/thisisaaronland/go-iiif/level"
+	"sort"
 )
 
 type Profile struct {
@@ -14,8 +17,73 @@ type Profile struct {
 	Width    int           `json:"width"`
 	Height   int           `json:"height"`
 	Profile  []interface{} `json:"profile"`
-	//	Sizes    []string `json:"sizes"` // Optional, existing/supported sizes.
-	//	Tiles    []string `json:"tiles"` // Optional
+	Sizes    []Size        `json:"sizes,omitempty"` // Optional, existing/supported sizes.
+	Tiles    []Tile        `json:"tiles,omitempty"` // Optional
+}
+
+// http://iiif.io/api/image/3.0/#5-image-information
+
+type Profile3 struct {
+	Context        string   `json:"@context"`
+	Id             string   `json:"id"`
+	Type           string   `json:"type"` // ImageService3
+	Protocol       string   `json:"protocol"`
+	Profile        string   `json:"profile"`
+	Width          int      `json:"width"`
+	Height         int      `json:"height"`
+	MaxWidth       int      `json:"maxWidth,omitempty"`
+	MaxHeight      int      `json:"maxHeight,omitempty"`
+	MaxArea        int      `json:"maxArea,omitempty"`
+	Sizes          []Size   `json:"sizes,omitempty"`
+	Tiles          []Tile   `json:"tiles,omitempty"`
+	ExtraQualities []string `json:"extraQualities,omitempty"`
+	ExtraFormats   []string `json:"extraFormats,omitempty"`
+	ExtraFeatures  []string `json:"extraFeatures,omitempty"`
+}
+
+// NewProfileFromConfig returns either a Profile or a Profile3 depending on which
+// version of the Image API level was created for, with sizes and tiles as
+// defined in the "profile" config block. Both are meant to be handed to
+// json.Marshal and written out as info.json
+
+func NewProfileFromConfig(config *iiifconfig.Config, endpoint string, image iiifimage.Image, level iiiflevel.Level) (interface{}, error) {
+
+	sizes, err := SizesFromConfig(config, image)
+
+	if err != nil {
+		return nil, err
+	}
+
+	tiles, err := TilesFromConfig(config, image)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if level.Compliance().Version() == iiifcompliance.Version3 {
+
+		p, err := NewProfile3(endpoint, image, level)
+
+		if err != nil {
+			return nil, err
+		}
+
+		p.Sizes = sizes
+		p.Tiles = tiles
+
+		return p, nil
+	}
+
+	p, err := NewProfile(endpoint, image, level)
+
+	if err != nil {
+		return nil, err
+	}
+
+	p.Sizes = sizes
+	p.Tiles = tiles
+
+	return p, nil
 }
 
 func NewProfile(endpoint string, image iiifimage.Image, level iiiflevel.Level) (*Profile, error) {
@@ -27,19 +95,83 @@ func NewProfile(endpoint string, image iiifimage.Image, level iiiflevel.Level) (
 	}
 
 	p := Profile{
-		Context:  "http://iiif.io/api/image/2/context.json",
+		Context:  iiifcompliance.ContextURI(iiifcompliance.Version2),
 		Id:       fmt.Sprintf("%s/%s", endpoint, image.Identifier()),
 		Type:     "iiif:Image",
 		Protocol: "http://iiif.io/api/image",
 		Width:    dims.Width(),
 		Height:   dims.Height(),
 		Profile: []interface{}{
-			"http://iiif.io/api/image/2/level2.json",
+			iiifcompliance.ComplianceURI(iiifcompliance.Version2, level.Compliance().Level()),
 			level,
 		},
-		//Sizes: []ProfileSize{},
-		//Tiles: []ProfileTile{},
 	}
 
 	return &p, nil
 }
+
+func NewProfile3(endpoint string, image iiifimage.Image, level iiiflevel.Level) (*Profile3, error) {
+
+	dims, err := image.Dimensions()
+
+	if err != nil {
+		return nil, err
+	}
+
+	spec := level.Compliance().Spec()
+	limits := level.Limits()
+
+	features := make([]string, 0)
+
+	for _, sect := range []map[string]iiifcompliance.ComplianceDetails{spec.Image.Region, spec.Image.Size, spec.Image.Rotation} {
+
+		for _, name := range extras(sect) {
+			features = append(features, name)
+		}
+	}
+
+	for name, details := range spec.HTTP {
+
+		if details.Supported && !details.Required {
+			features = append(features, name)
+		}
+	}
+
+	sort.Strings(features)
+
+	p := Profile3{
+		Context:        iiifcompliance.ContextURI(iiifcompliance.Version3),
+		Id:             fmt.Sprintf("%s/%s", endpoint, image.Identifier()),
+		Type:           "ImageService3",
+		Protocol:       "http://iiif.io/api/image",
+		Profile:        fmt.Sprintf("level%s", level.Compliance().Level()),
+		Width:          dims.Width(),
+		Height:         dims.Height(),
+		ExtraQualities: extras(spec.Image.Quality),
+		ExtraFormats:   extras(spec.Image.Format),
+		ExtraFeatures:  features,
+		MaxWidth:       limits.MaxWidth,
+		MaxHeight:      limits.MaxHeight,
+		MaxArea:        limits.MaxArea,
+	}
+
+	return &p, nil
+}
+
+// extras returns the names of the features that are supported but that the
+// compliance level doesn't require, which is what 3.0 wants in the extra* lists
+
+func extras(sect map[string]iiifcompliance.ComplianceDetails) []string {
+
+	names := make([]string, 0)
+
+	for name, details := range sect {
+
+		if details.Supported && !details.Required {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,136 @@
+package profile
+
+import (
+	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	iiifimage "github.com/thisisaaronland/go-iiif/image"
+	"math"
+	"sort"
+)
+
+// http://iiif.io/api/image/2.1/#image-information
+// http://iiif.io/api/image/3.0/#53-sizes
+// http://iiif.io/api/image/3.0/#54-tiles
+
+const DefaultTileSize = 256
+
+type Size struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+type Tile struct {
+	Width        int   `json:"width"`
+	Height       int   `json:"height,omitempty"`
+	ScaleFactors []int `json:"scaleFactors"`
+}
+
+// TileSizeFromConfig returns the width and height of tiles, defaulting to
+// DefaultTileSize and to square tiles if only a width has been defined.
+
+func TileSizeFromConfig(config *iiifconfig.Config) (int, int) {
+
+	w := config.Profile.Tiles.Width
+	h := config.Profile.Tiles.Height
+
+	if w <= 0 {
+		w = DefaultTileSize
+	}
+
+	if h <= 0 {
+		h = w
+	}
+
+	return w, h
+}
+
+// ScaleFactorsFromConfig returns the scale factors defined in the config file
+// or, if there aren't any, all the powers of two for which an image needs more
+// than one tile. That is the same rule that tile.TileSeed uses to decide which
+// scale factors are excessive.
+
+func ScaleFactorsFromConfig(config *iiifconfig.Config, dims iiifimage.Dimensions) []int {
+
+	if len(config.Profile.Tiles.ScaleFactors) > 0 {
+		return config.Profile.Tiles.ScaleFactors
+	}
+
+	tw, th := TileSizeFromConfig(config)
+
+	w := dims.Width()
+	h := dims.Height()
+
+	scales := []int{1}
+
+	for sf := 2; sf*tw < w || sf*th < h; sf *= 2 {
+		scales = append(scales, sf)
+	}
+
+	return scales
+}
+
+func TilesFromConfig(config *iiifconfig.Config, image iiifimage.Image) ([]Tile, error) {
+
+	dims, err := image.Dimensions()
+
+	if err != nil {
+		return nil, err
+	}
+
+	w, h := TileSizeFromConfig(config)
+
+	tile := Tile{
+		Width:        w,
+		Height:       h,
+		ScaleFactors: ScaleFactorsFromConfig(config, dims),
+	}
+
+	return []Tile{tile}, nil
+}
+
+// SizesFromConfig returns the preferred (downscaled) renditions of an image,
+// where each value in the config file is the length of the longest side.
+
+func SizesFromConfig(config *iiifconfig.Config, image iiifimage.Image) ([]Size, error) {
+
+	sizes := make([]Size, 0)
+
+	if len(config.Profile.Sizes) == 0 {
+		return sizes, nil
+	}
+
+	dims, err := image.Dimensions()
+
+	if err != nil {
+		return nil, err
+	}
+
+	w := float64(dims.Width())
+	h := float64(dims.Height())
+
+	for _, max := range config.Profile.Sizes {
+
+		m := float64(max)
+
+		if max <= 0 || m >= math.Max(w, h) {
+			continue
+		}
+
+		sz := Size{}
+
+		if w >= h {
+			sz.Width = max
+			sz.Height = int(math.Max(1, math.Round(h*m/w)))
+		} else {
+			sz.Width = int(math.Max(1, math.Round(w*m/h)))
+			sz.Height = max
+		}
+
+		sizes = append(sizes, sz)
+	}
+
+	sort.Slice(sizes, func(i int, j int) bool {
+		return sizes[i].Width < sizes[j].Width
+	})
+
+	return sizes, nil
+}
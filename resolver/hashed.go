@@ -0,0 +1,101 @@
+package resolver
+
+// HashedResolver spreads images across nested directories named after the hex
+// digest of their identifier, so that "example.jpg" becomes something like
+// "d9/ea/example.jpg". The "hash" property is "sha1" (the default) or "md5",
+// "depth" is the number of directories (default 2) and "width" the number of
+// characters in each directory name (default 2).
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	"hash"
+	"strings"
+)
+
+type HashedResolver struct {
+	Resolver
+	hash  func() hash.Hash
+	depth int
+	width int
+}
+
+func init() {
+
+	Register("Hashed", func(config *iiifconfig.Config) (Resolver, error) {
+
+		r, err := NewHashedResolver(config)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return r, nil
+	})
+}
+
+func NewHashedResolver(config *iiifconfig.Config) (*HashedResolver, error) {
+
+	cfg := config.Images.Resolver
+
+	var hash_func func() hash.Hash
+	var size int
+
+	if cfg.Hash == "" || cfg.Hash == "sha1" {
+		hash_func = sha1.New
+		size = sha1.Size
+	} else if cfg.Hash == "md5" {
+		hash_func = md5.New
+		size = md5.Size
+	} else {
+		message := fmt.Sprintf("Unsupported hash '%s' for hashed resolver", cfg.Hash)
+		return nil, errors.New(message)
+	}
+
+	depth := cfg.Depth
+	width := cfg.Width
+
+	if depth == 0 {
+		depth = 2
+	}
+
+	if width == 0 {
+		width = 2
+	}
+
+	if depth < 0 || width < 0 || depth*width > size*2 {
+		message := fmt.Sprintf("Invalid depth (%d) or width (%d) for hashed resolver", depth, width)
+		return nil, errors.New(message)
+	}
+
+	r := HashedResolver{
+		hash:  hash_func,
+		depth: depth,
+		width: width,
+	}
+
+	return &r, nil
+}
+
+func (r *HashedResolver) Resolve(id string) (string, error) {
+
+	h := r.hash()
+	h.Write([]byte(id))
+
+	digest := hex.EncodeToString(h.Sum(nil))
+
+	parts := make([]string, 0)
+
+	for i := 0; i < r.depth; i++ {
+		offset := i * r.width
+		parts = append(parts, digest[offset:offset+r.width])
+	}
+
+	parts = append(parts, id)
+
+	return strings.Join(parts, "/"), nil
+}
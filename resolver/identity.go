@@ -0,0 +1,33 @@
+package resolver
+
+import (
+	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+)
+
+type IdentityResolver struct {
+	Resolver
+}
+
+func init() {
+
+	Register("Identity", func(config *iiifconfig.Config) (Resolver, error) {
+
+		r, err := NewIdentityResolver()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return r, nil
+	})
+}
+
+func NewIdentityResolver() (*IdentityResolver, error) {
+
+	r := IdentityResolver{}
+	return &r, nil
+}
+
+func (r *IdentityResolver) Resolve(id string) (string, error) {
+	return id, nil
+}
@@ -0,0 +1,165 @@
+package resolver
+
+// LookupResolver maps identifiers using a table that is read in to memory when
+// the resolver is created. The "path" property is either a JSON file containing
+// a single object whose keys are public identifiers and whose values are source
+// identifiers:
+//
+//	{
+//		"191733_5755a1309e4d66a7": "191733_5755a1309e4d66a7_k.jpg"
+//	}
+//
+// Or a CSV file with "alternate_id" and "source_id" columns, which is the same
+// format that iiif-tile-seed reads in "csv" mode so the file used to seed tiles
+// can also be used to serve them:
+//
+//	source_id,alternate_id
+//	191733_5755a1309e4d66a7_k.jpg,191733_5755a1309e4d66a7
+//
+// Identifiers that aren't in the table are passed through unchanged or, if the
+// "strict" property is true, treated as not found.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	iiiferrors "github.com/thisisaaronland/go-iiif/errors"
+	"github.com/whosonfirst/go-whosonfirst-csv"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+type LookupResolver struct {
+	Resolver
+	table  map[string]string
+	strict bool
+}
+
+func init() {
+
+	Register("Lookup", func(config *iiifconfig.Config) (Resolver, error) {
+
+		r, err := NewLookupResolver(config)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return r, nil
+	})
+}
+
+func NewLookupResolver(config *iiifconfig.Config) (*LookupResolver, error) {
+
+	cfg := config.Images.Resolver
+
+	if cfg.Path == "" {
+		return nil, errors.New("Lookup resolver has no path")
+	}
+
+	var table map[string]string
+	var err error
+
+	ext := strings.ToLower(filepath.Ext(cfg.Path))
+
+	if ext == ".json" {
+		table, err = readLookupJSON(cfg.Path)
+	} else if ext == ".csv" {
+		table, err = readLookupCSV(cfg.Path)
+	} else {
+		message := fmt.Sprintf("Lookup resolver path '%s' is neither a .json nor a .csv file", cfg.Path)
+		err = errors.New(message)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	r := LookupResolver{
+		table:  table,
+		strict: cfg.Strict,
+	}
+
+	return &r, nil
+}
+
+func (r *LookupResolver) Resolve(id string) (string, error) {
+
+	src_id, ok := r.table[id]
+
+	if ok {
+		return src_id, nil
+	}
+
+	if r.strict {
+		return "", iiiferrors.NewNotFoundError(id, nil)
+	}
+
+	return id, nil
+}
+
+func readLookupJSON(path string) (map[string]string, error) {
+
+	body, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	table := make(map[string]string)
+	err = json.Unmarshal(body, &table)
+
+	if err != nil {
+		message := fmt.Sprintf("Failed to parse lookup table '%s', %s", path, err)
+		return nil, errors.New(message)
+	}
+
+	return table, nil
+}
+
+func readLookupCSV(path string) (map[string]string, error) {
+
+	reader, err := csv.NewDictReaderFromPath(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	table := make(map[string]string)
+	line := 1
+
+	for {
+
+		row, err := reader.Read()
+		line += 1
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		src_id, ok := row["source_id"]
+
+		if !ok {
+			message := fmt.Sprintf("Lookup table '%s' is missing a source_id at line %d", path, line)
+			return nil, errors.New(message)
+		}
+
+		alt_id, ok := row["alternate_id"]
+
+		if !ok {
+			message := fmt.Sprintf("Lookup table '%s' is missing an alternate_id at line %d", path, line)
+			return nil, errors.New(message)
+		}
+
+		table[alt_id] = src_id
+	}
+
+	return table, nil
+}
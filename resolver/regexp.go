@@ -0,0 +1,99 @@
+package resolver
+
+// RegexpResolver rewrites identifiers using a list of regular expressions and
+// replacement strings, in the syntax that regexp.ReplaceAllString uses:
+//
+//	"resolver": {
+//		"name": "Regexp",
+//		"rules": [
+//			{ "match": "^([0-9]{3})([0-9]{3})_(.*)$", "replace": "${1}/${2}/${1}${2}_${3}" }
+//		]
+//	}
+//
+// The first rule that matches an identifier is the only one applied. If no
+// rule matches the identifier is passed through unchanged or, if the "strict"
+// property is true, it is treated as not found.
+
+import (
+	"errors"
+	"fmt"
+	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	iiiferrors "github.com/thisisaaronland/go-iiif/errors"
+	"regexp"
+)
+
+type RegexpResolver struct {
+	Resolver
+	rules  []*regexpRule
+	strict bool
+}
+
+type regexpRule struct {
+	match   *regexp.Regexp
+	replace string
+}
+
+func init() {
+
+	Register("Regexp", func(config *iiifconfig.Config) (Resolver, error) {
+
+		r, err := NewRegexpResolver(config)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return r, nil
+	})
+}
+
+func NewRegexpResolver(config *iiifconfig.Config) (*RegexpResolver, error) {
+
+	cfg := config.Images.Resolver
+
+	if len(cfg.Rules) == 0 {
+		return nil, errors.New("Regexp resolver has no rules")
+	}
+
+	rules := make([]*regexpRule, 0)
+
+	for i, rule_cfg := range cfg.Rules {
+
+		re, err := regexp.Compile(rule_cfg.Match)
+
+		if err != nil {
+			message := fmt.Sprintf("Invalid match for regexp resolver rule %d, %s", i, err)
+			return nil, errors.New(message)
+		}
+
+		rule := regexpRule{
+			match:   re,
+			replace: rule_cfg.Replace,
+		}
+
+		rules = append(rules, &rule)
+	}
+
+	r := RegexpResolver{
+		rules:  rules,
+		strict: cfg.Strict,
+	}
+
+	return &r, nil
+}
+
+func (r *RegexpResolver) Resolve(id string) (string, error) {
+
+	for _, rule := range r.rules {
+
+		if rule.match.MatchString(id) {
+			return rule.match.ReplaceAllString(id, rule.replace), nil
+		}
+	}
+
+	if r.strict {
+		return "", iiiferrors.NewNotFoundError(id, nil)
+	}
+
+	return id, nil
+}
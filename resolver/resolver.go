@@ -0,0 +1,89 @@
+package resolver
+
+// A Resolver maps the identifier in a IIIF request (the "public" identifier
+// that appears in URLs, info.json files and derivative cache keys) to the
+// identifier that the images source should read. The default "Identity"
+// resolver hands back the identifier unchanged, which is how go-iiif has
+// always worked. Others are chosen with the "images.resolver" config block:
+//
+//	"images": {
+//		"source": { "name": "Disk", "path": "/usr/local/images" },
+//		"resolver": { "name": "Template", "template": "{+id}.jpg" }
+//	}
+//
+// Resolvers are registered the same way sources are (see source/registry.go)
+// so third-party resolvers can be enabled with a blank import.
+
+import (
+	"errors"
+	"fmt"
+	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	"sort"
+	"sync"
+)
+
+type Resolver interface {
+	Resolve(id string) (string, error)
+}
+
+type ResolverInitializeFunc func(config *iiifconfig.Config) (Resolver, error)
+
+var resolvers_mu = new(sync.RWMutex)
+var resolvers = make(map[string]ResolverInitializeFunc)
+
+// Register makes a resolver available by name, as in the "images.resolver.name"
+// config property. Like sql.Register it panics if it's called twice with the
+// same name or with a nil function.
+
+func Register(name string, init_func ResolverInitializeFunc) {
+
+	resolvers_mu.Lock()
+	defer resolvers_mu.Unlock()
+
+	if init_func == nil {
+		panic("resolver: Register function is nil")
+	}
+
+	_, dupe := resolvers[name]
+
+	if dupe {
+		panic("resolver: Register called twice for resolver " + name)
+	}
+
+	resolvers[name] = init_func
+}
+
+func Resolvers() []string {
+
+	resolvers_mu.RLock()
+	defer resolvers_mu.RUnlock()
+
+	names := make([]string, 0)
+
+	for name, _ := range resolvers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+func NewResolverFromConfig(config *iiifconfig.Config) (Resolver, error) {
+
+	name := config.Images.Resolver.Name
+
+	if name == "" {
+		name = "Identity"
+	}
+
+	resolvers_mu.RLock()
+	init_func, ok := resolvers[name]
+	resolvers_mu.RUnlock()
+
+	if !ok {
+		message := fmt.Sprintf("Unknown resolver type '%s'", name)
+		return nil, errors.New(message)
+	}
+
+	return init_func(config)
+}
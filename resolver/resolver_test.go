@@ -0,0 +1,153 @@
+package resolver
+
+import (
+	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	iiiferrors "github.com/thisisaaronland/go-iiif/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolvers(t *testing.T) {
+
+	rules := []iiifconfig.ResolverRuleConfig{
+		{Match: "^([0-9]{3})([0-9]{3})_(.*)$", Replace: "${1}/${2}/${1}${2}_${3}"},
+		{Match: "^([0-9]{3})_(.*)$", Replace: "${1}/${2}"},
+	}
+
+	tests := []struct {
+		config   iiifconfig.ResolverConfig
+		id       string
+		expected string
+	}{
+		{iiifconfig.ResolverConfig{}, "a/b c.jpg", "a/b c.jpg"},
+		{iiifconfig.ResolverConfig{Name: "Template", Template: "{+id}.jpg"}, "a/b", "a/b.jpg"},
+		{iiifconfig.ResolverConfig{Name: "Template", Template: "{id}.jpg"}, "a/b", "a%2Fb.jpg"},
+		{iiifconfig.ResolverConfig{Name: "Template", Template: "{+dirname}/originals/{stem}.tif"}, "a/b/c.jpg", "a/b/originals/c.tif"},
+		{iiifconfig.ResolverConfig{Name: "Template", Template: "{basename}-{extension}"}, "c.jpg", "c.jpg-jpg"},
+		{iiifconfig.ResolverConfig{Name: "Regexp", Rules: rules}, "191733_k.jpg", "191/733/191733_k.jpg"},
+		{iiifconfig.ResolverConfig{Name: "Regexp", Rules: rules}, "191_k.jpg", "191/k.jpg"},
+		{iiifconfig.ResolverConfig{Name: "Regexp", Rules: rules}, "k.jpg", "k.jpg"},
+		{iiifconfig.ResolverConfig{Name: "Hashed"}, "example.jpg", "f3/b0/example.jpg"},
+		{iiifconfig.ResolverConfig{Name: "Hashed", Hash: "md5", Depth: 1, Width: 3}, "example.jpg", "76e/example.jpg"},
+	}
+
+	for _, test := range tests {
+
+		config := iiifconfig.Config{}
+		config.Images.Resolver = test.config
+
+		r, err := NewResolverFromConfig(&config)
+
+		if err != nil {
+			t.Fatalf("Failed to create resolver %+v, %s", test.config, err)
+		}
+
+		src_id, err := r.Resolve(test.id)
+
+		if err != nil {
+			t.Fatalf("Failed to resolve %s with %+v, %s", test.id, test.config, err)
+		}
+
+		if src_id != test.expected {
+			t.Fatalf("Expected %s to resolve to %s with %+v, got %s", test.id, test.expected, test.config, src_id)
+		}
+	}
+
+	invalid := []iiifconfig.ResolverConfig{
+		{Name: "Unknown"},
+		{Name: "Template"},
+		{Name: "Regexp"},
+		{Name: "Regexp", Rules: []iiifconfig.ResolverRuleConfig{{Match: "("}}},
+		{Name: "Hashed", Hash: "sha256"},
+		{Name: "Hashed", Depth: 21},
+		{Name: "Lookup"},
+		{Name: "Lookup", Path: "lookup.txt"},
+	}
+
+	for _, cfg := range invalid {
+
+		config := iiifconfig.Config{}
+		config.Images.Resolver = cfg
+
+		_, err := NewResolverFromConfig(&config)
+
+		if err == nil {
+			t.Fatalf("Expected resolver %+v to fail", cfg)
+		}
+	}
+}
+
+func TestLookupResolver(t *testing.T) {
+
+	root, err := ioutil.TempDir("", "iiif-resolver")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(root)
+
+	tables := map[string]string{
+		"lookup.json": `{"191733_5755a1309e4d66a7": "191733_5755a1309e4d66a7_k.jpg"}`,
+		"lookup.csv":  "source_id,alternate_id\n191733_5755a1309e4d66a7_k.jpg,191733_5755a1309e4d66a7\n",
+	}
+
+	for name, body := range tables {
+
+		path := filepath.Join(root, name)
+		err := ioutil.WriteFile(path, []byte(body), 0644)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		config := iiifconfig.Config{}
+		config.Images.Resolver = iiifconfig.ResolverConfig{Name: "Lookup", Path: path}
+
+		r, err := NewLookupResolver(&config)
+
+		if err != nil {
+			t.Fatalf("Failed to create resolver for %s, %s", name, err)
+		}
+
+		src_id, err := r.Resolve("191733_5755a1309e4d66a7")
+
+		if err != nil || src_id != "191733_5755a1309e4d66a7_k.jpg" {
+			t.Fatalf("Unexpected lookup in %s, %s %v", name, src_id, err)
+		}
+
+		src_id, err = r.Resolve("missing.jpg")
+
+		if err != nil || src_id != "missing.jpg" {
+			t.Fatalf("Expected missing identifiers to be passed through by %s, %s %v", name, src_id, err)
+		}
+
+		config.Images.Resolver.Strict = true
+
+		r, err = NewLookupResolver(&config)
+
+		if err != nil {
+			t.Fatalf("Failed to create resolver for %s, %s", name, err)
+		}
+
+		_, err = r.Resolve("missing.jpg")
+
+		if !iiiferrors.IsNotFound(err) {
+			t.Fatalf("Expected a NotFound error from strict resolver for %s, got %v", name, err)
+		}
+	}
+
+	path := filepath.Join(root, "broken.csv")
+	ioutil.WriteFile(path, []byte("id,alternate_id\na.jpg,a\n"), 0644)
+
+	config := iiifconfig.Config{}
+	config.Images.Resolver = iiifconfig.ResolverConfig{Name: "Lookup", Path: path}
+
+	_, err = NewLookupResolver(&config)
+
+	if err == nil {
+		t.Fatal("Expected a CSV file without a source_id column to fail")
+	}
+}
@@ -0,0 +1,86 @@
+package resolver
+
+// TemplateResolver expands a URI template (RFC 6570) for each identifier. The
+// following variables are available:
+//
+//	id         the identifier itself
+//	dirname    everything before the last "/" in the identifier, or ""
+//	basename   everything after the last "/" in the identifier
+//	stem       the basename without its extension
+//	extension  the basename's extension, without the leading "."
+//
+// Simple expressions like {id} percent-encode reserved characters, including
+// "/", so use {+id} for identifiers that contain slashes and need to be kept
+// as paths, for example "{+dirname}/originals/{stem}.tif".
+
+import (
+	"errors"
+	"github.com/jtacoma/uritemplates"
+	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	"path"
+	"strings"
+)
+
+type TemplateResolver struct {
+	Resolver
+	template *uritemplates.UriTemplate
+}
+
+func init() {
+
+	Register("Template", func(config *iiifconfig.Config) (Resolver, error) {
+
+		r, err := NewTemplateResolver(config)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return r, nil
+	})
+}
+
+func NewTemplateResolver(config *iiifconfig.Config) (*TemplateResolver, error) {
+
+	cfg := config.Images.Resolver
+
+	if cfg.Template == "" {
+		return nil, errors.New("Template resolver has no template")
+	}
+
+	template, err := uritemplates.Parse(cfg.Template)
+
+	if err != nil {
+		return nil, err
+	}
+
+	r := TemplateResolver{
+		template: template,
+	}
+
+	return &r, nil
+}
+
+func (r *TemplateResolver) Resolve(id string) (string, error) {
+
+	dirname := ""
+	basename := id
+
+	idx := strings.LastIndex(id, "/")
+
+	if idx != -1 {
+		dirname = id[0:idx]
+		basename = id[idx+1:]
+	}
+
+	ext := path.Ext(basename)
+
+	values := make(map[string]interface{})
+	values["id"] = id
+	values["dirname"] = dirname
+	values["basename"] = basename
+	values["stem"] = strings.TrimSuffix(basename, ext)
+	values["extension"] = strings.TrimPrefix(ext, ".")
+
+	return r.template.Expand(values)
+}
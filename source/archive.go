@@ -0,0 +1,503 @@
+package source
+
+// ArchiveSource reads images from inside ZIP and (uncompressed) TAR files. If
+// the "path" property is a directory then identifiers are the path of an archive,
+// relative to that directory, followed by "!" and the path of an image inside
+// the archive, for example:
+//
+//	batch-0042.zip!/scans/0001.tif
+//
+// If "path" is an archive then identifiers are just the path of an image inside
+// it. Images are read straight out of archives, without unpacking them: ZIP files
+// are read using their central directory and TAR files are indexed (once) the
+// first time they are opened. Open archives are kept in a cache shared by all of
+// the archive sources in a process, which holds up to "max_open" (default 64)
+// archives. Archives that change on disk are reopened.
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	iiiferrors "github.com/thisisaaronland/go-iiif/errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultMaxOpenArchives = 64
+
+type ArchiveSource struct {
+	StreamingSource
+	root    string
+	archive string
+}
+
+type archiveHandle struct {
+	path    string
+	size    int64
+	modtime time.Time
+	fh      *os.File
+	entries map[string]*archiveEntry
+	refs    int
+	evicted bool
+	el      *list.Element
+}
+
+type archiveEntry struct {
+	name     string
+	size     int64
+	modtime  time.Time
+	zip_file *zip.File
+	offset   int64
+}
+
+// archiveReader is an image being read from an archive, which keeps the archive
+// open until it is closed
+
+type archiveReader struct {
+	io.ReadCloser
+	handle *archiveHandle
+}
+
+var archives_mu = new(sync.Mutex)
+var archives = make(map[string]*archiveHandle)
+var archives_lru = list.New()
+var archives_max = defaultMaxOpenArchives
+
+func init() {
+
+	Register("Archive", func(config *iiifconfig.Config) (Source, error) {
+
+		src, err := NewArchiveSource(config)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return src, nil
+	})
+}
+
+func NewArchiveSource(config *iiifconfig.Config) (*ArchiveSource, error) {
+
+	cfg := config.Images.Source
+
+	if cfg.Path == "" {
+		return nil, errors.New("Archive source has no path")
+	}
+
+	info, err := os.Stat(cfg.Path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.MaxOpen > 0 {
+		archives_mu.Lock()
+		archives_max = cfg.MaxOpen
+		archives_mu.Unlock()
+	}
+
+	as := ArchiveSource{}
+
+	if info.IsDir() {
+		as.root = cfg.Path
+	} else {
+
+		if !isArchive(cfg.Path) {
+			message := fmt.Sprintf("Archive source path '%s' is neither a directory nor a .zip or .tar file", cfg.Path)
+			return nil, errors.New(message)
+		}
+
+		as.archive = cfg.Path
+	}
+
+	return &as, nil
+}
+
+func (as *ArchiveSource) Read(id string) ([]byte, error) {
+
+	return ReadAll(context.Background(), as, id)
+}
+
+func (as *ArchiveSource) Open(ctx context.Context, id string) (io.ReadCloser, *SourceInfo, error) {
+
+	err := ctx.Err()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	h, e, err := as.entry(id)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var fh io.ReadCloser
+
+	if e.zip_file != nil {
+		fh, err = e.zip_file.Open()
+	} else {
+		fh = ioutil.NopCloser(io.NewSectionReader(h.fh, e.offset, e.size))
+	}
+
+	if err != nil {
+		releaseArchive(h)
+		return nil, nil, err
+	}
+
+	r := archiveReader{
+		ReadCloser: fh,
+		handle:     h,
+	}
+
+	return &r, infoFromArchiveEntry(h, e), nil
+}
+
+func (as *ArchiveSource) Stat(ctx context.Context, id string) (*SourceInfo, error) {
+
+	err := ctx.Err()
+
+	if err != nil {
+		return nil, err
+	}
+
+	h, e, err := as.entry(id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer releaseArchive(h)
+
+	return infoFromArchiveEntry(h, e), nil
+}
+
+func (as *ArchiveSource) LastModified(id string) (time.Time, error) {
+
+	info, err := as.Stat(context.Background(), id)
+
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return info.ModTime, nil
+}
+
+// entry returns the (open) archive and the entry in it for id. It is the
+// caller's responsibility to release the archive.
+
+func (as *ArchiveSource) entry(id string) (*archiveHandle, *archiveEntry, error) {
+
+	archive_path, name, err := as.split(id)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	h, err := openArchive(archive_path)
+
+	if os.IsNotExist(err) {
+		return nil, nil, iiiferrors.NewNotFoundError(id, err)
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	e, ok := h.entries[name]
+
+	if !ok {
+		releaseArchive(h)
+		return nil, nil, iiiferrors.NewNotFoundError(id, nil)
+	}
+
+	return h, e, nil
+}
+
+// split returns the path of the archive and the name of the entry in it for
+// id, neither of which can point outside of the source's root
+
+func (as *ArchiveSource) split(id string) (string, string, error) {
+
+	if as.archive != "" {
+		return as.archive, cleanArchiveName(id), nil
+	}
+
+	idx := strings.Index(id, "!")
+
+	if idx == -1 {
+		message := fmt.Sprintf("Identifier %s does not include an archive", id)
+		return "", "", iiiferrors.NewNotFoundError(id, errors.New(message))
+	}
+
+	rel_path := filepath.Clean("/" + filepath.FromSlash(id[0:idx]))
+
+	if !isArchive(rel_path) {
+		message := fmt.Sprintf("Identifier %s does not include an archive", id)
+		return "", "", iiiferrors.NewNotFoundError(id, errors.New(message))
+	}
+
+	return filepath.Join(as.root, rel_path), cleanArchiveName(id[idx+1:]), nil
+}
+
+func (r *archiveReader) Close() error {
+
+	err := r.ReadCloser.Close()
+	releaseArchive(r.handle)
+
+	return err
+}
+
+// openArchive returns the handle for the archive at abs_path, opening and
+// indexing it if necessary, with its reference count incremented
+
+func openArchive(abs_path string) (*archiveHandle, error) {
+
+	info, err := os.Stat(abs_path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	archives_mu.Lock()
+
+	h, ok := archives[abs_path]
+
+	if ok && h.size == info.Size() && h.modtime.Equal(info.ModTime()) {
+		h.refs += 1
+		archives_lru.MoveToFront(h.el)
+		archives_mu.Unlock()
+		return h, nil
+	}
+
+	archives_mu.Unlock()
+
+	// indexing a big TAR file can take a while so it happens without
+	// holding the lock; if two requests race to open the same archive
+	// whichever one finishes last wins
+
+	h, err = indexArchive(abs_path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	archives_mu.Lock()
+	defer archives_mu.Unlock()
+
+	old, ok := archives[abs_path]
+
+	if ok {
+		evictArchive(old)
+	}
+
+	h.refs = 1
+	h.el = archives_lru.PushFront(h)
+	archives[abs_path] = h
+
+	for archives_lru.Len() > archives_max {
+
+		oldest := archives_lru.Back()
+
+		if oldest == nil || oldest == h.el {
+			break
+		}
+
+		evictArchive(oldest.Value.(*archiveHandle))
+	}
+
+	return h, nil
+}
+
+// evictArchive removes h from the cache and closes it, or arranges for it to
+// be closed once nothing is reading from it. It assumes archives_mu is held.
+
+func evictArchive(h *archiveHandle) {
+
+	if h.evicted {
+		return
+	}
+
+	h.evicted = true
+
+	archives_lru.Remove(h.el)
+
+	current, ok := archives[h.path]
+
+	if ok && current == h {
+		delete(archives, h.path)
+	}
+
+	if h.refs == 0 {
+		h.fh.Close()
+	}
+}
+
+func releaseArchive(h *archiveHandle) {
+
+	archives_mu.Lock()
+	defer archives_mu.Unlock()
+
+	h.refs -= 1
+
+	if h.refs == 0 && h.evicted {
+		h.fh.Close()
+	}
+}
+
+func indexArchive(abs_path string) (*archiveHandle, error) {
+
+	fh, err := os.Open(abs_path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := fh.Stat()
+
+	if err != nil {
+		fh.Close()
+		return nil, err
+	}
+
+	var entries map[string]*archiveEntry
+
+	if strings.ToLower(filepath.Ext(abs_path)) == ".zip" {
+		entries, err = indexZip(fh, info.Size())
+	} else {
+		entries, err = indexTar(fh)
+	}
+
+	if err != nil {
+		fh.Close()
+		message := fmt.Sprintf("Failed to index archive %s, %s", abs_path, err)
+		return nil, errors.New(message)
+	}
+
+	h := archiveHandle{
+		path:    abs_path,
+		size:    info.Size(),
+		modtime: info.ModTime(),
+		fh:      fh,
+		entries: entries,
+	}
+
+	return &h, nil
+}
+
+func indexZip(fh *os.File, size int64) (map[string]*archiveEntry, error) {
+
+	r, err := zip.NewReader(fh, size)
+
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(map[string]*archiveEntry)
+
+	for _, f := range r.File {
+
+		if f.FileInfo().IsDir() {
+			continue
+		}
+
+		e := archiveEntry{
+			name:     f.Name,
+			size:     int64(f.UncompressedSize64),
+			modtime:  f.Modified,
+			zip_file: f,
+		}
+
+		entries[cleanArchiveName(f.Name)] = &e
+	}
+
+	return entries, nil
+}
+
+// indexTar records the offset and size of each (regular) file in a TAR file,
+// which is only possible because the file isn't compressed. The tar reader
+// seeks past the contents of each file rather than reading them.
+
+func indexTar(fh *os.File) (map[string]*archiveEntry, error) {
+
+	r := tar.NewReader(fh)
+
+	entries := make(map[string]*archiveEntry)
+
+	for {
+
+		hdr, err := r.Next()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+
+		offset, err := fh.Seek(0, io.SeekCurrent)
+
+		if err != nil {
+			return nil, err
+		}
+
+		e := archiveEntry{
+			name:    hdr.Name,
+			size:    hdr.Size,
+			modtime: hdr.ModTime,
+			offset:  offset,
+		}
+
+		entries[cleanArchiveName(hdr.Name)] = &e
+	}
+
+	return entries, nil
+}
+
+func isArchive(abs_path string) bool {
+
+	ext := strings.ToLower(filepath.Ext(abs_path))
+	return ext == ".zip" || ext == ".tar"
+}
+
+func cleanArchiveName(name string) string {
+
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// the ETag is weak for the same reason it is for files on disk
+
+func infoFromArchiveEntry(h *archiveHandle, e *archiveEntry) *SourceInfo {
+
+	modtime := e.modtime
+
+	if modtime.IsZero() {
+		modtime = h.modtime
+	}
+
+	info := SourceInfo{
+		Size:        e.size,
+		ModTime:     modtime,
+		ETag:        fmt.Sprintf("W/\"%x-%x-%x\"", e.size, e.offset, h.modtime.UnixNano()),
+		ContentType: mime.TypeByExtension(path.Ext(e.name)),
+	}
+
+	return &info
+}
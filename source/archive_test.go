@@ -0,0 +1,262 @@
+package source
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	iiiferrors "github.com/thisisaaronland/go-iiif/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestArchiveSource(t *testing.T) {
+
+	root, err := ioutil.TempDir("", "iiif-archive")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(root)
+
+	modtime := time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	images := map[string]string{
+		"scans/0001.jpg": "zero zero zero one",
+		"scans/0002.png": "zero zero zero two",
+	}
+
+	zip_fh, err := os.Create(filepath.Join(root, "batch.zip"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zw := zip.NewWriter(zip_fh)
+
+	tar_fh, err := os.Create(filepath.Join(root, "batch.tar"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tw := tar.NewWriter(tar_fh)
+
+	for name, body := range images {
+
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modtime})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w.Write([]byte(body))
+
+		err = tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(body)), ModTime: modtime, Typeflag: tar.TypeReg})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tw.Write([]byte(body))
+	}
+
+	zw.Close()
+	zip_fh.Close()
+	tw.Close()
+	tar_fh.Close()
+
+	config := iiifconfig.Config{}
+	config.Images.Source = iiifconfig.SourceConfig{Name: "Archive", Path: root}
+
+	src, err := NewArchiveSource(&config)
+
+	if err != nil {
+		t.Fatalf("Failed to create archive source, %s", err)
+	}
+
+	ctx := context.Background()
+
+	for _, archive := range []string{"batch.zip", "batch.tar"} {
+
+		for name, body := range images {
+
+			id := archive + "!/" + name
+			data, err := src.Read(id)
+
+			if err != nil {
+				t.Fatalf("Failed to read %s, %s", id, err)
+			}
+
+			if string(data) != body {
+				t.Fatalf("Expected '%s' for %s, got '%s'", body, id, data)
+			}
+
+			info, err := src.Stat(ctx, id)
+
+			if err != nil {
+				t.Fatalf("Failed to stat %s, %s", id, err)
+			}
+
+			if info.Size != int64(len(body)) || !info.ModTime.Equal(modtime) {
+				t.Fatalf("Unexpected info for %s, %+v", id, info)
+			}
+		}
+	}
+
+	info, _ := src.Stat(ctx, "batch.tar!scans/0002.png")
+
+	if info == nil || info.ContentType != "image/png" {
+		t.Fatalf("Expected an image/png content type, got %+v", info)
+	}
+
+	// anything that isn't there is NotFound and identifiers are cleaned so
+	// that they can't escape the source's root or the archive
+
+	for _, id := range []string{"batch.zip!/scans/0003.jpg", "missing.zip!/scans/0001.jpg", "scans/0001.jpg"} {
+
+		_, err := src.Stat(ctx, id)
+
+		if !iiiferrors.IsNotFound(err) {
+			t.Fatalf("Expected a NotFound error for %s, got %v", id, err)
+		}
+	}
+
+	for _, id := range []string{"../batch.zip!/scans/0001.jpg", "batch.zip!/../../scans/0001.jpg"} {
+
+		_, err := src.Stat(ctx, id)
+
+		if err != nil {
+			t.Fatalf("Expected %s to be cleaned in to the source's root, %s", id, err)
+		}
+	}
+
+	// if "path" is an archive identifiers are paths inside it
+
+	config.Images.Source.Path = filepath.Join(root, "batch.zip")
+
+	src, err = NewArchiveSource(&config)
+
+	if err != nil {
+		t.Fatalf("Failed to create archive source, %s", err)
+	}
+
+	_, err = src.Stat(ctx, "scans/0001.jpg")
+
+	if err != nil {
+		t.Fatalf("Failed to stat image in archive, %s", err)
+	}
+
+	config.Images.Source.Path = filepath.Join(root, "batch.txt")
+	ioutil.WriteFile(config.Images.Source.Path, []byte("not an archive"), 0644)
+
+	_, err = NewArchiveSource(&config)
+
+	if err == nil {
+		t.Fatal("Expected a path that isn't a directory or an archive to fail")
+	}
+}
+
+func TestArchiveSourceEviction(t *testing.T) {
+
+	root, err := ioutil.TempDir("", "iiif-archive")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(root)
+
+	for _, name := range []string{"a.zip", "b.zip"} {
+
+		fh, err := os.Create(filepath.Join(root, name))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		zw := zip.NewWriter(fh)
+		w, _ := zw.Create("image.jpg")
+		w.Write([]byte(name))
+		zw.Close()
+		fh.Close()
+	}
+
+	archives_mu.Lock()
+	max := archives_max
+	archives_mu.Unlock()
+
+	defer func() {
+		archives_mu.Lock()
+		archives_max = max
+		archives_mu.Unlock()
+	}()
+
+	config := iiifconfig.Config{}
+	config.Images.Source = iiifconfig.SourceConfig{Name: "Archive", Path: root, MaxOpen: 1}
+
+	src, err := NewArchiveSource(&config)
+
+	if err != nil {
+		t.Fatalf("Failed to create archive source, %s", err)
+	}
+
+	ctx := context.Background()
+
+	fh, _, err := src.Open(ctx, "a.zip!image.jpg")
+
+	if err != nil {
+		t.Fatalf("Failed to open image, %s", err)
+	}
+
+	// opening a second archive evicts the first but it isn't closed until
+	// nothing is reading from it
+
+	data, err := src.Read("b.zip!image.jpg")
+
+	if err != nil || string(data) != "b.zip" {
+		t.Fatalf("Failed to read image, %s", err)
+	}
+
+	data, err = ioutil.ReadAll(fh)
+
+	if err != nil || string(data) != "a.zip" {
+		t.Fatalf("Failed to read image from evicted archive, %v", err)
+	}
+
+	fh.Close()
+
+	archives_mu.Lock()
+	_, ok := archives[filepath.Join(root, "a.zip")]
+	archives_mu.Unlock()
+
+	if ok {
+		t.Fatal("Expected a.zip to have been evicted")
+	}
+
+	// archives that change on disk are reopened
+
+	time.Sleep(10 * time.Millisecond)
+
+	fh2, err := os.Create(filepath.Join(root, "b.zip"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zw := zip.NewWriter(fh2)
+	w, _ := zw.Create("image.jpg")
+	w.Write([]byte("b.zip, again"))
+	zw.Close()
+	fh2.Close()
+
+	data, err = src.Read("b.zip!image.jpg")
+
+	if err != nil || string(data) != "b.zip, again" {
+		t.Fatalf("Expected the changed archive to be reopened, got '%s' %v", data, err)
+	}
+}
@@ -0,0 +1,170 @@
+package source
+
+// uriAuth is the set of headers that a URI source sends with every request: the
+// static "headers" from its config block plus, if its "auth" block says so, an
+// Authorization header using either basic auth or a (bearer) token. Tokens can
+// be read from the config file itself, an environment variable or a file. Token
+// files are read again whenever they change so that they can be rotated without
+// restarting anything.
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type uriAuth struct {
+	headers       http.Header
+	authorization string
+	token_file    string
+	token_scheme  string
+	mu            *sync.Mutex
+	file_token    string
+	file_modtime  time.Time
+}
+
+func newURIAuth(cfg iiifconfig.SourceConfig) (*uriAuth, error) {
+
+	headers := make(http.Header)
+
+	for k, v := range cfg.Headers {
+		headers.Set(k, v)
+	}
+
+	auth := cfg.Auth
+
+	token_scheme := auth.TokenScheme
+
+	if token_scheme == "" {
+		token_scheme = "Bearer"
+	}
+
+	a := uriAuth{
+		headers:      headers,
+		token_file:   auth.TokenFile,
+		token_scheme: token_scheme,
+		mu:           new(sync.Mutex),
+	}
+
+	has_token := auth.Token != "" || auth.TokenEnv != "" || auth.TokenFile != ""
+
+	if auth.Username != "" && has_token {
+		return nil, errors.New("URI source auth can use basic auth or a token, but not both")
+	}
+
+	if auth.Username != "" {
+
+		password := auth.Password
+
+		if auth.PasswordEnv != "" {
+
+			password = os.Getenv(auth.PasswordEnv)
+
+			if password == "" {
+				message := fmt.Sprintf("URI source password environment variable '%s' is empty", auth.PasswordEnv)
+				return nil, errors.New(message)
+			}
+		}
+
+		creds := fmt.Sprintf("%s:%s", auth.Username, password)
+		a.authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(creds))
+
+	} else if auth.TokenFile != "" {
+
+		_, err := a.readTokenFile()
+
+		if err != nil {
+			return nil, err
+		}
+
+	} else if auth.TokenEnv != "" {
+
+		token := os.Getenv(auth.TokenEnv)
+
+		if token == "" {
+			message := fmt.Sprintf("URI source token environment variable '%s' is empty", auth.TokenEnv)
+			return nil, errors.New(message)
+		}
+
+		a.authorization = token_scheme + " " + token
+
+	} else if auth.Token != "" {
+		a.authorization = token_scheme + " " + auth.Token
+	}
+
+	return &a, nil
+}
+
+// header returns a copy of the headers to send with a request, which the caller
+// is free to add to
+
+func (a *uriAuth) header() (http.Header, error) {
+
+	header := make(http.Header)
+
+	for k, v := range a.headers {
+		header[k] = v
+	}
+
+	authorization := a.authorization
+
+	if a.token_file != "" {
+
+		token, err := a.readTokenFile()
+
+		if err != nil {
+			return nil, err
+		}
+
+		authorization = a.token_scheme + " " + token
+	}
+
+	if authorization != "" {
+		header.Set("Authorization", authorization)
+	}
+
+	return header, nil
+}
+
+func (a *uriAuth) readTokenFile() (string, error) {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	info, err := os.Stat(a.token_file)
+
+	if err != nil {
+		message := fmt.Sprintf("Failed to read URI source token file, %s", err)
+		return "", errors.New(message)
+	}
+
+	if a.file_token != "" && info.ModTime().Equal(a.file_modtime) {
+		return a.file_token, nil
+	}
+
+	body, err := ioutil.ReadFile(a.token_file)
+
+	if err != nil {
+		message := fmt.Sprintf("Failed to read URI source token file, %s", err)
+		return "", errors.New(message)
+	}
+
+	token := strings.TrimSpace(string(body))
+
+	if token == "" {
+		message := fmt.Sprintf("URI source token file '%s' is empty", a.token_file)
+		return "", errors.New(message)
+	}
+
+	a.file_token = token
+	a.file_modtime = info.ModTime()
+
+	return token, nil
+}
@@ -0,0 +1,137 @@
+package source
+
+// BlobSource reads images from any bucket that the blob package knows how to
+// open, named by the "uri" property:
+//
+//	"source": { "name": "Blob", "uri": "s3://your-bucket/images?region=us-east-1" }
+//
+// Buckets are opened once and shared by all of the blob sources in a process
+// with the same URI.
+
+import (
+	"context"
+	"errors"
+	iiifblob "github.com/thisisaaronland/go-iiif/blob"
+	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	"io"
+	"mime"
+	"path"
+	"sync"
+	"time"
+)
+
+type BlobSource struct {
+	StreamingSource
+	bucket iiifblob.Bucket
+}
+
+var blob_buckets_mu = new(sync.Mutex)
+var blob_buckets = make(map[string]iiifblob.Bucket)
+
+func init() {
+
+	Register("Blob", func(config *iiifconfig.Config) (Source, error) {
+
+		src, err := NewBlobSource(config)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return src, nil
+	})
+}
+
+func NewBlobSource(config *iiifconfig.Config) (*BlobSource, error) {
+
+	uri := config.Images.Source.URI
+
+	if uri == "" {
+		return nil, errors.New("Blob source has no URI")
+	}
+
+	blob_buckets_mu.Lock()
+	defer blob_buckets_mu.Unlock()
+
+	bucket, ok := blob_buckets[uri]
+
+	if !ok {
+
+		b, err := iiifblob.OpenBucket(context.Background(), uri)
+
+		if err != nil {
+			return nil, err
+		}
+
+		bucket = b
+		blob_buckets[uri] = bucket
+	}
+
+	bs := BlobSource{
+		bucket: bucket,
+	}
+
+	return &bs, nil
+}
+
+func (bs *BlobSource) Read(id string) ([]byte, error) {
+
+	return ReadAll(context.Background(), bs, id)
+}
+
+func (bs *BlobSource) Open(ctx context.Context, id string) (io.ReadCloser, *SourceInfo, error) {
+
+	fh, attrs, err := bs.bucket.NewReader(ctx, id)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return fh, infoFromAttributes(id, attrs), nil
+}
+
+func (bs *BlobSource) Stat(ctx context.Context, id string) (*SourceInfo, error) {
+
+	attrs, err := bs.bucket.Attributes(ctx, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return infoFromAttributes(id, attrs), nil
+}
+
+// LastModified only knows when images in local (file:// and mem://) buckets
+// were last modified, since asking S3 for every request isn't cheap. Stat will
+// ask any bucket.
+
+func (bs *BlobSource) LastModified(id string) (time.Time, error) {
+
+	if !iiifblob.IsLocal(bs.bucket) {
+		return time.Time{}, errors.New("Last modified time is unknown for remote buckets")
+	}
+
+	attrs, err := bs.bucket.Attributes(context.Background(), id)
+
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return attrs.ModTime, nil
+}
+
+func infoFromAttributes(id string, attrs *iiifblob.Attributes) *SourceInfo {
+
+	info := SourceInfo{
+		Size:        attrs.Size,
+		ModTime:     attrs.ModTime,
+		ETag:        attrs.ETag,
+		ContentType: attrs.ContentType,
+	}
+
+	if info.ContentType == "" {
+		info.ContentType = mime.TypeByExtension(path.Ext(id))
+	}
+
+	return &info
+}
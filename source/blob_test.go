@@ -0,0 +1,134 @@
+package source
+
+import (
+	"context"
+	iiifblob "github.com/thisisaaronland/go-iiif/blob"
+	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	iiiferrors "github.com/thisisaaronland/go-iiif/errors"
+	"io/ioutil"
+	"testing"
+)
+
+func TestBlobSource(t *testing.T) {
+
+	ctx := context.Background()
+
+	bucket, err := iiifblob.OpenBucket(ctx, "mem://source-test")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := iiifblob.WriterOptions{
+		ContentType: "image/tiff",
+	}
+
+	err = bucket.WriteAll(ctx, "images/test.jpg", []byte("hello"), &opts)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = bucket.WriteAll(ctx, "images/test.png", []byte("hello"), nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := iiifconfig.Config{}
+
+	config.Images.Source = iiifconfig.SourceConfig{
+		Name: "Blob",
+		URI:  "mem://source-test",
+	}
+
+	src, err := NewBlobSource(&config)
+
+	if err != nil {
+		t.Fatalf("Failed to create Blob source, %s", err)
+	}
+
+	fh, info, err := src.Open(ctx, "images/test.jpg")
+
+	if err != nil {
+		t.Fatalf("Failed to open image, %s", err)
+	}
+
+	body, err := ioutil.ReadAll(fh)
+	fh.Close()
+
+	if err != nil || string(body) != "hello" {
+		t.Fatalf("Expected 'hello', got '%s' and %v", body, err)
+	}
+
+	// the stored content type wins over the extension
+
+	if info.Size != 5 || info.ContentType != "image/tiff" || info.ModTime.IsZero() {
+		t.Fatalf("Unexpected info %+v", info)
+	}
+
+	// but the extension is used when there isn't one
+
+	info, err = src.Stat(ctx, "images/test.png")
+
+	if err != nil {
+		t.Fatalf("Failed to stat image, %s", err)
+	}
+
+	if info.ContentType != "image/png" {
+		t.Fatalf("Expected content type to be inferred from the extension, got '%s'", info.ContentType)
+	}
+
+	modtime, err := src.LastModified("images/test.png")
+
+	if err != nil || !modtime.Equal(info.ModTime) {
+		t.Fatalf("Expected last modified time %s, got %s and %v", info.ModTime, modtime, err)
+	}
+
+	// ids are cleaned by the bucket, so can't climb out of it
+
+	body, err = src.Read("../images/../images/test.jpg")
+
+	if err != nil || string(body) != "hello" {
+		t.Fatalf("Expected a cleaned id to be read, got '%s' and %v", body, err)
+	}
+
+	_, _, err = src.Open(ctx, "missing.jpg")
+
+	if !iiiferrors.IsNotFound(err) {
+		t.Fatalf("Expected a NotFound error, got %v", err)
+	}
+
+	_, err = src.LastModified("missing.jpg")
+
+	if !iiiferrors.IsNotFound(err) {
+		t.Fatalf("Expected a NotFound error, got %v", err)
+	}
+
+	// asking a remote bucket when an image was last modified isn't cheap,
+	// so only Stat does
+
+	remote := BlobSource{
+		bucket: struct{ iiifblob.Bucket }{bucket},
+	}
+
+	_, err = remote.LastModified("images/test.jpg")
+
+	if err == nil {
+		t.Fatal("Expected LastModified to fail for a remote bucket")
+	}
+
+	_, err = remote.Stat(ctx, "images/test.jpg")
+
+	if err != nil {
+		t.Fatalf("Expected Stat to work for a remote bucket, %s", err)
+	}
+
+	config.Images.Source.URI = ""
+
+	_, err = NewBlobSource(&config)
+
+	if err == nil {
+		t.Fatal("Expected a Blob source without a URI to fail")
+	}
+}
@@ -0,0 +1,360 @@
+package source
+
+// CompositeSource reads images from a number of named "child" sources, each of
+// which is an ordinary source config, choosing between them according to the
+// identifier being read:
+//
+//	"source": {
+//		"name": "Composite",
+//		"sources": {
+//			"local": { "name": "Disk", "path": "/usr/local/images" },
+//			"archive": { "name": "S3", "path": "your.S3.bucket", "region": "us-east-1" },
+//			"legacy": { "name": "URI", "path": "https://images.example.com/{id}" }
+//		},
+//		"routes": [
+//			{ "prefix": "archive:", "sources": [ "archive" ], "strip_prefix": true },
+//			{ "match": "^[0-9]+_[0-9a-f]+_[a-z]\\.jpg$", "sources": [ "local", "legacy" ] }
+//		],
+//		"fallback": [ "local", "archive" ]
+//	}
+//
+// The first route whose prefix or regular expression matches an identifier
+// decides which sources are tried, in order; identifiers that don't match any
+// route use the fallback sources. The next source in the list is only tried
+// if the one before it says the image doesn't exist. Any other error (like an
+// upstream being unavailable) is returned straight away.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	iiiferrors "github.com/thisisaaronland/go-iiif/errors"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type CompositeSource struct {
+	StreamingSource
+	sources  map[string]StreamingSource
+	routes   []*compositeRoute
+	fallback []string
+}
+
+type compositeRoute struct {
+	prefix       string
+	match        *regexp.Regexp
+	sources      []string
+	strip_prefix bool
+}
+
+func init() {
+
+	Register("Composite", func(config *iiifconfig.Config) (Source, error) {
+
+		src, err := NewCompositeSource(config)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return src, nil
+	})
+}
+
+func NewCompositeSource(config *iiifconfig.Config) (*CompositeSource, error) {
+
+	cfg := config.Images.Source
+
+	if len(cfg.Sources) == 0 {
+		return nil, errors.New("Composite source has no sources")
+	}
+
+	sources := make(map[string]StreamingSource)
+
+	for name, child_cfg := range cfg.Sources {
+
+		// sources are created from a whole config, not just their own
+		// block, so each one gets a copy with its block swapped in
+
+		child_config := *config
+		child_config.Images.Source = child_cfg
+
+		src, err := NewSourceFromConfig(&child_config)
+
+		if err != nil {
+			message := fmt.Sprintf("Failed to create composite source '%s', %s", name, err)
+			return nil, errors.New(message)
+		}
+
+		sources[name] = NewStreamingSource(src)
+	}
+
+	check_names := func(names []string) error {
+
+		for _, name := range names {
+
+			_, ok := sources[name]
+
+			if !ok {
+				message := fmt.Sprintf("Unknown composite source '%s'", name)
+				return errors.New(message)
+			}
+		}
+
+		return nil
+	}
+
+	routes := make([]*compositeRoute, 0)
+
+	for i, route_cfg := range cfg.Routes {
+
+		if route_cfg.Prefix == "" && route_cfg.Match == "" {
+			message := fmt.Sprintf("Composite source route %d has neither a prefix nor a match", i)
+			return nil, errors.New(message)
+		}
+
+		if len(route_cfg.Sources) == 0 {
+			message := fmt.Sprintf("Composite source route %d has no sources", i)
+			return nil, errors.New(message)
+		}
+
+		err := check_names(route_cfg.Sources)
+
+		if err != nil {
+			return nil, err
+		}
+
+		r := compositeRoute{
+			prefix:       route_cfg.Prefix,
+			sources:      route_cfg.Sources,
+			strip_prefix: route_cfg.StripPrefix,
+		}
+
+		if route_cfg.Match != "" {
+
+			re, err := regexp.Compile(route_cfg.Match)
+
+			if err != nil {
+				message := fmt.Sprintf("Invalid match for composite source route %d, %s", i, err)
+				return nil, errors.New(message)
+			}
+
+			r.match = re
+		}
+
+		routes = append(routes, &r)
+	}
+
+	err := check_names(cfg.Fallback)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c := CompositeSource{
+		sources:  sources,
+		routes:   routes,
+		fallback: cfg.Fallback,
+	}
+
+	return &c, nil
+}
+
+func (c *CompositeSource) Read(id string) ([]byte, error) {
+
+	return ReadAll(context.Background(), c, id)
+}
+
+func (c *CompositeSource) Open(ctx context.Context, id string) (io.ReadCloser, *SourceInfo, error) {
+
+	var fh io.ReadCloser
+	var info *SourceInfo
+
+	err := c.try(ctx, id, func(src StreamingSource, child_id string) error {
+
+		var err error
+		fh, info, err = src.Open(ctx, child_id)
+
+		return err
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return fh, info, nil
+}
+
+func (c *CompositeSource) Stat(ctx context.Context, id string) (*SourceInfo, error) {
+
+	var info *SourceInfo
+
+	err := c.try(ctx, id, func(src StreamingSource, child_id string) error {
+
+		var err error
+		info, err = src.Stat(ctx, child_id)
+
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// OpenIfChanged asks the sources for id, in order, whether it has changed.
+// Sources that can't make conditional requests simply open the image.
+
+func (c *CompositeSource) OpenIfChanged(ctx context.Context, id string, info *SourceInfo) (io.ReadCloser, *SourceInfo, error) {
+
+	var fh io.ReadCloser
+	var new_info *SourceInfo
+
+	err := c.try(ctx, id, func(src StreamingSource, child_id string) error {
+
+		var err error
+
+		cs, ok := unwrapSource(src).(ConditionalSource)
+
+		if ok {
+			fh, new_info, err = cs.OpenIfChanged(ctx, child_id, info)
+		} else {
+			fh, new_info, err = src.Open(ctx, child_id)
+		}
+
+		return err
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return fh, new_info, nil
+}
+
+// Conditional reports whether all the sources that will be tried for id can
+// make conditional requests, which is what decides whether an image read
+// from a composite source is revalidated later.
+
+func (c *CompositeSource) Conditional(id string) bool {
+
+	names, _ := c.Route(id)
+
+	if len(names) == 0 {
+		return false
+	}
+
+	for _, name := range names {
+
+		_, ok := unwrapSource(c.sources[name]).(ConditionalSource)
+
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// LastModified asks the sources for id, in order, when it was last changed.
+// Only sources that can answer that cheaply (see LastModifiedSource) are
+// asked. If a source that can't is reached before one that has the image then
+// the time is unknown, rather than reading (or making a HEAD request for) the
+// image to find out.
+
+func (c *CompositeSource) LastModified(id string) (time.Time, error) {
+
+	var t time.Time
+
+	err := c.try(context.Background(), id, func(src StreamingSource, child_id string) error {
+
+		lm, ok := unwrapSource(src).(LastModifiedSource)
+
+		if !ok {
+			return errors.New("Last modified time is unknown")
+		}
+
+		var err error
+		t, err = lm.LastModified(child_id)
+
+		return err
+	})
+
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return t, nil
+}
+
+// Route returns the names of the sources that will be tried for id, in order,
+// and the identifier that they will be asked for.
+
+func (c *CompositeSource) Route(id string) ([]string, string) {
+
+	for _, r := range c.routes {
+
+		if r.prefix != "" && strings.HasPrefix(id, r.prefix) {
+
+			if r.strip_prefix {
+				return r.sources, strings.TrimPrefix(id, r.prefix)
+			}
+
+			return r.sources, id
+		}
+
+		if r.match != nil && r.match.MatchString(id) {
+			return r.sources, id
+		}
+	}
+
+	return c.fallback, id
+}
+
+func (c *CompositeSource) try(ctx context.Context, id string, fn func(StreamingSource, string) error) error {
+
+	names, child_id := c.Route(id)
+
+	if len(names) == 0 {
+		message := fmt.Sprintf("No composite source for '%s'", id)
+		return iiiferrors.NewNotFoundError(id, errors.New(message))
+	}
+
+	var last_err error
+
+	for _, name := range names {
+
+		err := fn(c.sources[name], child_id)
+
+		if err == nil {
+			return nil
+		}
+
+		if !iiiferrors.IsNotFound(err) {
+			return err
+		}
+
+		last_err = err
+	}
+
+	return last_err
+}
+
+// unwrapSource returns the source that NewStreamingSource wrapped, if it did,
+// so that it can be checked for the optional source interfaces
+
+func unwrapSource(src StreamingSource) Source {
+
+	ls, ok := src.(*legacySource)
+
+	if ok {
+		return ls.source
+	}
+
+	return src
+}
@@ -0,0 +1,153 @@
+package source
+
+import (
+	"context"
+	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	iiiferrors "github.com/thisisaaronland/go-iiif/errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCompositeSource(t *testing.T) {
+
+	var requests int32
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		atomic.AddInt32(&requests, 1)
+
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("remote"))
+	}))
+
+	defer ts.Close()
+
+	root, err := ioutil.TempDir("", "iiif-composite")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(root)
+
+	local_path := filepath.Join(root, "local.jpg")
+	modtime := time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	ioutil.WriteFile(local_path, []byte("local"), 0644)
+	err = os.Chtimes(local_path, modtime, modtime)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := iiifconfig.Config{}
+
+	config.Images.Source = iiifconfig.SourceConfig{
+		Name: "Composite",
+		Sources: map[string]iiifconfig.SourceConfig{
+			"local":  {Name: "Disk", Path: root},
+			"remote": {Name: "URI", Path: ts.URL + "/{id}"},
+		},
+		Routes: []iiifconfig.SourceRouteConfig{
+			{Prefix: "remote:", Sources: []string{"remote"}, StripPrefix: true},
+			{Prefix: "both:", Sources: []string{"local", "remote"}, StripPrefix: true},
+		},
+		Fallback: []string{"local"},
+	}
+
+	src, err := NewCompositeSource(&config)
+
+	if err != nil {
+		t.Fatalf("Failed to create composite source, %s", err)
+	}
+
+	// a local image is found before the remote source is reached
+
+	for _, id := range []string{"local.jpg", "both:local.jpg"} {
+
+		lm, err := src.LastModified(id)
+
+		if err != nil || !lm.Equal(modtime) {
+			t.Fatalf("Expected %s for %s, got %s %v", modtime, id, lm, err)
+		}
+	}
+
+	_, err = src.LastModified("missing.jpg")
+
+	if !iiiferrors.IsNotFound(err) {
+		t.Fatalf("Expected a NotFound error, got %v", err)
+	}
+
+	// URI sources can't say when an image changed without a request, so
+	// they aren't asked
+
+	for _, id := range []string{"remote:remote.jpg", "both:remote.jpg"} {
+
+		_, err := src.LastModified(id)
+
+		if err == nil {
+			t.Fatalf("Expected the last modified time of %s to be unknown", id)
+		}
+	}
+
+	if n := atomic.LoadInt32(&requests); n != 0 {
+		t.Fatalf("Expected no requests to the remote source, got %d", n)
+	}
+
+	// only images that can only come from the remote source are conditional
+
+	if !IsConditional(src, "remote:remote.jpg") {
+		t.Fatal("Expected images from the remote source to be conditional")
+	}
+
+	if IsConditional(src, "local.jpg") || IsConditional(src, "both:local.jpg") {
+		t.Fatal("Expected images that might come from the local source not to be conditional")
+	}
+
+	ctx := context.Background()
+
+	info := SourceInfo{
+		ETag: `"v1"`,
+	}
+
+	_, _, err = src.OpenIfChanged(ctx, "remote:remote.jpg", &info)
+
+	if err != ErrNotModified {
+		t.Fatalf("Expected ErrNotModified, got %v", err)
+	}
+
+	info.ETag = `"v0"`
+
+	tests := map[string]string{
+		"remote:remote.jpg": "remote",
+		"local.jpg":         "local",
+	}
+
+	for id, expected := range tests {
+
+		fh, _, err := src.OpenIfChanged(ctx, id, &info)
+
+		if err != nil {
+			t.Fatalf("Failed to open %s, %s", id, err)
+		}
+
+		body, err := ioutil.ReadAll(fh)
+		fh.Close()
+
+		if err != nil || string(body) != expected {
+			t.Fatalf("Expected '%s' for %s, got '%s' %v", expected, id, body, err)
+		}
+	}
+}
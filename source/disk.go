@@ -1,10 +1,16 @@
 package source
 
 import (
+	"context"
+	"fmt"
 	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	iiiferrors "github.com/thisisaaronland/go-iiif/errors"
+	"io"
 	"io/ioutil"
+	"mime"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 type DiskSource struct {
@@ -12,6 +18,20 @@ type DiskSource struct {
 	root string
 }
 
+func init() {
+
+	Register("Disk", func(config *iiifconfig.Config) (Source, error) {
+
+		src, err := NewDiskSource(config)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return src, nil
+	})
+}
+
 func NewDiskSource(config *iiifconfig.Config) (*DiskSource, error) {
 
 	cfg := config.Images
@@ -30,7 +50,7 @@ func (ds *DiskSource) Read(uri string) ([]byte, error) {
 	_, err := os.Stat(abs_path)
 
 	if os.IsNotExist(err) {
-		return nil, err
+		return nil, iiiferrors.NewNotFoundError(uri, err)
 	}
 
 	body, err := ioutil.ReadFile(abs_path)
@@ -41,3 +61,88 @@ func (ds *DiskSource) Read(uri string) ([]byte, error) {
 
 	return body, nil
 }
+
+func (ds *DiskSource) LastModified(uri string) (time.Time, error) {
+
+	abs_path := filepath.Join(ds.root, uri)
+
+	info, err := os.Stat(abs_path)
+
+	if os.IsNotExist(err) {
+		return time.Time{}, iiiferrors.NewNotFoundError(uri, err)
+	}
+
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return info.ModTime(), nil
+}
+
+func (ds *DiskSource) Open(ctx context.Context, uri string) (io.ReadCloser, *SourceInfo, error) {
+
+	err := ctx.Err()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	abs_path := filepath.Join(ds.root, uri)
+
+	fh, err := os.Open(abs_path)
+
+	if os.IsNotExist(err) {
+		return nil, nil, iiiferrors.NewNotFoundError(uri, err)
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fi, err := fh.Stat()
+
+	if err != nil {
+		fh.Close()
+		return nil, nil, err
+	}
+
+	return fh, infoFromFileInfo(fi), nil
+}
+
+func (ds *DiskSource) Stat(ctx context.Context, uri string) (*SourceInfo, error) {
+
+	err := ctx.Err()
+
+	if err != nil {
+		return nil, err
+	}
+
+	abs_path := filepath.Join(ds.root, uri)
+
+	fi, err := os.Stat(abs_path)
+
+	if os.IsNotExist(err) {
+		return nil, iiiferrors.NewNotFoundError(uri, err)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return infoFromFileInfo(fi), nil
+}
+
+// the ETag is weak because it's derived from the size and modification time
+// rather than the contents of the file
+
+func infoFromFileInfo(fi os.FileInfo) *SourceInfo {
+
+	info := SourceInfo{
+		Size:        fi.Size(),
+		ModTime:     fi.ModTime(),
+		ETag:        fmt.Sprintf("W/\"%x-%x\"", fi.Size(), fi.ModTime().UnixNano()),
+		ContentType: mime.TypeByExtension(filepath.Ext(fi.Name())),
+	}
+
+	return &info
+}
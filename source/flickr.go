@@ -1,25 +1,43 @@
 package source
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	iiifcache "github.com/thisisaaronland/go-iiif/cache"
 	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	iiiferrors "github.com/thisisaaronland/go-iiif/errors"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"sync"
 )
 
+// the getSizes API response is a few kilobytes at most
+
+const maxFlickrResponse = 1024 * 1024
+
 type FlickrSource struct {
 	Source
 	apikey    string
 	apisecret string
-	client    *http.Client
+	fetcher   *httpFetcher
 	cache     iiifcache.Cache
 }
 
+// the cache of photo IDs to image URLs is shared by all the Flickr sources in a
+// process since sources are created for every image that is read
+
+var flickr_cache_mu = new(sync.Mutex)
+var flickr_cache iiifcache.Cache
+
 type PhotoRsp struct {
-	Sizes PhotoSizes `json:"sizes"`
+	Sizes   PhotoSizes `json:"sizes"`
+	Stat    string     `json:"stat"`
+	Code    int        `json:"code,omitempty"`
+	Message string     `json:"message,omitempty"`
 }
 
 type PhotoSizes struct {
@@ -41,28 +59,35 @@ type PhotoSize struct {
 	Media  string `json:"media"`
 }
 
-func NewFlickrSource(config *iiifconfig.Config) (*FlickrSource, error) {
+func init() {
 
-	cache_config := iiifconfig.CacheConfig{
-		TTL:   3600,
-		Limit: 1,
-	}
+	Register("Flickr", func(config *iiifconfig.Config) (Source, error) {
 
-	cache, err := iiifcache.NewMemoryCache(cache_config)
+		src, err := NewFlickrSource(config)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return src, nil
+	})
+}
+
+func NewFlickrSource(config *iiifconfig.Config) (*FlickrSource, error) {
+
+	cache, err := flickrCache()
 
 	if err != nil {
 		return nil, err
 	}
 
-	client := &http.Client{}
-
 	apikey := config.Flickr.ApiKey
 	apisecret := config.Flickr.ApiSecret
 
 	fs := FlickrSource{
 		apikey:    apikey,
 		apisecret: apisecret,
-		client:    client,
+		fetcher:   newHTTPFetcher(config),
 		cache:     cache,
 	}
 
@@ -71,37 +96,65 @@ func NewFlickrSource(config *iiifconfig.Config) (*FlickrSource, error) {
 
 func (fs *FlickrSource) Read(id string) ([]byte, error) {
 
-	source, err := fs.GetSource(id)
+	return ReadAll(context.Background(), fs, id)
+}
+
+func (fs *FlickrSource) Open(ctx context.Context, id string) (io.ReadCloser, *SourceInfo, error) {
+
+	rsp, err := fs.do(ctx, "GET", id, nil)
 
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	req, err := http.NewRequest("GET", source, nil)
+	return rsp.Body, infoFromResponse(rsp), nil
+}
+
+func (fs *FlickrSource) OpenIfChanged(ctx context.Context, id string, info *SourceInfo) (io.ReadCloser, *SourceInfo, error) {
+
+	rsp, err := fs.do(ctx, "GET", id, conditionalHeader(info))
 
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	if rsp.StatusCode == http.StatusNotModified {
+		return nil, nil, ErrNotModified
 	}
 
-	rsp, err := fs.client.Do(req)
+	return rsp.Body, infoFromResponse(rsp), nil
+}
+
+func (fs *FlickrSource) Stat(ctx context.Context, id string) (*SourceInfo, error) {
+
+	rsp, err := fs.do(ctx, "HEAD", id, nil)
 
 	if err != nil {
 		return nil, err
 	}
 
-	defer rsp.Body.Close()
-	body, err := ioutil.ReadAll(rsp.Body)
+	rsp.Body.Close()
+	return infoFromResponse(rsp), nil
+}
+
+func (fs *FlickrSource) do(ctx context.Context, method string, id string, header http.Header) (*http.Response, error) {
+
+	source, err := fs.getSource(ctx, id)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return body, nil
-
+	return fs.fetcher.do(ctx, method, id, source, header)
 }
 
 func (fs *FlickrSource) GetSource(id string) (string, error) {
 
+	return fs.getSource(context.Background(), id)
+}
+
+func (fs *FlickrSource) getSource(ctx context.Context, id string) (string, error) {
+
 	cached, err := fs.cache.Get(id)
 
 	if err == nil {
@@ -126,14 +179,26 @@ func (fs *FlickrSource) GetSource(id string) (string, error) {
 	req.URL.RawQuery = values.Encode()
 	log.Println(req.URL.RawQuery)
 
-	rsp, err := fs.client.Do(req)
+	req = req.WithContext(ctx)
+
+	// the API responds with JSON rather than an image so it doesn't go
+	// through fs.fetcher.do, but it does use the same (timeout) settings
+
+	rsp, err := fs.fetcher.client.Do(req)
 
 	if err != nil {
-		return "", err
+		return "", iiiferrors.NewUpstreamUnavailableError("Flickr", err)
 	}
 
 	defer rsp.Body.Close()
-	body, err := ioutil.ReadAll(rsp.Body)
+
+	err = checkResponse(id, "Flickr", rsp)
+
+	if err != nil {
+		return "", err
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(rsp.Body, maxFlickrResponse))
 
 	if err != nil {
 		return "", err
@@ -148,6 +213,21 @@ func (fs *FlickrSource) GetSource(id string) (string, error) {
 		return "", err
 	}
 
+	// https://www.flickr.com/services/api/flickr.photos.getSizes.html - error
+	// code 1 is "Photo not found"
+
+	if data.Stat == "fail" {
+
+		message := fmt.Sprintf("Flickr API error %d: %s", data.Code, data.Message)
+		err := errors.New(message)
+
+		if data.Code == 1 {
+			return "", iiiferrors.NewNotFoundError(id, err)
+		}
+
+		return "", err
+	}
+
 	by_label := make(map[string]PhotoSize)
 
 	for _, sz := range data.Sizes.Size {
@@ -178,3 +258,27 @@ func (fs *FlickrSource) GetSource(id string) (string, error) {
 
 	return source, nil
 }
+
+func flickrCache() (iiifcache.Cache, error) {
+
+	flickr_cache_mu.Lock()
+	defer flickr_cache_mu.Unlock()
+
+	if flickr_cache != nil {
+		return flickr_cache, nil
+	}
+
+	cache_config := iiifconfig.CacheConfig{
+		TTL:   3600,
+		Limit: 1,
+	}
+
+	cache, err := iiifcache.NewMemoryCache(cache_config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	flickr_cache = cache
+	return flickr_cache, nil
+}
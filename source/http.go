@@ -0,0 +1,329 @@
+package source
+
+// httpFetcher is the HTTP plumbing shared by the sources that read images from
+// remote servers (URI and Flickr). It is configured by the following properties
+// of a source's config block:
+//
+//	timeout        the number of seconds to wait for a response (default 30)
+//	retries        the number of times to retry a request that failed because
+//	               the server couldn't be reached, returned a 5xx error or asked
+//	               us to slow down (default 0)
+//	backoff        the number of milliseconds to wait before the first retry,
+//	               doubling for each one after that (default 500)
+//	max_bytes      the largest image, in bytes, to read (default: the
+//	               limits.max_source_bytes setting, if there is one)
+//	content_types  the list of content types (or prefixes like "image/") that
+//	               count as images (default "image/", "application/octet-stream"
+//	               and "binary/octet-stream")
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	iiiferrors "github.com/thisisaaronland/go-iiif/errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultHTTPTimeout = 30 * time.Second
+const defaultHTTPBackoff = 500 * time.Millisecond
+const maxHTTPBackoff = 30 * time.Second
+
+var defaultContentTypes = []string{
+	"image/",
+	"application/octet-stream",
+	"binary/octet-stream",
+}
+
+type httpFetcher struct {
+	client        *http.Client
+	retries       int
+	backoff       time.Duration
+	max_bytes     int64
+	content_types []string
+}
+
+// retryableError is a failed request that might work if it's tried again,
+// after waiting at least as long as the server asked us to
+
+type retryableError struct {
+	err   error
+	after time.Duration
+}
+
+func (e *retryableError) Error() string {
+	return e.err.Error()
+}
+
+func newHTTPFetcher(config *iiifconfig.Config) *httpFetcher {
+
+	cfg := config.Images.Source
+
+	timeout := defaultHTTPTimeout
+
+	if cfg.Timeout > 0 {
+		timeout = time.Duration(cfg.Timeout) * time.Second
+	}
+
+	backoff := defaultHTTPBackoff
+
+	if cfg.Backoff > 0 {
+		backoff = time.Duration(cfg.Backoff) * time.Millisecond
+	}
+
+	max_bytes := cfg.MaxBytes
+
+	if max_bytes <= 0 {
+		max_bytes = config.Limits.MaxSourceBytes
+	}
+
+	content_types := cfg.ContentTypes
+
+	if len(content_types) == 0 {
+		content_types = defaultContentTypes
+	}
+
+	client := &http.Client{
+		Timeout: timeout,
+	}
+
+	f := httpFetcher{
+		client:        client,
+		retries:       cfg.Retries,
+		backoff:       backoff,
+		max_bytes:     int64(max_bytes),
+		content_types: content_types,
+	}
+
+	return &f
+}
+
+// do returns the (successful) response for uri, whose Body it is the caller's
+// responsibility to close. If the request had conditional headers and the
+// server says the image hasn't changed the response's status code will be
+// 304 (Not Modified) and its Body will be empty.
+
+func (f *httpFetcher) do(ctx context.Context, method string, id string, uri string, header http.Header) (*http.Response, error) {
+
+	backoff := f.backoff
+
+	for attempt := 0; ; attempt++ {
+
+		rsp, err := f.try(ctx, method, id, uri, header)
+
+		if err == nil {
+			return rsp, nil
+		}
+
+		r, ok := err.(*retryableError)
+
+		if !ok {
+			return nil, err
+		}
+
+		if attempt >= f.retries {
+			return nil, r.err
+		}
+
+		wait := backoff
+
+		if r.after > wait {
+			wait = r.after
+		}
+
+		if wait > maxHTTPBackoff {
+			wait = maxHTTPBackoff
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+			// pass
+		}
+
+		backoff = backoff * 2
+	}
+}
+
+func (f *httpFetcher) try(ctx context.Context, method string, id string, uri string, header http.Header) (*http.Response, error) {
+
+	req, err := http.NewRequest(method, uri, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	req = req.WithContext(ctx)
+
+	rsp, err := f.client.Do(req)
+
+	if err != nil {
+
+		// don't retry (or blame the upstream for) requests that were
+		// cancelled by the caller
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		e := retryableError{
+			err: iiiferrors.NewUpstreamUnavailableError(uri, err),
+		}
+
+		return nil, &e
+	}
+
+	if rsp.StatusCode == http.StatusNotModified {
+		rsp.Body.Close()
+		return rsp, nil
+	}
+
+	err = checkResponse(id, uri, rsp)
+
+	if err != nil {
+
+		rsp.Body.Close()
+
+		if rsp.StatusCode >= 500 || rsp.StatusCode == http.StatusTooManyRequests {
+
+			e := retryableError{
+				err:   err,
+				after: retryAfter(rsp),
+			}
+
+			return nil, &e
+		}
+
+		return nil, err
+	}
+
+	err = f.checkContentType(uri, rsp)
+
+	if err != nil {
+		rsp.Body.Close()
+		return nil, err
+	}
+
+	if f.max_bytes > 0 {
+
+		if rsp.ContentLength > f.max_bytes {
+			rsp.Body.Close()
+			return nil, maxBytesError(id, f.max_bytes)
+		}
+
+		rsp.Body = &limitedBody{
+			ReadCloser: rsp.Body,
+			id:         id,
+			remaining:  f.max_bytes,
+			max:        f.max_bytes,
+		}
+	}
+
+	return rsp, nil
+}
+
+// checkContentType makes sure that a response is (probably) an image and not,
+// for example, an HTML error page that was sent with a 200 status code
+
+func (f *httpFetcher) checkContentType(uri string, rsp *http.Response) error {
+
+	content_type := rsp.Header.Get("Content-Type")
+
+	if content_type == "" {
+		return nil
+	}
+
+	media_type, _, err := mime.ParseMediaType(content_type)
+
+	if err != nil {
+		media_type = content_type
+	}
+
+	media_type = strings.ToLower(media_type)
+
+	for _, t := range f.content_types {
+
+		if strings.HasPrefix(media_type, strings.ToLower(t)) {
+			return nil
+		}
+	}
+
+	message := fmt.Sprintf("%s returned an unsupported content type (%s)", uri, content_type)
+	return iiiferrors.NewUpstreamUnavailableError(uri, errors.New(message))
+}
+
+// retryAfter returns the delay that a server asked for in its Retry-After
+// header, or 0 if it didn't ask for one
+
+func retryAfter(rsp *http.Response) time.Duration {
+
+	v := rsp.Header.Get("Retry-After")
+
+	if v == "" {
+		return 0
+	}
+
+	secs, err := strconv.Atoi(v)
+
+	if err == nil {
+		return time.Duration(secs) * time.Second
+	}
+
+	t, err := http.ParseTime(v)
+
+	if err == nil {
+		return time.Until(t)
+	}
+
+	return 0
+}
+
+func maxBytesError(id string, max int64) error {
+
+	message := fmt.Sprintf("Source image %s exceeds the limit of %d bytes", id, max)
+	return iiiferrors.NewLimitExceededError("max_bytes", errors.New(message))
+}
+
+// limitedBody is a response body that fails, rather than being silently
+// truncated, if it's bigger than max bytes
+
+type limitedBody struct {
+	io.ReadCloser
+	id        string
+	remaining int64
+	max       int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+
+	if b.remaining <= 0 {
+
+		var extra [1]byte
+		n, _ := io.ReadFull(b.ReadCloser, extra[:])
+
+		if n > 0 {
+			return 0, maxBytesError(b.id, b.max)
+		}
+
+		return 0, io.EOF
+	}
+
+	if int64(len(p)) > b.remaining {
+		p = p[0:b.remaining]
+	}
+
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+
+	return n, err
+}
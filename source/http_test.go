@@ -0,0 +1,329 @@
+package source
+
+import (
+	"context"
+	"errors"
+	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	iiiferrors "github.com/thisisaaronland/go-iiif/errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func readTestURISource(src *URISource, id string) ([]byte, error) {
+
+	fh, _, err := src.Open(context.Background(), id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer fh.Close()
+
+	return ioutil.ReadAll(fh)
+}
+
+func TestHTTPFetcher(t *testing.T) {
+
+	modtime := time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	mu := new(sync.Mutex)
+	requests := make(map[string]int)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		mu.Lock()
+		requests[r.URL.Path] += 1
+		n := requests[r.URL.Path]
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "image/jpeg")
+
+		switch r.URL.Path {
+		case "/retry.jpg":
+
+			if n == 1 {
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+
+			if n == 2 {
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+
+		case "/bad.jpg":
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		case "/gone.jpg":
+			w.WriteHeader(http.StatusGone)
+			return
+		case "/forbidden.jpg":
+			w.WriteHeader(http.StatusForbidden)
+			return
+		case "/missing.jpg":
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case "/slow.jpg":
+
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+
+			return
+
+		case "/large.jpg":
+			w.Write([]byte("0123456789"))
+			return
+		case "/chunked.jpg":
+
+			// flushing before writing everything means that there's
+			// no Content-Length header
+
+			w.Write([]byte("01234"))
+			w.(http.Flusher).Flush()
+			w.Write([]byte("56789"))
+			return
+
+		case "/error.jpg":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		case "/test.jp2":
+			w.Header().Set("Content-Type", "image/jp2")
+		default:
+			w.Header().Set("Content-Type", "Image/JPEG")
+		}
+
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+
+		if err == nil && !modtime.After(since) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", modtime.Format(http.TimeFormat))
+		w.Write([]byte("hello"))
+	}))
+
+	defer ts.Close()
+
+	config := iiifconfig.Config{}
+
+	config.Images.Source = iiifconfig.SourceConfig{
+		Name:     "URI",
+		Path:     ts.URL + "/{id}",
+		Retries:  2,
+		Backoff:  1,
+		MaxBytes: 8,
+	}
+
+	src, err := NewURISource(&config)
+
+	if err != nil {
+		t.Fatalf("Failed to create URI source, %s", err)
+	}
+
+	// the first retry waits for as long as the server asked, not the (much
+	// shorter) backoff
+
+	t1 := time.Now()
+	body, err := readTestURISource(src, "retry.jpg")
+
+	if err != nil || string(body) != "hello" {
+		t.Fatalf("Expected request to succeed after retrying, got '%s' %v", body, err)
+	}
+
+	if time.Since(t1) < time.Second {
+		t.Fatalf("Expected the Retry-After header to be honoured, took %s", time.Since(t1))
+	}
+
+	_, err = readTestURISource(src, "bad.jpg")
+
+	if iiiferrors.StatusCode(err, 0) != http.StatusServiceUnavailable {
+		t.Fatalf("Expected an UpstreamUnavailableError once retries are exhausted, got %v", err)
+	}
+
+	for _, id := range []string{"missing.jpg", "gone.jpg"} {
+
+		_, err := readTestURISource(src, id)
+
+		if !iiiferrors.IsNotFound(err) {
+			t.Fatalf("Expected a NotFound error for %s, got %v", id, err)
+		}
+	}
+
+	_, err = readTestURISource(src, "forbidden.jpg")
+
+	if err == nil || iiiferrors.IsNotFound(err) || iiiferrors.StatusCode(err, 0) == http.StatusServiceUnavailable {
+		t.Fatalf("Expected a plain error for a 403, got %v", err)
+	}
+
+	// only server errors are worth retrying
+
+	expected := map[string]int{
+		"/retry.jpg":     3,
+		"/bad.jpg":       3,
+		"/missing.jpg":   1,
+		"/gone.jpg":      1,
+		"/forbidden.jpg": 1,
+	}
+
+	mu.Lock()
+
+	for path, count := range expected {
+
+		if requests[path] != count {
+			t.Fatalf("Expected %d requests for %s, got %d", count, path, requests[path])
+		}
+	}
+
+	mu.Unlock()
+
+	// images are limited to max_bytes whether or not the server says how
+	// big they are up front
+
+	var limit_err *iiiferrors.LimitExceededError
+
+	_, _, err = src.Open(context.Background(), "large.jpg")
+
+	if !errors.As(err, &limit_err) {
+		t.Fatalf("Expected a LimitExceededError before reading, got %v", err)
+	}
+
+	_, err = readTestURISource(src, "chunked.jpg")
+
+	if !errors.As(err, &limit_err) {
+		t.Fatalf("Expected a LimitExceededError while reading, got %v", err)
+	}
+
+	// content types are case-insensitive and HTML error pages are rejected
+
+	_, err = readTestURISource(src, "test.jpg")
+
+	if err != nil {
+		t.Fatalf("Expected content types to be case-insensitive, %s", err)
+	}
+
+	_, err = readTestURISource(src, "error.jpg")
+
+	if iiiferrors.StatusCode(err, 0) != http.StatusServiceUnavailable || !strings.Contains(err.Error(), "unsupported content type") {
+		t.Fatalf("Expected an HTML page to be rejected, got %v", err)
+	}
+
+	// conditional requests
+
+	ctx := context.Background()
+
+	fh, info, err := src.Open(ctx, "test.jpg")
+
+	if err != nil {
+		t.Fatalf("Failed to open image, %s", err)
+	}
+
+	fh.Close()
+
+	if info.ETag != `"v1"` || !info.ModTime.Equal(modtime) {
+		t.Fatalf("Unexpected info %+v", info)
+	}
+
+	for _, info := range []*SourceInfo{{ETag: `"v1"`}, {ModTime: modtime}} {
+
+		_, _, err = src.OpenIfChanged(ctx, "test.jpg", info)
+
+		if err != ErrNotModified {
+			t.Fatalf("Expected ErrNotModified for %+v, got %v", info, err)
+		}
+	}
+
+	fh, _, err = src.OpenIfChanged(ctx, "test.jpg", &SourceInfo{ETag: `"v0"`})
+
+	if err != nil {
+		t.Fatalf("Expected a changed image to be opened, %s", err)
+	}
+
+	fh.Close()
+
+	// timeouts are the upstream's fault but requests that are cancelled by
+	// the caller aren't
+
+	src.fetcher.client.Timeout = 50 * time.Millisecond
+
+	_, err = readTestURISource(src, "slow.jpg")
+
+	if iiiferrors.StatusCode(err, 0) != http.StatusServiceUnavailable {
+		t.Fatalf("Expected a timeout to be an UpstreamUnavailableError, got %v", err)
+	}
+
+	src.fetcher.client.Timeout = 0
+
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+
+	_, _, err = src.Open(ctx, "slow.jpg")
+
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Expected context.DeadlineExceeded, got %v", err)
+	}
+
+	// only the allowed content types are accepted, if there are any
+
+	config.Images.Source.ContentTypes = []string{"image/jp2"}
+
+	src, err = NewURISource(&config)
+
+	if err != nil {
+		t.Fatalf("Failed to create URI source, %s", err)
+	}
+
+	_, err = readTestURISource(src, "test.jp2")
+
+	if err != nil {
+		t.Fatalf("Expected image/jp2 to be allowed, %s", err)
+	}
+
+	_, err = readTestURISource(src, "test.jpg")
+
+	if iiiferrors.StatusCode(err, 0) != http.StatusServiceUnavailable {
+		t.Fatalf("Expected image/jpeg to be rejected, got %v", err)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+
+	rsp := http.Response{
+		Header: http.Header{},
+	}
+
+	if retryAfter(&rsp) != 0 {
+		t.Fatal("Expected no delay without a Retry-After header")
+	}
+
+	rsp.Header.Set("Retry-After", "120")
+
+	if retryAfter(&rsp) != 2*time.Minute {
+		t.Fatalf("Expected a delay of 2m, got %s", retryAfter(&rsp))
+	}
+
+	rsp.Header.Set("Retry-After", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+
+	if d := retryAfter(&rsp); d < 59*time.Minute || d > time.Hour {
+		t.Fatalf("Expected a delay of about 1h, got %s", d)
+	}
+
+	rsp.Header.Set("Retry-After", "soon")
+
+	if retryAfter(&rsp) != 0 {
+		t.Fatal("Expected no delay for an invalid Retry-After header")
+	}
+}
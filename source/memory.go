@@ -1,6 +1,11 @@
 package source
 
-import ()
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+)
 
 type MemorySource struct {
 	Source
@@ -20,3 +25,26 @@ func (mem *MemorySource) Read(uri string) ([]byte, error) {
 
 	return mem.body, nil
 }
+
+func (mem *MemorySource) Open(ctx context.Context, uri string) (io.ReadCloser, *SourceInfo, error) {
+
+	err := ctx.Err()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fh := ioutil.NopCloser(bytes.NewReader(mem.body))
+	return fh, infoFromBytes(mem.body), nil
+}
+
+func (mem *MemorySource) Stat(ctx context.Context, uri string) (*SourceInfo, error) {
+
+	err := ctx.Err()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return infoFromBytes(mem.body), nil
+}
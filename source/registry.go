@@ -0,0 +1,78 @@
+package source
+
+// This is the same pattern that database/sql uses for drivers. Every source
+// that ships with go-iiif registers itself (see the init functions in each
+// source's file) and third-party sources can do the same thing and be enabled
+// with a blank import, for example:
+//
+//	import _ "example.com/your/iiif-source"
+
+import (
+	"errors"
+	"fmt"
+	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	"sort"
+	"sync"
+)
+
+type SourceInitializeFunc func(config *iiifconfig.Config) (Source, error)
+
+var sources_mu = new(sync.RWMutex)
+var sources = make(map[string]SourceInitializeFunc)
+
+// Register makes a source available by name, as in the "images.source.name"
+// config property. Like sql.Register it panics if it's called twice with the
+// same name or with a nil function.
+
+func Register(name string, init_func SourceInitializeFunc) {
+
+	sources_mu.Lock()
+	defer sources_mu.Unlock()
+
+	if init_func == nil {
+		panic("source: Register function is nil")
+	}
+
+	_, dupe := sources[name]
+
+	if dupe {
+		panic("source: Register called twice for source " + name)
+	}
+
+	sources[name] = init_func
+}
+
+func Sources() []string {
+
+	sources_mu.RLock()
+	defer sources_mu.RUnlock()
+
+	names := make([]string, 0)
+
+	for name, _ := range sources {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+func NewSourceFromConfig(config *iiifconfig.Config) (Source, error) {
+
+	// note that there is no "Memory" source or at least not yet
+	// since it assumes you're passing it []bytes and not a config
+	// file (20160907/thisisaaronland)
+
+	name := config.Images.Source.Name
+
+	sources_mu.RLock()
+	init_func, ok := sources[name]
+	sources_mu.RUnlock()
+
+	if !ok {
+		message := fmt.Sprintf("Unknown source type '%s'", name)
+		return nil, errors.New(message)
+	}
+
+	return init_func(config)
+}
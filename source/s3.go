@@ -1,8 +1,11 @@
 package source
 
 import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
 	iiifaws "github.com/thisisaaronland/go-iiif/aws"
 	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	"io"
 	_ "log"
 )
 
@@ -10,6 +13,20 @@ type S3Source struct {
 	S3 *iiifaws.S3Connection
 }
 
+func init() {
+
+	Register("S3", func(config *iiifconfig.Config) (Source, error) {
+
+		src, err := NewS3Source(config)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return src, nil
+	})
+}
+
 func NewS3Source(cfg *iiifconfig.Config) (*S3Source, error) {
 
 	src := cfg.Images.Source
@@ -24,6 +41,8 @@ func NewS3Source(cfg *iiifconfig.Config) (*S3Source, error) {
 		Prefix:      prefix,
 		Region:      region,
 		Credentials: creds,
+		Endpoint:    src.Endpoint,
+		PathStyle:   src.PathStyle,
 	}
 
 	s3, err := iiifaws.NewS3Connection(s3cfg)
@@ -43,3 +62,47 @@ func (c *S3Source) Read(id string) ([]byte, error) {
 
 	return c.S3.Get(id)
 }
+
+func (c *S3Source) Open(ctx context.Context, id string) (io.ReadCloser, *SourceInfo, error) {
+
+	rsp, err := c.S3.GetWithContext(ctx, id)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info := SourceInfo{
+		Size:        aws.Int64Value(rsp.ContentLength),
+		ModTime:     aws.TimeValue(rsp.LastModified),
+		ETag:        aws.StringValue(rsp.ETag),
+		ContentType: aws.StringValue(rsp.ContentType),
+	}
+
+	if rsp.ContentLength == nil {
+		info.Size = -1
+	}
+
+	return rsp.Body, &info, nil
+}
+
+func (c *S3Source) Stat(ctx context.Context, id string) (*SourceInfo, error) {
+
+	rsp, err := c.S3.HeadWithContext(ctx, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	info := SourceInfo{
+		Size:        aws.Int64Value(rsp.ContentLength),
+		ModTime:     aws.TimeValue(rsp.LastModified),
+		ETag:        aws.StringValue(rsp.ETag),
+		ContentType: aws.StringValue(rsp.ContentType),
+	}
+
+	if rsp.ContentLength == nil {
+		info.Size = -1
+	}
+
+	return &info, nil
+}
@@ -1,36 +1,181 @@
 package source
 
 import (
+	"bytes"
+	"context"
 	"errors"
-	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
 )
 
 type Source interface {
 	Read(uri string) ([]byte, error)
 }
 
-func NewSourceFromConfig(config *iiifconfig.Config) (Source, error) {
-
-	cfg := config.Images
-
-	// note that there is no "Memory" source or at least not yet
-	// since it assumes you're passing it []bytes and not a config
-	// file (20160907/thisisaaronland)
-
-	if cfg.Source.Name == "Disk" {
-		cache, err := NewDiskSource(config)
-		return cache, err
-	} else if cfg.Source.Name == "Flickr" {
-		cache, err := NewFlickrSource(config)
-		return cache, err
-	} else if cfg.Source.Name == "S3" {
-		cache, err := NewS3Source(config)
-		return cache, err
-	} else if cfg.Source.Name == "URI" {
-		cache, err := NewURISource(config)
-		return cache, err
-	} else {
-		err := errors.New("Unknown source type")
+// StreamingSource is a Source that can hand back an image without reading all
+// of it in to memory first, that can be cancelled and that can describe an
+// image without reading it at all. All the sources that ship with go-iiif are
+// StreamingSources; use NewStreamingSource for anything else.
+
+type StreamingSource interface {
+	Source
+	Open(ctx context.Context, uri string) (io.ReadCloser, *SourceInfo, error)
+	Stat(ctx context.Context, uri string) (*SourceInfo, error)
+}
+
+// SourceInfo is what a StreamingSource knows about an image. Size is -1 and
+// everything else is its zero value if it isn't known.
+
+type SourceInfo struct {
+	Size        int64
+	ModTime     time.Time
+	ETag        string
+	ContentType string
+}
+
+// LastModifiedSource is implemented by sources that can (cheaply) tell when an
+// image was last changed without having to read it. It's used to set the
+// Last-Modified header for info.json files and derivatives.
+
+type LastModifiedSource interface {
+	LastModified(uri string) (time.Time, error)
+}
+
+// ConditionalSource is implemented by sources that can check whether an image
+// has changed since it was last read, using the ETag and ModTime properties of
+// info. If it hasn't changed OpenIfChanged returns ErrNotModified, otherwise it
+// behaves like StreamingSource.Open.
+
+type ConditionalSource interface {
+	OpenIfChanged(ctx context.Context, uri string, info *SourceInfo) (io.ReadCloser, *SourceInfo, error)
+}
+
+var ErrNotModified = errors.New("Not modified")
+
+// IsConditional reports whether id, read from src, can be checked for changes
+// later with OpenIfChanged. Sources that choose between other sources (like
+// CompositeSource) decide that for each identifier.
+
+func IsConditional(src Source, id string) bool {
+
+	cs, ok := src.(interface {
+		Conditional(string) bool
+	})
+
+	if ok {
+		return cs.Conditional(id)
+	}
+
+	_, ok = src.(ConditionalSource)
+	return ok
+}
+
+// NewStreamingSource returns src if it is already a StreamingSource and
+// otherwise wraps it so that it can be used as one. Wrapped sources still
+// read images in to memory, because that's all they know how to do, and
+// they need to read an image to Stat it.
+
+func NewStreamingSource(src Source) StreamingSource {
+
+	ss, ok := src.(StreamingSource)
+
+	if ok {
+		return ss
+	}
+
+	ls := legacySource{
+		source: src,
+	}
+
+	return &ls
+}
+
+// ReadAll reads the whole of an image from a StreamingSource, which is how
+// they implement the Source interface's Read method.
+
+func ReadAll(ctx context.Context, src StreamingSource, uri string) ([]byte, error) {
+
+	fh, _, err := src.Open(ctx, uri)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer fh.Close()
+
+	return ioutil.ReadAll(fh)
+}
+
+type legacySource struct {
+	StreamingSource
+	source Source
+}
+
+func (ls *legacySource) Read(uri string) ([]byte, error) {
+
+	return ls.source.Read(uri)
+}
+
+func (ls *legacySource) Open(ctx context.Context, uri string) (io.ReadCloser, *SourceInfo, error) {
+
+	body, info, err := ls.read(ctx, uri)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(body)), info, nil
+}
+
+func (ls *legacySource) Stat(ctx context.Context, uri string) (*SourceInfo, error) {
+
+	_, info, err := ls.read(ctx, uri)
+
+	if err != nil {
 		return nil, err
 	}
+
+	return info, nil
+}
+
+func (ls *legacySource) read(ctx context.Context, uri string) ([]byte, *SourceInfo, error) {
+
+	err := ctx.Err()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	body, err := ls.source.Read(uri)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info := infoFromBytes(body)
+
+	lm, ok := ls.source.(LastModifiedSource)
+
+	if ok {
+
+		t, err := lm.LastModified(uri)
+
+		if err == nil {
+			info.ModTime = t
+		}
+	}
+
+	return body, info, nil
+}
+
+func infoFromBytes(body []byte) *SourceInfo {
+
+	info := SourceInfo{
+		Size:        int64(len(body)),
+		ContentType: http.DetectContentType(body),
+	}
+
+	return &info
 }
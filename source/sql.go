@@ -0,0 +1,447 @@
+package source
+
+// SQLSource reads images stored as BLOBs in a database, using a query that
+// takes the identifier as its only parameter:
+//
+//	"source": {
+//		"name": "SQL",
+//		"driver": "sqlite3",
+//		"dsn": "/usr/local/images.db",
+//		"query": "SELECT body, content_type, last_modified FROM images WHERE id = ?"
+//	}
+//
+// The query must return either a single column, which is the image, or a column
+// called "body" and, optionally, columns called "content_type" and
+// "last_modified". The optional "stat_query" is used to describe an image
+// without reading it and may return columns called "size", "content_type" and
+// "last_modified". Without it images are read in order to describe them and
+// the Last-Modified time of an image is only reported once it has been read.
+//
+// The "sqlite3" driver is always available. Other database/sql drivers can be
+// used by importing them, for example:
+//
+//	import _ "github.com/lib/pq"
+//
+// Connections are pooled, according to the "max_open_conns", "max_idle_conns"
+// and "conn_max_lifetime" (seconds) properties, and each pool is shared by all
+// of the SQL sources in a process with the same driver and DSN. Since they
+// share a pool those sources must also have the same pool properties.
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	_ "github.com/mattn/go-sqlite3"
+	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	iiiferrors "github.com/thisisaaronland/go-iiif/errors"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type SQLSource struct {
+	StreamingSource
+	db         *sql.DB
+	query      string
+	stat_query string
+}
+
+type sqlPool struct {
+	db       *sql.DB
+	settings string
+}
+
+var databases_mu = new(sync.Mutex)
+var databases = make(map[string]*sqlPool)
+
+// the formats that SQLite (and others) use when times are stored as strings
+
+var sqlTimeFormats = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+func init() {
+
+	Register("SQL", func(config *iiifconfig.Config) (Source, error) {
+
+		src, err := NewSQLSource(config)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return src, nil
+	})
+}
+
+func NewSQLSource(config *iiifconfig.Config) (*SQLSource, error) {
+
+	cfg := config.Images.Source
+
+	if cfg.DSN == "" {
+		return nil, errors.New("SQL source has no DSN")
+	}
+
+	if cfg.Query == "" {
+		return nil, errors.New("SQL source has no query")
+	}
+
+	driver := cfg.Driver
+
+	if driver == "" {
+		driver = "sqlite3"
+	}
+
+	db, err := openDatabase(driver, cfg)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ss := SQLSource{
+		db:         db,
+		query:      cfg.Query,
+		stat_query: cfg.StatQuery,
+	}
+
+	return &ss, nil
+}
+
+// openDatabase returns the (shared) connection pool for driver and cfg.DSN,
+// creating it if necessary. sql.Open doesn't actually connect to anything so
+// new pools are pinged to make sure the config is valid. Asking for a pool that
+// already exists with different settings is an error, rather than quietly
+// ignoring them.
+
+func openDatabase(driver string, cfg iiifconfig.SourceConfig) (*sql.DB, error) {
+
+	key := fmt.Sprintf("%s#%s", driver, cfg.DSN)
+	settings := fmt.Sprintf("max_open_conns=%d max_idle_conns=%d conn_max_lifetime=%d", cfg.MaxOpenConns, cfg.MaxIdleConns, cfg.ConnMaxLifetime)
+
+	databases_mu.Lock()
+	defer databases_mu.Unlock()
+
+	pool, ok := databases[key]
+
+	if ok {
+
+		if pool.settings != settings {
+			message := fmt.Sprintf("SQL source has different connection pool settings (%s) to another SQL source with the same DSN (%s)", settings, pool.settings)
+			return nil, errors.New(message)
+		}
+
+		return pool.db, nil
+	}
+
+	db, err := sql.Open(driver, cfg.DSN)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(cfg.MaxOpenConns)
+	}
+
+	if cfg.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+
+	if cfg.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(time.Duration(cfg.ConnMaxLifetime) * time.Second)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err = db.PingContext(ctx)
+
+	if err != nil {
+		db.Close()
+		message := fmt.Sprintf("Failed to connect to SQL source, %s", err)
+		return nil, errors.New(message)
+	}
+
+	databases[key] = &sqlPool{
+		db:       db,
+		settings: settings,
+	}
+
+	return db, nil
+}
+
+func (ss *SQLSource) Read(id string) ([]byte, error) {
+
+	return ReadAll(context.Background(), ss, id)
+}
+
+func (ss *SQLSource) Open(ctx context.Context, id string) (io.ReadCloser, *SourceInfo, error) {
+
+	row, err := ss.queryRow(ctx, ss.query, id)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var body []byte
+
+	if len(row) == 1 {
+
+		for _, v := range row {
+			body, err = sqlBytes(v)
+		}
+
+	} else {
+
+		v, ok := row["body"]
+
+		if !ok {
+			return nil, nil, errors.New("SQL source query did not return a body column")
+		}
+
+		body, err = sqlBytes(v)
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if body == nil {
+		return nil, nil, iiiferrors.NewNotFoundError(id, nil)
+	}
+
+	info := infoFromBytes(body)
+	err = sqlInfo(row, info)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(body)), info, nil
+}
+
+func (ss *SQLSource) Stat(ctx context.Context, id string) (*SourceInfo, error) {
+
+	if ss.stat_query == "" {
+
+		fh, info, err := ss.Open(ctx, id)
+
+		if err != nil {
+			return nil, err
+		}
+
+		fh.Close()
+		return info, nil
+	}
+
+	row, err := ss.queryRow(ctx, ss.stat_query, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	info := SourceInfo{
+		Size: -1,
+	}
+
+	v, ok := row["size"]
+
+	if ok {
+
+		size, err := sqlInt(v)
+
+		if err != nil {
+			return nil, err
+		}
+
+		info.Size = size
+	}
+
+	err = sqlInfo(row, &info)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &info, nil
+}
+
+// LastModified uses the "stat_query" to find out when an image was last
+// changed. Without one the only way to find out is to read the whole image,
+// which is too much to do for every request, so the time is unknown.
+
+func (ss *SQLSource) LastModified(id string) (time.Time, error) {
+
+	if ss.stat_query == "" {
+		return time.Time{}, errors.New("Last modified time is unknown without a stat_query")
+	}
+
+	info, err := ss.Stat(context.Background(), id)
+
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if info.ModTime.IsZero() {
+		return time.Time{}, errors.New("Last modified time is unknown")
+	}
+
+	return info.ModTime, nil
+}
+
+// queryRow returns the first row returned by query, keyed by (lower-cased)
+// column name
+
+func (ss *SQLSource) queryRow(ctx context.Context, query string, id string) (map[string]interface{}, error) {
+
+	rows, err := ss.db.QueryContext(ctx, query, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	if !rows.Next() {
+
+		err = rows.Err()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, iiiferrors.NewNotFoundError(id, nil)
+	}
+
+	columns, err := rows.Columns()
+
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]interface{}, len(columns))
+	dest := make([]interface{}, len(columns))
+
+	for i, _ := range values {
+		dest[i] = &values[i]
+	}
+
+	err = rows.Scan(dest...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	row := make(map[string]interface{})
+
+	for i, name := range columns {
+		row[strings.ToLower(name)] = values[i]
+	}
+
+	return row, nil
+}
+
+// sqlInfo copies the optional "content_type" and "last_modified" columns in
+// row to info
+
+func sqlInfo(row map[string]interface{}, info *SourceInfo) error {
+
+	v, ok := row["content_type"]
+
+	if ok && v != nil {
+
+		content_type, err := sqlBytes(v)
+
+		if err != nil {
+			return err
+		}
+
+		info.ContentType = string(content_type)
+	}
+
+	v, ok = row["last_modified"]
+
+	if ok && v != nil {
+
+		t, err := sqlTime(v)
+
+		if err != nil {
+			return err
+		}
+
+		info.ModTime = t
+	}
+
+	return nil
+}
+
+func sqlBytes(v interface{}) ([]byte, error) {
+
+	switch v.(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		return v.([]byte), nil
+	case string:
+		return []byte(v.(string)), nil
+	default:
+		message := fmt.Sprintf("Unexpected type %T for SQL source column", v)
+		return nil, errors.New(message)
+	}
+}
+
+func sqlInt(v interface{}) (int64, error) {
+
+	switch v.(type) {
+	case int64:
+		return v.(int64), nil
+	case []byte:
+		return strconv.ParseInt(string(v.([]byte)), 10, 64)
+	case string:
+		return strconv.ParseInt(v.(string), 10, 64)
+	default:
+		message := fmt.Sprintf("Unexpected type %T for SQL source size column", v)
+		return 0, errors.New(message)
+	}
+}
+
+// sqlTime converts a time (or a Unix timestamp or a string that looks like a
+// time) returned by a database in to a time.Time
+
+func sqlTime(v interface{}) (time.Time, error) {
+
+	switch v.(type) {
+	case time.Time:
+		return v.(time.Time), nil
+	case int64:
+		return time.Unix(v.(int64), 0), nil
+	case []byte, string:
+
+		str, _ := sqlBytes(v)
+
+		for _, layout := range sqlTimeFormats {
+
+			t, err := time.Parse(layout, string(str))
+
+			if err == nil {
+				return t, nil
+			}
+		}
+
+		message := fmt.Sprintf("Unable to parse '%s' as a time for SQL source", str)
+		return time.Time{}, errors.New(message)
+
+	default:
+		message := fmt.Sprintf("Unexpected type %T for SQL source last_modified column", v)
+		return time.Time{}, errors.New(message)
+	}
+}
@@ -0,0 +1,116 @@
+package source
+
+import (
+	"context"
+	"database/sql"
+	iiifconfig "github.com/thisisaaronland/go-iiif/config"
+	iiiferrors "github.com/thisisaaronland/go-iiif/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSQLSourceLastModified(t *testing.T) {
+
+	root, err := ioutil.TempDir("", "iiif-sql")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(root)
+
+	dsn := filepath.Join(root, "images.db")
+
+	db, err := sql.Open("sqlite3", dsn)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	_, err = db.Exec("CREATE TABLE images (id TEXT PRIMARY KEY, body BLOB, content_type TEXT, last_modified TEXT)")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.Exec("INSERT INTO images VALUES (?, ?, ?, ?)", "test.jpg", []byte("hello"), "image/jpeg", "2019-01-02 03:04:05")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	modtime := time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	// without a stat_query LastModified doesn't read the image, but Stat
+	// still can
+
+	config := iiifconfig.Config{}
+
+	config.Images.Source = iiifconfig.SourceConfig{
+		Name:  "SQL",
+		DSN:   dsn,
+		Query: "SELECT body, content_type, last_modified FROM images WHERE id = ?",
+	}
+
+	src, err := NewSQLSource(&config)
+
+	if err != nil {
+		t.Fatalf("Failed to create SQL source, %s", err)
+	}
+
+	_, err = src.LastModified("test.jpg")
+
+	if err == nil {
+		t.Fatal("Expected the last modified time to be unknown without a stat_query")
+	}
+
+	info, err := src.Stat(context.Background(), "test.jpg")
+
+	if err != nil {
+		t.Fatalf("Failed to stat image, %s", err)
+	}
+
+	if info.Size != 5 || info.ContentType != "image/jpeg" || !info.ModTime.Equal(modtime) {
+		t.Fatalf("Unexpected info %+v", info)
+	}
+
+	config.Images.Source.StatQuery = "SELECT length(body) AS size, last_modified FROM images WHERE id = ?"
+
+	src, err = NewSQLSource(&config)
+
+	if err != nil {
+		t.Fatalf("Failed to create SQL source, %s", err)
+	}
+
+	lm, err := src.LastModified("test.jpg")
+
+	if err != nil {
+		t.Fatalf("Failed to get last modified time, %s", err)
+	}
+
+	if !lm.Equal(modtime) {
+		t.Fatalf("Expected %s, got %s", modtime, lm)
+	}
+
+	_, err = src.LastModified("missing.jpg")
+
+	if !iiiferrors.IsNotFound(err) {
+		t.Fatalf("Expected a NotFound error, got %v", err)
+	}
+
+	// sources with the same DSN share a connection pool, so they can't ask
+	// for different pool settings
+
+	config.Images.Source.MaxOpenConns = 4
+
+	_, err = NewSQLSource(&config)
+
+	if err == nil {
+		t.Fatal("Expected different pool settings for the same DSN to fail")
+	}
+}
@@ -1,34 +1,74 @@
 package source
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/jtacoma/uritemplates"
 	iiifconfig "github.com/thisisaaronland/go-iiif/config"
-	"io/ioutil"
-	_ "log"
+	iiiferrors "github.com/thisisaaronland/go-iiif/errors"
+	"io"
 	"net/http"
-	_ "time"
+	"regexp"
 )
 
 type URISource struct {
 	Source
 	template *uritemplates.UriTemplate
-	client   *http.Client
+	match    *regexp.Regexp
+	auth     *uriAuth
+	fetcher  *httpFetcher
+}
+
+func init() {
+
+	Register("URI", func(config *iiifconfig.Config) (Source, error) {
+
+		src, err := NewURISource(config)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return src, nil
+	})
 }
 
 func NewURISource(config *iiifconfig.Config) (*URISource, error) {
 
 	cfg := config.Images
 
-	client := &http.Client{}
 	template, err := uritemplates.Parse(cfg.Source.Path)
 
 	if err != nil {
 		return nil, err
 	}
 
+	auth, err := newURIAuth(cfg.Source)
+
+	if err != nil {
+		return nil, err
+	}
+
 	us := URISource{
 		template: template,
-		client:   client,
+		auth:     auth,
+		fetcher:  newHTTPFetcher(config),
+	}
+
+	// named groups in the "match" regular expression are made available
+	// to the template alongside {id}
+
+	if cfg.Source.Match != "" {
+
+		re, err := regexp.Compile(cfg.Source.Match)
+
+		if err != nil {
+			message := fmt.Sprintf("Invalid match for URI source, %s", err)
+			return nil, errors.New(message)
+		}
+
+		us.match = re
 	}
 
 	return &us, nil
@@ -36,33 +76,154 @@ func NewURISource(config *iiifconfig.Config) (*URISource, error) {
 
 func (us *URISource) Read(id string) ([]byte, error) {
 
-	values := make(map[string]interface{})
-	values["id"] = id
+	return ReadAll(context.Background(), us, id)
+}
 
-	uri, err := us.template.Expand(values)
+func (us *URISource) Open(ctx context.Context, id string) (io.ReadCloser, *SourceInfo, error) {
+
+	rsp, err := us.do(ctx, "GET", id, nil)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return rsp.Body, infoFromResponse(rsp), nil
+}
+
+// OpenIfChanged makes a conditional request for id, using info's ETag and
+// ModTime properties, and returns ErrNotModified if the server says that the
+// image hasn't changed.
+
+func (us *URISource) OpenIfChanged(ctx context.Context, id string, info *SourceInfo) (io.ReadCloser, *SourceInfo, error) {
+
+	rsp, err := us.do(ctx, "GET", id, conditionalHeader(info))
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if rsp.StatusCode == http.StatusNotModified {
+		return nil, nil, ErrNotModified
+	}
+
+	return rsp.Body, infoFromResponse(rsp), nil
+}
+
+func (us *URISource) Stat(ctx context.Context, id string) (*SourceInfo, error) {
+
+	rsp, err := us.do(ctx, "HEAD", id, nil)
 
 	if err != nil {
 		return nil, err
 	}
 
-	req, err := http.NewRequest("GET", uri, nil)
+	rsp.Body.Close()
+	return infoFromResponse(rsp), nil
+}
 
-	// t1 := time.Now()
-	rsp, err := us.client.Do(req)
+// do returns the (successful) response for id, whose Body it is the caller's
+// responsibility to close
 
-	// t2 := time.Since(t1)
-	// log.Println("time to fetch", uri, t2)
+func (us *URISource) do(ctx context.Context, method string, id string, header http.Header) (*http.Response, error) {
+
+	values := make(map[string]interface{})
+	values["id"] = id
+
+	if us.match != nil {
+
+		m := us.match.FindStringSubmatch(id)
+
+		if m == nil {
+			message := fmt.Sprintf("Identifier %s does not match the URI source's pattern", id)
+			return nil, iiiferrors.NewNotFoundError(id, errors.New(message))
+		}
+
+		for i, name := range us.match.SubexpNames() {
+
+			if name != "" && name != "id" {
+				values[name] = m[i]
+			}
+		}
+	}
+
+	uri, err := us.template.Expand(values)
 
 	if err != nil {
 		return nil, err
 	}
 
-	defer rsp.Body.Close()
-	body, err := ioutil.ReadAll(rsp.Body)
+	req_header, err := us.auth.header()
 
 	if err != nil {
 		return nil, err
 	}
 
-	return body, nil
+	for k, v := range header {
+		req_header[k] = v
+	}
+
+	return us.fetcher.do(ctx, method, id, uri, req_header)
+}
+
+// conditionalHeader returns the headers needed to ask a server whether the
+// image described by info has changed
+
+func conditionalHeader(info *SourceInfo) http.Header {
+
+	header := make(http.Header)
+
+	if info == nil {
+		return header
+	}
+
+	if info.ETag != "" {
+		header.Set("If-None-Match", info.ETag)
+	}
+
+	if !info.ModTime.IsZero() {
+		header.Set("If-Modified-Since", info.ModTime.UTC().Format(http.TimeFormat))
+	}
+
+	return header
+}
+
+// checkResponse turns anything other than a 2xx response from an upstream
+// server in to an error, distinguishing between images that don't exist and
+// servers that are having a bad day
+
+func checkResponse(id string, upstream string, rsp *http.Response) error {
+
+	if rsp.StatusCode >= 200 && rsp.StatusCode < 300 {
+		return nil
+	}
+
+	message := fmt.Sprintf("%s returned %s", upstream, rsp.Status)
+	err := errors.New(message)
+
+	if rsp.StatusCode == http.StatusNotFound || rsp.StatusCode == http.StatusGone {
+		return iiiferrors.NewNotFoundError(id, err)
+	}
+
+	if rsp.StatusCode >= 500 || rsp.StatusCode == http.StatusTooManyRequests {
+		return iiiferrors.NewUpstreamUnavailableError(upstream, err)
+	}
+
+	return err
+}
+
+func infoFromResponse(rsp *http.Response) *SourceInfo {
+
+	info := SourceInfo{
+		Size:        rsp.ContentLength,
+		ETag:        rsp.Header.Get("ETag"),
+		ContentType: rsp.Header.Get("Content-Type"),
+	}
+
+	t, err := http.ParseTime(rsp.Header.Get("Last-Modified"))
+
+	if err == nil {
+		info.ModTime = t
+	}
+
+	return &info
 }
@@ -5,10 +5,12 @@ import (
 	"errors"
 	"fmt"
 	iiifcache "github.com/thisisaaronland/go-iiif/cache"
+	iiifcompliance "github.com/thisisaaronland/go-iiif/compliance"
 	iiifconfig "github.com/thisisaaronland/go-iiif/config"
 	iiifimage "github.com/thisisaaronland/go-iiif/image"
 	iiiflevel "github.com/thisisaaronland/go-iiif/level"
 	iiifprofile "github.com/thisisaaronland/go-iiif/profile"
+	iiifresolver "github.com/thisisaaronland/go-iiif/resolver"
 	iiifsource "github.com/thisisaaronland/go-iiif/source"
 	_ "log"
 	"math"
@@ -22,8 +24,10 @@ import (
 type TileSeed struct {
 	config            *iiifconfig.Config
 	level             iiiflevel.Level
+	seed_level        iiiflevel.Level
 	images_cache      iiifcache.Cache
 	derivatives_cache iiifcache.Cache
+	resolver          iiifresolver.Resolver
 	Endpoint          string
 	Height            int
 	Width             int
@@ -40,6 +44,20 @@ func NewTileSeed(config *iiifconfig.Config, h int, w int, endpoint string, quali
 		return nil, err
 	}
 
+	/*
+
+		Tiles are always generated using the level 2 features, regardless of what
+		level the config file says. Pre-seeding tiles is how you publish a level 0
+		(static) service in the first place... (see also: TileSizes)
+
+	*/
+
+	seed_level, err := iiiflevel.NewLevel2WithVersion(config, endpoint, level.Compliance().Version())
+
+	if err != nil {
+		return nil, err
+	}
+
 	images_cache, err := iiifcache.NewImagesCacheFromConfig(config)
 
 	if err != nil {
@@ -52,6 +70,12 @@ func NewTileSeed(config *iiifconfig.Config, h int, w int, endpoint string, quali
 		return nil, err
 	}
 
+	resolver, err := iiifresolver.NewResolverFromConfig(config)
+
+	if err != nil {
+		return nil, err
+	}
+
 	compliance := level.Compliance()
 	_, err = compliance.DefaultQuality()
 
@@ -64,8 +88,10 @@ func NewTileSeed(config *iiifconfig.Config, h int, w int, endpoint string, quali
 	ts := TileSeed{
 		config:            config,
 		level:             level,
+		seed_level:        seed_level,
 		images_cache:      images_cache,
 		derivatives_cache: derivatives_cache,
+		resolver:          resolver,
 		Endpoint:          endpoint,
 		Height:            h,
 		Width:             w,
@@ -77,6 +103,14 @@ func NewTileSeed(config *iiifconfig.Config, h int, w int, endpoint string, quali
 	return &ts, nil
 }
 
+// Resolve returns the source identifier for the public identifier id, using
+// the same resolver (the "images.resolver" config block) as iiif-server. Tiles
+// should be seeded with id as their alternate ID so that the server finds them.
+
+func (ts *TileSeed) Resolve(id string) (string, error) {
+	return ts.resolver.Resolve(id)
+}
+
 func (ts *TileSeed) SeedTiles(src_id string, alt_id string, scales []int, refresh bool) (int, error) {
 
 	count := 0
@@ -127,20 +161,13 @@ func (ts *TileSeed) SeedTiles(src_id string, alt_id string, scales []int, refres
 		throttle <- true
 	}
 
-	for _, scale := range scales {
-
-		crops, err := ts.TileSizes(image, scale)
-
-		if err != nil {
-			// log.Println(err)
-			continue
-		}
+	seed := func(crops []*iiifimage.Transformation) {
 
 		wg := new(sync.WaitGroup)
 
 		for _, transformation := range crops {
 
-			<- throttle
+			<-throttle
 
 			wg.Add(1)
 
@@ -151,11 +178,11 @@ func (ts *TileSeed) SeedTiles(src_id string, alt_id string, scales []int, refres
 					throttle <- true
 				}()
 
-				uri, _ := tr.ToURI(alt_id)
+				key, _ := tr.ToCacheKey(alt_id)
 
 				if !refresh {
 
-					_, err := ts.derivatives_cache.Get(uri)
+					_, err := ts.derivatives_cache.Get(key)
 
 					if err == nil {
 						return
@@ -164,29 +191,74 @@ func (ts *TileSeed) SeedTiles(src_id string, alt_id string, scales []int, refres
 
 				tmp, _ := iiifimage.NewImageFromConfigWithSource(ts.config, source, im.Identifier())
 
-				err = tmp.Transform(tr)
+				err := tmp.Transform(tr)
 
 				if err == nil {
-					ts.derivatives_cache.Set(uri, tmp.Body())
+					ts.derivatives_cache.Set(key, tmp.Body())
 				}
 
 			}(throttle, image, transformation, wg)
 		}
 
 		wg.Wait()
+	}
+
+	// no scale factors means use whatever the config file says or, failing
+	// that, whatever makes sense for the image (see profile/tiles.go)
+
+	if len(scales) == 0 {
+
+		dims, err := image.Dimensions()
+
+		if err != nil {
+			return count, err
+		}
+
+		scales = iiifprofile.ScaleFactorsFromConfig(ts.config, dims)
+	}
+
+	for _, scale := range scales {
+
+		crops, err := ts.TileSizes(image, scale)
+
+		if err != nil {
+			// log.Println(err)
+			continue
+		}
+
+		seed(crops)
 
 		// something something something using the channel above to increment count...
 
 		count += len(crops)
 	}
 
+	sizes, err := ts.Sizes(image)
+
+	if err != nil {
+		return count, err
+	}
+
+	seed(sizes)
+	count += len(sizes)
+
 	level, err := iiiflevel.NewLevelFromConfig(ts.config, ts.Endpoint)
 
 	if err != nil {
 		return count, err
 	}
 
-	profile, err := iiifprofile.NewProfile(ts.Endpoint, image, level)
+	// make sure the info.json file describes the tiles we've just seeded
+
+	cfg := *ts.config
+
+	cfg.Profile.Tiles = iiifconfig.TilesConfig{
+		Width:        ts.Width,
+		Height:       ts.Height,
+		ScaleFactors: scales,
+	}
+
+	profile, err := iiifprofile.NewProfileFromConfig(&cfg, ts.Endpoint, image, level)
 
 	if err != nil {
 		return count, err
@@ -220,14 +292,9 @@ func (ts *TileSeed) TileSizes(im iiifimage.Image, sf int) ([]*iiifimage.Transfor
 		return nil, errors.New(msg)
 	}
 
-	quality := ts.Quality
-
-	if quality == "default" {
-		compliance := ts.level.Compliance()
-		quality, _ = compliance.DefaultQuality()
-	}
-
+	quality := ts.canonicalQuality()
 	format := ts.Format
+	version := ts.level.Compliance().Version()
 
 	crops := make([]*iiifimage.Transformation, 0)
 
@@ -296,16 +363,29 @@ func (ts *TileSeed) TileSizes(im iiifimage.Image, sf int) ([]*iiifimage.Transfor
 
 			region := fmt.Sprintf("%d,%d,%d,%d", _x, _y, _w, _h)
 			size := fmt.Sprintf("%d,", _s) // but maybe some client will send 'full' or what...?
+
+			// 3.0 clients ask for tiles using the canonical "w,h" size syntax (see
+			// also: Sizes) which is how OpenSeadragon, for one, works out the height
+
+			if version == iiifcompliance.Version3 {
+				_sh := int(math.Ceil(float64(_h) / float64(sf)))
+				size = fmt.Sprintf("%d,%d", _s, _sh)
+			}
 			rotation := "0"
 			quality := quality
 			format := format
 
-			transformation, err := iiifimage.NewTransformation(ts.level, region, size, rotation, quality, format)
+			transformation, err := iiifimage.NewTransformation(ts.seed_level, region, size, rotation, quality, format)
 
 			if err != nil {
 				return nil, err
 			}
 
+			// but make sure the tiles are named using the configured level's default
+			// quality or they'll never be found by the clients asking for them
+
+			transformation.Quality = quality
+
 			crops = append(crops, transformation)
 		}
 
@@ -313,3 +393,53 @@ func (ts *TileSeed) TileSizes(im iiifimage.Image, sf int) ([]*iiifimage.Transfor
 
 	return crops, nil
 }
+
+// Sizes returns the transformations for the preferred sizes defined in the
+// "profile" config block, using the canonical size syntax for the version of
+// the Image API being spoken.
+
+func (ts *TileSeed) Sizes(im iiifimage.Image) ([]*iiifimage.Transformation, error) {
+
+	sizes, err := iiifprofile.SizesFromConfig(ts.config, im)
+
+	if err != nil {
+		return nil, err
+	}
+
+	quality := ts.canonicalQuality()
+	version := ts.level.Compliance().Version()
+
+	crops := make([]*iiifimage.Transformation, 0)
+
+	for _, sz := range sizes {
+
+		size := fmt.Sprintf("%d,", sz.Width)
+
+		if version == iiifcompliance.Version3 {
+			size = fmt.Sprintf("%d,%d", sz.Width, sz.Height)
+		}
+
+		transformation, err := iiifimage.NewTransformation(ts.seed_level, "full", size, "0", quality, ts.Format)
+
+		if err != nil {
+			return nil, err
+		}
+
+		transformation.Quality = quality
+		crops = append(crops, transformation)
+	}
+
+	return crops, nil
+}
+
+func (ts *TileSeed) canonicalQuality() string {
+
+	quality := ts.Quality
+
+	if quality == "default" {
+		compliance := ts.level.Compliance()
+		quality, _ = compliance.DefaultQuality()
+	}
+
+	return quality
+}